@@ -1,67 +1,76 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
 	"github.com/go-chi/chi"
-	"github.com/takattila/monitor/internal/api/pkg/cpu"
-	"github.com/takattila/monitor/internal/api/pkg/handlers"
-	"github.com/takattila/monitor/internal/api/pkg/logos"
-	"github.com/takattila/monitor/internal/api/pkg/memory"
-	"github.com/takattila/monitor/internal/api/pkg/model"
-	"github.com/takattila/monitor/internal/api/pkg/network"
-	"github.com/takattila/monitor/internal/api/pkg/playground"
-	"github.com/takattila/monitor/internal/api/pkg/processes"
-	"github.com/takattila/monitor/internal/api/pkg/run"
+	"github.com/takattila/monitor/internal/api/pkg/routes"
 	"github.com/takattila/monitor/internal/api/pkg/servers"
-	"github.com/takattila/monitor/internal/api/pkg/services"
-	"github.com/takattila/monitor/internal/api/pkg/skins"
-	"github.com/takattila/monitor/internal/api/pkg/storage"
+	"github.com/takattila/monitor/internal/common/pkg/apiauth"
 	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
 	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
 )
 
-var s *settings.Settings
+var (
+	s *settings.Settings
+	l logger.Logger
+)
 
 func init() {
-	s = settings.New(common.GetConfigPath("api"))
-	s.AutoReload()
-
-	s.Data.Set("Memory", false)
-	s.Data.Set("Services", false)
-	s.Data.Set("TopProcesses", false)
-	s.Data.Set("NetworkTraffic", false)
-	s.Data.Set("Storage", false)
-
-	cpu.Cfg, handlers.Cfg, logos.Cfg, memory.Cfg, model.Cfg, network.Cfg, processes.Cfg, run.Cfg, services.Cfg, skins.Cfg, storage.Cfg = s, s, s, s, s, s, s, s, s, s, s
-
-	l := logger.New(config.GetLogLevel(s, "on_start.logger.level"), config.GetLogColor(s, "on_start.logger.color"))
-	cpu.L, handlers.L, logos.L, memory.L, model.L, network.L, playground.L, processes.L, servers.L, run.L, services.L, skins.L, storage.L = l, l, l, l, l, l, l, l, l, l, l, l, l
-
-	go services.Watcher()
-	go network.Stats()
-
-	run.Cleanup()
+	s = config.Load("api")
+	l = config.NewLogger(s)
+	routes.Configure(common.GetProgramDir(), s, l)
 }
 
 func main() {
+	// SIGTERM drains the requests, and interrupts the running jobs, before the service exits.
+	lifecycle.Start(l, time.Duration(config.GetInt(s, "on_start.shutdown_timeout_seconds"))*time.Second)
+
 	router := chi.NewRouter()
 
-	router.Get(config.GetString(s, "on_start.routes.all"), handlers.All)
-	router.Get(config.GetString(s, "on_start.routes.playground"), handlers.Playground)
-	router.Get(config.GetString(s, "on_start.routes.model"), handlers.Model)
-	router.Get(config.GetString(s, "on_start.routes.cpu"), handlers.Cpu)
-	router.Get(config.GetString(s, "on_start.routes.memory"), handlers.Memory)
-	router.Get(config.GetString(s, "on_start.routes.processes"), handlers.Process)
-	router.Get(config.GetString(s, "on_start.routes.storages"), handlers.Storages)
-	router.Get(config.GetString(s, "on_start.routes.services"), handlers.Services)
-	router.Get(config.GetString(s, "on_start.routes.network"), handlers.Network)
-	router.Get(config.GetString(s, "on_start.routes.toggle"), handlers.Toggle)
-	router.Get(config.GetString(s, "on_start.routes.run.list"), handlers.RunList)
-	router.Get(config.GetString(s, "on_start.routes.run.exec"), handlers.RunExec)
-	router.Get(config.GetString(s, "on_start.routes.run.stdout"), handlers.RunStdOut)
-	router.Get(config.GetString(s, "on_start.routes.skins"), handlers.Skins)
-	router.Get(config.GetString(s, "on_start.routes.logos"), handlers.Logos)
+	// The requests of the web service are signed with the shared secret, the others are rejected.
+	dir := common.GetProgramDir()
+	if secretFile := config.GetString(s, "on_start.api_auth.secret_file"); secretFile != "" {
+		secret, err := apiauth.LoadSecret(filepath.Join(dir, secretFile))
+		if err != nil {
+			servers.L.Fatal(err)
+		}
+		router.Use(apiauth.NewVerifier(secret).Middleware)
+	} else {
+		servers.L.Warning("The API service accepts unsigned requests, set: on_start.api_auth.secret_file")
+	}
+
+	tlsConfig, err := apiauth.ServerTLSConfig(dir,
+		config.GetString(s, "on_start.api_auth.cert_file"),
+		config.GetString(s, "on_start.api_auth.key_file"),
+		config.GetString(s, "on_start.api_auth.client_ca_file"),
+	)
+	if err != nil {
+		servers.L.Fatal(err)
+	}
+
+	routes.Register(router, s)
 
-	servers.ServeHTTP(config.GetInt(s, "on_start.port"), router)
+	// On single-host installs, the service listens only on a Unix socket, guarded by its permissions.
+	if socket := config.GetString(s, "on_start.socket.path"); socket != "" {
+		mode, err := strconv.ParseUint(config.GetString(s, "on_start.socket.mode"), 8, 32)
+		if err != nil {
+			servers.L.Fatal(fmt.Errorf("invalid socket mode: %w", err))
+		}
+		servers.ServeUnix(servers.Socket{
+			Path:  filepath.Join(dir, socket),
+			Mode:  os.FileMode(mode),
+			Owner: config.GetString(s, "on_start.socket.owner"),
+			Group: config.GetString(s, "on_start.socket.group"),
+		}, tlsConfig, router)
+		return
+	}
+	servers.ServeHTTP(config.GetString(s, "on_start.address"), config.GetInt(s, "on_start.port"), tlsConfig, router)
 }
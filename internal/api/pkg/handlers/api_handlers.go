@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
@@ -17,7 +18,6 @@ import (
 	"github.com/takattila/monitor/internal/api/pkg/services"
 	"github.com/takattila/monitor/internal/api/pkg/skins"
 	"github.com/takattila/monitor/internal/api/pkg/storage"
-	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
 )
@@ -107,17 +107,30 @@ func RunList(w http.ResponseWriter, r *http.Request) {
 }
 
 // RunExec executes a specific command by its name.
+// The parameters of the command can be passed as query parameters: /run/exec/ping?count=5
+// The query parameters, which are not declared by the command (e.g. the cache-buster: _), are ignored.
 func RunExec(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
 	L.Info("RunExec", "Request IP:", r.RemoteAddr)
-	if name != "" {
-		go func() {
-			err := run.Exec(name)
-			L.Error(err)
-			L.Warning(common.GetProgramDir())
-		}()
+
+	values := map[string]string{}
+	if entry, err := run.GetEntry(name); err == nil {
+		query := r.URL.Query()
+		for _, p := range entry.Params {
+			if _, ok := query[p.Name]; ok {
+				values[p.Name] = query.Get(p.Name)
+			}
+		}
+	}
+
+	job, err := run.Exec(name, values)
+	if err != nil {
+		L.Error(err)
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
 	}
-	fmt.Fprintf(w, `{ "started": "%s" }`, name)
+
+	writeJSON(w, http.StatusOK, map[string]string{"started": name, "id": job.ID})
 }
 
 // RunStdOut returns with the specific run's output.
@@ -128,6 +141,69 @@ func RunStdOut(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, `%s`, output)
 }
 
+// RunHistory returns with the executions of a specific command.
+func RunHistory(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	L.Info("RunHistory", "Request IP:", r.RemoteAddr)
+	fmt.Fprintf(w, "%s", run.GetHistoryJSON(name))
+}
+
+// RunJob returns with the state and the output of a specific job.
+func RunJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	L.Info("RunJob", "Request IP:", r.RemoteAddr)
+
+	job, err := run.GetJobJSON(id)
+	if err != nil {
+		L.Error(err)
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	fmt.Fprintf(w, "%s", job)
+}
+
+// RunCancel terminates a specific running job.
+func RunCancel(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	L.Info("RunCancel", "Request IP:", r.RemoteAddr)
+
+	if err := run.Cancel(id); err != nil {
+		L.Error(err)
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"cancelled": id})
+}
+
+// RunStream streams the combined output of a specific job, while it is running.
+// The final state of the job is sent in the X-Run-Status and X-Run-Exit-Code trailers.
+func RunStream(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	L.Info("RunStream", "Request IP:", r.RemoteAddr)
+
+	if _, err := run.GetJob(id); err != nil {
+		L.Error(err)
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("the job: '%s' does not exist", id)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Trailer", "X-Run-Status, X-Run-Exit-Code")
+	w.WriteHeader(http.StatusOK)
+
+	job, err := run.Follow(r.Context(), id, flushWriter{w})
+	if err != nil {
+		L.Debug("RunStream", "id:", id, "stopped:", err)
+		return
+	}
+
+	w.Header().Set("X-Run-Status", job.Status)
+	w.Header().Set("X-Run-Exit-Code", fmt.Sprint(job.ExitCode))
+}
+
 // Skins returns with a list of skins.
 func Skins(w http.ResponseWriter, r *http.Request) {
 	L.Info("Skins", "Request IP:", r.RemoteAddr)
@@ -139,3 +215,23 @@ func Logos(w http.ResponseWriter, r *http.Request) {
 	L.Info("Logos", "Request IP:", r.RemoteAddr)
 	fmt.Fprintf(w, `%s`, logos.GetJSON())
 }
+
+// writeJSON encodes v as JSON into the response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	L.Error(json.NewEncoder(w).Encode(v))
+}
+
+// flushWriter sends every write to the client immediately.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
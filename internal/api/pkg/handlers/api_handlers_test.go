@@ -9,11 +9,11 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/suite"
 	"github.com/takattila/monitor/internal/api/pkg/cpu"
-	"github.com/takattila/monitor/internal/api/pkg/logos"
 	"github.com/takattila/monitor/internal/api/pkg/memory"
 	"github.com/takattila/monitor/internal/api/pkg/model"
 	"github.com/takattila/monitor/internal/api/pkg/network"
@@ -22,7 +22,6 @@ import (
 	"github.com/takattila/monitor/internal/api/pkg/run"
 	"github.com/takattila/monitor/internal/api/pkg/servers"
 	"github.com/takattila/monitor/internal/api/pkg/services"
-	"github.com/takattila/monitor/internal/api/pkg/skins"
 	"github.com/takattila/monitor/internal/api/pkg/storage"
 	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
@@ -222,38 +221,52 @@ func (a ApiHandlersSuite) TestRunList() {
 func (a ApiHandlersSuite) TestRunExec() {
 	s := getConfig("api", "linux")
 	run.Cfg = s
-	run.Cleanup()
+
+	oldCmdFolder := run.CmdFolder
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
 
 	r := chi.NewRouter()
 	r.Get("/run/exec/{name}", RunExec)
 
 	ts := httptest.NewServer(r)
 	defer ts.Close()
-	request := request(ts, "GET", "/run/exec/get_storages", nil)
+	resp := request(ts, "GET", "/run/exec/get_storages", nil)
 
-	a.Equal(200, request.status)
-	a.Contains(request.responsebody, `"started": "get_storages"`)
+	a.Equal(200, resp.status)
+	a.Contains(resp.responsebody, `"started":"get_storages"`)
+	a.Contains(resp.responsebody, `"id":`)
+
+	waitForJobs()
+
+	// The query parameters, which are not declared by the command, are ignored, e.g. the cache-buster of jQuery.
+	resp = request(ts, "GET", "/run/exec/get_storages?_=1700000000000", nil)
+	a.Equal(200, resp.status)
+	a.Contains(resp.responsebody, `"started":"get_storages"`)
+
+	waitForJobs()
+
+	resp = request(ts, "GET", "/run/exec/not_exists", nil)
+	a.Equal(http.StatusConflict, resp.status)
+	a.Contains(resp.responsebody, "does not exist")
+
+	resp = request(ts, "GET", "/run/exec/ping?count=1000", nil)
+	a.Equal(http.StatusConflict, resp.status)
+	a.Contains(resp.responsebody, "must be at most 100")
 }
 
 func (a ApiHandlersSuite) TestRunStdOut() {
 	s := getConfig("api", "linux")
 	run.Cfg = s
-	defer func() { run.Cleanup() }()
-
-	gitRootPath := strings.ReplaceAll(common.Cli([]string{"bash", "-c", "git rev-parse --show-toplevel"}), "\n", "")
 
 	oldCmdFolder := run.CmdFolder
-	run.CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		run.CmdFolder = oldCmdFolder
-		run.Cleanup()
-	}()
-
-	run.Cleanup()
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
 
 	content := "/dev/root 125781323776 11785846784 108853583872 10% /"
-	err := createFile(run.CmdFolder+"get_storages.stdout", content)
+	job, err := run.Run(&run.Entry{Name: "get_storages", Command: "echo '" + content + "'"})
 	a.Equal(nil, err)
+	job.Wait()
 
 	r := chi.NewRouter()
 	r.Get("/run/stdout/{name}", RunStdOut)
@@ -266,16 +279,115 @@ func (a ApiHandlersSuite) TestRunStdOut() {
 	a.Contains(request.responsebody, content)
 }
 
-func (a ApiHandlersSuite) TestSkins() {
+func (a ApiHandlersSuite) TestRunHistoryAndJob() {
+	oldCmdFolder := run.CmdFolder
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
+
+	job, err := run.Run(&run.Entry{Name: "hello", Command: "echo hello"})
+	a.Equal(nil, err)
+	job.Wait()
+
+	r := chi.NewRouter()
+	r.Get("/run/history/{name}", RunHistory)
+	r.Get("/run/{id}", RunJob)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp := request(ts, "GET", "/run/history/hello", nil)
+	a.Equal(200, resp.status)
+	a.Contains(resp.responsebody, `"run_history"`)
+	a.Contains(resp.responsebody, job.ID)
+
+	resp = request(ts, "GET", "/run/"+job.ID, nil)
+	a.Equal(200, resp.status)
+	a.Contains(resp.responsebody, `"status":"finished"`)
+	a.Contains(resp.responsebody, `"output":"hello\n"`)
+
+	resp = request(ts, "GET", "/run/not_exists", nil)
+	a.Equal(http.StatusNotFound, resp.status)
+}
+
+func (a ApiHandlersSuite) TestRunStream() {
+	oldCmdFolder := run.CmdFolder
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
+
+	job, err := run.Run(&run.Entry{Name: "streamed", Command: "echo one; sleep 0.3; echo two; exit 4"})
+	a.Equal(nil, err)
+
+	r := chi.NewRouter()
+	r.Get("/run/stream/{id}", RunStream)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/run/stream/" + job.ID)
+	a.Equal(nil, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	a.Equal(nil, err)
+	a.Equal(200, res.StatusCode)
+	a.Equal("one\ntwo\n", string(body))
+	a.Equal(run.StatusFailed, res.Trailer.Get("X-Run-Status"))
+	a.Equal("4", res.Trailer.Get("X-Run-Exit-Code"))
+
+	resp := request(ts, "GET", "/run/stream/not_exists", nil)
+	a.Equal(http.StatusNotFound, resp.status)
+}
+
+func (a ApiHandlersSuite) TestRunCancel() {
 	s := getConfig("api", "linux")
 	run.Cfg = s
-	defer func() { run.Cleanup() }()
 
-	gitRootPath := strings.ReplaceAll(common.Cli([]string{"bash", "-c", "git rev-parse --show-toplevel"}), "\n", "")
+	oldCmdFolder := run.CmdFolder
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
+
+	job, err := run.Run(&run.Entry{Name: "sleeping", Command: "sleep 30"})
+	a.Equal(nil, err)
+
+	r := chi.NewRouter()
+	r.Get("/run/cancel/{id}", RunCancel)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp := request(ts, "GET", "/run/cancel/"+job.ID, nil)
+	a.Equal(200, resp.status)
+	a.Contains(resp.responsebody, `"cancelled":"`+job.ID+`"`)
+
+	job.Wait()
+	a.Equal(run.StatusCancelled, job.Status)
+
+	resp = request(ts, "GET", "/run/cancel/"+job.ID, nil)
+	a.Equal(http.StatusConflict, resp.status)
+}
+
+// waitForJobs waits until all jobs of the history are finished.
+func waitForJobs() {
+	for i := 0; i < 100; i++ {
+		jobs, _ := run.History("")
+
+		done := true
+		for _, job := range jobs {
+			if job.Running() {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
 
-	oldSkinsPath := skins.SkinsPath
-	skins.SkinsPath = gitRootPath + "/web/css"
-	defer func() { skins.SkinsPath = oldSkinsPath }()
+func (a ApiHandlersSuite) TestSkins() {
+	s := getConfig("api", "linux")
+	run.Cfg = s
+	defer func() { run.Cleanup() }()
 
 	r := chi.NewRouter()
 	r.Get("/skins", Skins)
@@ -293,12 +405,6 @@ func (a ApiHandlersSuite) TestLogos() {
 	run.Cfg = s
 	defer func() { run.Cleanup() }()
 
-	gitRootPath := strings.ReplaceAll(common.Cli([]string{"bash", "-c", "git rev-parse --show-toplevel"}), "\n", "")
-
-	oldLogosPath := logos.LogosPath
-	logos.LogosPath = gitRootPath + "/web/img"
-	defer func() { logos.LogosPath = oldLogosPath }()
-
 	r := chi.NewRouter()
 	r.Get("/logos", Logos)
 
@@ -2,10 +2,11 @@ package logos
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"io/fs"
 	"path/filepath"
 	"strings"
 
+	"github.com/takattila/monitor/internal/common/pkg/assets"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
 )
@@ -13,12 +14,15 @@ import (
 var (
 	Cfg       *settings.Settings
 	L         logger.Logger
-	LogosPath = "./web/img"
+	LogosPath = "img"
+
+	// Assets are the sources of the web interface, the embedded ones by default.
+	Assets fs.FS = assets.New("")
 )
 
 // GetJSON returns with a JSON that holds information from available logos.
 func GetJSON() string {
-	files, err := ioutil.ReadDir(LogosPath)
+	files, err := fs.ReadDir(Assets, LogosPath)
 	L.Error(err)
 
 	var logos []string
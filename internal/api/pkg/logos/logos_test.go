@@ -26,10 +26,6 @@ func (a ApiLogoSuite) TestGetJSON() {
 	Cfg = s
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	oldLogosPath := LogosPath
-	LogosPath = gitRootPath + "/web/img"
-	defer func() { LogosPath = oldLogosPath }()
-
 	JSON := GetJSON()
 	a.Contains(JSON, "logos")
 
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/shirou/gopsutil/net"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
 )
@@ -43,6 +44,8 @@ func Stats() {
 		select {
 		case <-StopStats:
 			return
+		case <-lifecycle.Stopping():
+			return
 		default:
 		}
 		sample()
@@ -0,0 +1,74 @@
+// Package routes wires the packages of the API service, and registers its routes.
+// It is shared by the API service and by the all-in-one binary.
+package routes
+
+import (
+	"path/filepath"
+
+	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/api/pkg/cpu"
+	"github.com/takattila/monitor/internal/api/pkg/handlers"
+	"github.com/takattila/monitor/internal/api/pkg/logos"
+	"github.com/takattila/monitor/internal/api/pkg/memory"
+	"github.com/takattila/monitor/internal/api/pkg/model"
+	"github.com/takattila/monitor/internal/api/pkg/network"
+	"github.com/takattila/monitor/internal/api/pkg/playground"
+	"github.com/takattila/monitor/internal/api/pkg/processes"
+	"github.com/takattila/monitor/internal/api/pkg/run"
+	"github.com/takattila/monitor/internal/api/pkg/servers"
+	"github.com/takattila/monitor/internal/api/pkg/services"
+	"github.com/takattila/monitor/internal/api/pkg/skins"
+	"github.com/takattila/monitor/internal/api/pkg/storage"
+	"github.com/takattila/monitor/internal/common/pkg/assets"
+	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
+	"github.com/takattila/monitor/pkg/logger"
+	"github.com/takattila/settings-manager"
+)
+
+// Configure sets the config and the logger of the packages, and starts the background collectors.
+// They stop at the shutdown, and the running jobs are interrupted.
+// The skins and the logos of the web sources directory under dir are listed with the embedded ones.
+func Configure(dir string, s *settings.Settings, l logger.Logger) {
+	s.Data.Set("Memory", false)
+	s.Data.Set("Services", false)
+	s.Data.Set("TopProcesses", false)
+	s.Data.Set("NetworkTraffic", false)
+	s.Data.Set("Storage", false)
+
+	cpu.Cfg, handlers.Cfg, logos.Cfg, memory.Cfg, model.Cfg, network.Cfg, processes.Cfg, run.Cfg, services.Cfg, skins.Cfg, storage.Cfg = s, s, s, s, s, s, s, s, s, s, s
+	cpu.L, handlers.L, logos.L, memory.L, model.L, network.L, playground.L, processes.L, servers.L, run.L, services.L, skins.L, storage.L = l, l, l, l, l, l, l, l, l, l, l, l, l
+
+	webAssets := assets.New(filepath.Join(dir, config.GetString(s, "on_start.web_sources_directory")))
+	skins.Assets, logos.Assets = webAssets, webAssets
+
+	go services.Watcher()
+	go network.Stats()
+
+	run.Cleanup()
+	go run.Scheduler()
+	lifecycle.OnShutdown("jobs", run.Shutdown)
+}
+
+// Register adds the routes of the API service to the router.
+func Register(router chi.Router, s *settings.Settings) {
+	router.Get(config.GetString(s, "on_start.routes.all"), handlers.All)
+	router.Get(config.GetString(s, "on_start.routes.playground"), handlers.Playground)
+	router.Get(config.GetString(s, "on_start.routes.model"), handlers.Model)
+	router.Get(config.GetString(s, "on_start.routes.cpu"), handlers.Cpu)
+	router.Get(config.GetString(s, "on_start.routes.memory"), handlers.Memory)
+	router.Get(config.GetString(s, "on_start.routes.processes"), handlers.Process)
+	router.Get(config.GetString(s, "on_start.routes.storages"), handlers.Storages)
+	router.Get(config.GetString(s, "on_start.routes.services"), handlers.Services)
+	router.Get(config.GetString(s, "on_start.routes.network"), handlers.Network)
+	router.Get(config.GetString(s, "on_start.routes.toggle"), handlers.Toggle)
+	router.Get(config.GetString(s, "on_start.routes.run.list"), handlers.RunList)
+	router.Get(config.GetString(s, "on_start.routes.run.exec"), handlers.RunExec)
+	router.Get(config.GetString(s, "on_start.routes.run.stdout"), handlers.RunStdOut)
+	router.Get(config.GetString(s, "on_start.routes.run.history"), handlers.RunHistory)
+	router.Get(config.GetString(s, "on_start.routes.run.job"), handlers.RunJob)
+	router.Get(config.GetString(s, "on_start.routes.run.cancel"), handlers.RunCancel)
+	router.Get(config.GetString(s, "on_start.routes.run.stream"), handlers.RunStream)
+	router.Get(config.GetString(s, "on_start.routes.skins"), handlers.Skins)
+	router.Get(config.GetString(s, "on_start.routes.logos"), handlers.Logos)
+}
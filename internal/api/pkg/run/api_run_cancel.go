@@ -0,0 +1,97 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// DefaultCancelGracePeriod is used, when 'on_runtime.run_cancel_grace_period' is not set.
+const DefaultCancelGracePeriod = 5 * time.Second
+
+var syscallKill = func(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+// shuttingDown is set by Shutdown, no new jobs are started after it. jobsMu must be held.
+var shuttingDown bool
+
+// Cancel terminates a running job, or removes a queued one by its ID.
+func Cancel(id string) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	job, ok := running[id]
+	if !ok {
+		return fmt.Errorf("the job: '%s' is not running", id)
+	}
+
+	L.Warning("job cancelled:", job.ID, "name:", job.Name)
+	if job.Status == StatusQueued {
+		job.dequeue(StatusCancelled)
+		return nil
+	}
+	job.terminate(StatusCancelled)
+
+	return nil
+}
+
+// Shutdown removes the queued jobs, and terminates the running ones: they are recorded as interrupted.
+// No new jobs are started after it. It returns, when the processes are exited, or the context is done.
+func Shutdown(ctx context.Context) error {
+	jobsMu.Lock()
+	shuttingDown = true
+	jobs := make([]*Job, 0, len(running))
+	for _, job := range running {
+		jobs = append(jobs, job)
+	}
+	// The queued jobs are removed first, so they are not started, when a running one exits.
+	for _, job := range jobs {
+		if job.Status == StatusQueued {
+			job.dequeue(StatusInterrupted)
+		}
+	}
+	for _, job := range jobs {
+		if job.Status == StatusRunning {
+			L.Warning("job interrupted by the shutdown:", job.ID, "name:", job.Name)
+			job.terminate(StatusInterrupted)
+		}
+	}
+	jobsMu.Unlock()
+
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// terminate sends SIGTERM to the process group of the job, and SIGKILL
+// if it is still running after the grace period. jobsMu must be held.
+func (j *Job) terminate(status string) {
+	if j.stopping != "" || j.cmd == nil || j.cmd.Process == nil {
+		return
+	}
+	j.stopping = status
+
+	pgid := j.cmd.Process.Pid
+	L.Error(syscallKill(-pgid, syscall.SIGTERM))
+
+	grace := toDuration(Cfg.Data.Get("on_runtime.run_cancel_grace_period"))
+	if grace <= 0 {
+		grace = DefaultCancelGracePeriod
+	}
+
+	go func() {
+		select {
+		case <-j.done:
+		case <-time.After(grace):
+			L.Warning("job did not stop in time, killing it:", j.ID, "name:", j.Name)
+			L.Error(syscallKill(-pgid, syscall.SIGKILL))
+		}
+	}()
+}
@@ -0,0 +1,110 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+type (
+	ApiRunCancelSuite struct {
+		suite.Suite
+	}
+)
+
+func (a ApiRunCancelSuite) TestCancel() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	// The child process must be stopped as well, otherwise Wait blocks on the open stdout.
+	job, err := Run(&Entry{Name: "sleeping", Command: "sleep 30 & sleep 30; wait"})
+	a.Equal(nil, err)
+
+	a.Equal(nil, Cancel(job.ID))
+	job.Wait()
+
+	a.Equal(StatusCancelled, job.Status)
+	a.NotNil(job.FinishedAt)
+
+	err = Cancel(job.ID)
+	a.Contains(fmt.Sprint(err), "is not running")
+}
+
+func (a ApiRunCancelSuite) TestCancelGracePeriod() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run_cancel_grace_period", "100ms")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	job, err := Run(&Entry{Name: "stubborn", Command: "trap '' TERM; echo ready; sleep 30"})
+	a.Equal(nil, err)
+
+	for i := 0; i < 50 && job.Output("output") == ""; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	start := time.Now()
+	a.Equal(nil, Cancel(job.ID))
+	job.Wait()
+
+	a.Equal(StatusCancelled, job.Status)
+	a.Less(int64(time.Since(start)), int64(5*time.Second))
+}
+
+func (a ApiRunCancelSuite) TestTimeout() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	job, err := Run(&Entry{Name: "slow", Command: "sleep 30", Timeout: 100 * time.Millisecond})
+	a.Equal(nil, err)
+	job.Wait()
+
+	a.Equal(StatusTimedOut, job.Status)
+
+	stored, err := GetJob(job.ID)
+	a.Equal(nil, err)
+	a.Equal(StatusTimedOut, stored.Status)
+}
+
+func (a ApiRunCancelSuite) TestShutdown() {
+	defer useTempCmdFolder(a.T())()
+	defer func() { shuttingDown = false }()
+
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run_max_concurrent", 1)
+	defer Cfg.Data.Set("on_runtime.run_max_concurrent", 0)
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	first, err := Run(&Entry{Name: "first", Command: "sleep 30"})
+	a.Equal(nil, err)
+	second, err := Run(&Entry{Name: "second", Command: "sleep 30"})
+	a.Equal(nil, err)
+	a.Equal(StatusQueued, second.Status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a.Equal(nil, Shutdown(ctx))
+
+	// The queued job is not started, when the running one exits.
+	a.Equal(StatusInterrupted, first.Status)
+	a.Equal(StatusInterrupted, second.Status)
+	stored, err := GetJob(second.ID)
+	a.Equal(nil, err)
+	a.Equal(StatusInterrupted, stored.Status)
+
+	_, err = Run(&Entry{Name: "third", Command: "true"})
+	a.Contains(fmt.Sprint(err), "the service is stopping")
+}
+
+func TestApiRunCancelSuite(t *testing.T) {
+	suite.Run(t, new(ApiRunCancelSuite))
+}
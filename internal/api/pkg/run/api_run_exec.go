@@ -1,111 +1,291 @@
 package run
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"strings"
-
-	"github.com/takattila/monitor/pkg/common"
+	"sync"
+	"time"
 )
 
 var (
-	osCreate = func(stdout string) (*os.File, error) {
-		return os.Create(stdout)
-	}
-	cmdStdoutPipe = func(cmd *exec.Cmd) (io.ReadCloser, error) {
-		return cmd.StdoutPipe()
+	osCreate = func(path string) (*os.File, error) {
+		return os.Create(path)
 	}
 	cmdStart = func(cmd *exec.Cmd) error {
 		return cmd.Start()
 	}
 )
 
-// Exec starts a spacific command by its name.
-func Exec(name string) (err error) {
-	cmd := GetRunByName(name)
-	err = Run(name, cmd)
-	return err
+type (
+	// outputs holds the files where the output of a job is written.
+	outputs struct {
+		stdout *os.File
+		stderr *os.File
+		output *os.File
+	}
+
+	// lockedWriter serializes writes coming from stdout and stderr
+	// into the combined output.
+	lockedWriter struct {
+		mu sync.Mutex
+		w  io.Writer
+	}
+)
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// Exec starts a spacific command by its name with the given parameter values.
+// The command runs in the background, the returned job can be used to follow it.
+func Exec(name string, values map[string]string) (*Job, error) {
+	return execute(name, values, TriggerManual)
 }
 
-// GetRunByName returns a command by its name.
-func GetRunByName(name string) string {
-	var command string
+// execute expands the parameters of a command and starts it.
+func execute(name string, values map[string]string, trigger string) (*Job, error) {
+	entry, err := GetEntry(name)
+	if err != nil {
+		return nil, err
+	}
 
-	for cmd, _ := range Cfg.Data.GetStringMapString("on_runtime.run") {
-		if cmd == name {
-			slice := Cfg.Data.GetStringSlice("on_runtime.run." + cmd)
-			command = strings.Join(slice, ` `)
-		}
+	command, params, err := entry.Expand(values)
+	if err != nil {
+		return nil, err
 	}
 
-	return command
+	expanded := *entry
+	expanded.Command = command
+	return run(&expanded, params, trigger)
 }
 
-// Run issues a specific command.
-func Run(name, command string) (err error) {
-	// time.Sleep(500 * time.Millisecond)
+// Run issues a specific command and records it as a new job.
+// Only one job can run at the same time with the same name.
+func Run(entry *Entry) (*Job, error) {
+	return run(entry, nil, TriggerManual)
+}
 
-	stdout := CmdFolder + name + ".stdout"
-	finish := CmdFolder + name + ".finish"
+// run prepares the command of the entry, the params and the trigger are recorded into the job.
+// The job is started immediately, or queued when 'on_runtime.run_max_concurrent' jobs are running.
+func run(entry *Entry, params map[string]string, trigger string) (*Job, error) {
+	cmd, err := entry.command()
+	if err != nil {
+		return nil, err
+	}
 
-	execute := func(stdout, finish, command string) (err error) {
-		cmd := exec.Command("bash", "-c", command)
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
 
-		outfile, err := osCreate(stdout)
-		if err != nil {
-			return err
-		}
-		defer outfile.Close()
+	if shuttingDown {
+		return nil, errors.New("the service is stopping, no new jobs are started")
+	}
 
-		stdoutPipe, err := cmdStdoutPipe(cmd)
-		if err != nil {
-			return err
+	if n := activeByName(entry.Name); n >= entry.maxParallel() {
+		L.Warning("the command:", entry.Name, "is running already, jobs:", n)
+		if n == 1 {
+			return nil, fmt.Errorf("the command: '%s' is running already", entry.Name)
 		}
+		return nil, fmt.Errorf("the command: '%s' is running %d times already", entry.Name, n)
+	}
 
-		writer := bufio.NewWriter(outfile)
-		defer func() {
-			_, _ = writer.WriteString("~x~o(f)o~x~")
-			writer.Flush()
-			_ = os.Rename(stdout, finish)
-		}()
+	job := &Job{
+		ID:        newJobID(),
+		Name:      entry.Name,
+		Command:   entry.Command,
+		Params:    params,
+		Trigger:   trigger,
+		Status:    StatusQueued,
+		ExitCode:  -1,
+		StartedAt: time.Now(),
+		done:      make(chan struct{}),
+		cmd:       cmd,
+		timeout:   entry.Timeout,
+	}
 
-		err = cmdStart(cmd)
-		if err != nil {
-			return err
-		}
+	out, err := openOutputs(job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	combined := &lockedWriter{w: out.output}
+	cmd.Stdout = io.MultiWriter(out.stdout, combined)
+	cmd.Stderr = io.MultiWriter(out.stderr, combined)
+	job.out = out
 
-		go io.Copy(writer, stdoutPipe)
-		cmd.Wait()
+	running[job.ID] = job
+	historyIDs()[job.Name] = append([]string{job.ID}, historyIDs()[job.Name]...)
 
-		return nil
+	if !slotAvailable() {
+		queue = append(queue, job)
+		L.Info("job queued:", job.ID, "name:", entry.Name, "position:", len(queue))
+		L.Error(job.save())
+		return job, nil
 	}
 
-	// stdout exists and finish NOT exists
-	if common.FileExists(stdout) && !common.FileExists(finish) {
-		L.Warning("stdout exists and finish NOT exists -> [ execute ] function NOT called")
-		return fmt.Errorf("the command: '%s' is running  already", command)
+	if err := job.start(); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// start starts the command of a prepared job. jobsMu must be held.
+func (j *Job) start() error {
+	j.Status = StatusRunning
+	j.StartedAt = time.Now()
+
+	if err := cmdStart(j.cmd); err != nil {
+		j.out.close()
+		j.finish(err)
+		L.Error(j.save())
+		delete(running, j.ID)
+		pruneHistory(j.Name)
+		close(j.done)
+		return err
 	}
-	// stdout exists and finish exists
-	if common.FileExists(stdout) && common.FileExists(finish) {
-		L.Warning("stdout exists and finish exists -> [ execute ] function is called")
-		_ = os.Remove(stdout)
-		_ = os.Remove(finish)
-		err = execute(stdout, finish, command)
+
+	L.Error(j.save())
+	L.Info("job started:", j.ID, "name:", j.Name)
+
+	if j.timeout > 0 {
+		j.timer = time.AfterFunc(j.timeout, func() {
+			jobsMu.Lock()
+			defer jobsMu.Unlock()
+			if _, ok := running[j.ID]; ok {
+				L.Warning("job timed out:", j.ID, "name:", j.Name, "timeout:", j.timeout)
+				j.terminate(StatusTimedOut)
+			}
+		})
 	}
-	// stdout NOT exists and finish exists
-	if !common.FileExists(stdout) && common.FileExists(finish) {
-		L.Warning("stdout NOT exists and finish exists -> [ execute ] function is called")
-		_ = os.Remove(finish)
-		err = execute(stdout, finish, command)
+
+	go j.wait()
+
+	return nil
+}
+
+// wait waits for the command to exit, then stores the final state of the job
+// and starts the next queued jobs.
+func (j *Job) wait() {
+	err := j.cmd.Wait()
+	j.out.close()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		L.Warning("job:", j.ID, "name:", j.Name, "exited, but its children still hold the output, it is not read further")
+		err = nil
 	}
-	// stdout NOT exists and finish NOT exists
-	if !common.FileExists(finish) && !common.FileExists(stdout) {
-		L.Warning("stdout NOT exists and finish NOT exists -> [ execute ] function is called")
-		err = execute(stdout, finish, command)
+
+	jobsMu.Lock()
+	if j.timer != nil {
+		j.timer.Stop()
 	}
+	j.finish(err)
+	L.Error(j.save())
+	delete(running, j.ID)
+	pruneHistory(j.Name)
+	startQueued()
+	jobsMu.Unlock()
 
-	return err
+	L.Info("job finished:", j.ID, "name:", j.Name, "status:", j.Status, "exit code:", j.ExitCode)
+	close(j.done)
+}
+
+// slotAvailable reports whether a new job can be started
+// under the 'on_runtime.run_max_concurrent' limit. jobsMu must be held.
+func slotAvailable() bool {
+	max := Cfg.Data.GetInt("on_runtime.run_max_concurrent")
+	if max <= 0 {
+		return true
+	}
+
+	n := 0
+	for _, job := range running {
+		if job.Status == StatusRunning {
+			n++
+		}
+	}
+	return n < max
+}
+
+// startQueued starts the queued jobs in order, while there are free slots. jobsMu must be held.
+func startQueued() {
+	for len(queue) > 0 && slotAvailable() {
+		job := queue[0]
+		queue = queue[1:]
+		L.Error(job.start())
+	}
+}
+
+// dequeue removes a job from the queue, it is recorded with the given status. jobsMu must be held.
+func (j *Job) dequeue(status string) {
+	for i, job := range queue {
+		if job == j {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+
+	j.out.close()
+	j.stopping = status
+	j.finish(nil)
+	L.Error(j.save())
+	delete(running, j.ID)
+	pruneHistory(j.Name)
+	close(j.done)
+}
+
+// finish sets the final state of the job based on the error returned by the command.
+func (j *Job) finish(err error) {
+	now := time.Now()
+	j.FinishedAt = &now
+
+	var exitErr *exec.ExitError
+	switch {
+	case j.stopping != "":
+		j.Status = j.stopping
+		if errors.As(err, &exitErr) {
+			j.ExitCode = exitErr.ExitCode()
+		}
+	case err == nil:
+		j.Status = StatusFinished
+		j.ExitCode = 0
+	case errors.As(err, &exitErr):
+		j.Status = StatusFailed
+		j.ExitCode = exitErr.ExitCode()
+		j.Error = err.Error()
+	default:
+		j.Status = StatusFailed
+		j.Error = err.Error()
+	}
+}
+
+// openOutputs creates the output files of a job.
+func openOutputs(id string) (*outputs, error) {
+	var err error
+	out := &outputs{}
+
+	if out.stdout, err = osCreate(jobFile(id, "stdout")); err == nil {
+		if out.stderr, err = osCreate(jobFile(id, "stderr")); err == nil {
+			out.output, err = osCreate(jobFile(id, "output"))
+		}
+	}
+
+	if err != nil {
+		out.close()
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// close closes all opened output files.
+func (o *outputs) close() {
+	for _, f := range []*os.File{o.stdout, o.stderr, o.output} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
 }
@@ -2,10 +2,10 @@ package run
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 	"github.com/takattila/monitor/pkg/logger"
@@ -18,77 +18,69 @@ type (
 )
 
 func (a ApiRunExecSuite) TestExec() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
+	defer useTempCmdFolder(a.T())()
 
 	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	Cleanup()
-
-	err := Exec("get_storages")
+	job, err := Exec("get_storages", nil)
 	a.Equal(nil, err)
+	a.NotEqual("", job.ID)
+
+	job.Wait()
+	a.Equal(StatusFinished, job.Status)
+	a.Equal(0, job.ExitCode)
+	a.NotNil(job.FinishedAt)
+	a.NotEqual("", job.Output("stdout"))
+	a.NotEqual("", job.Output("output"))
 }
 
-func (a ApiRunExecSuite) TestRunOsCreateError() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
-
-	Cleanup()
-
-	oldOsCreate := osCreate
-	osCreate = func(stdout string) (*os.File, error) {
-		return nil, fmt.Errorf("osCreate %s", "error")
-	}
-	defer func() { osCreate = oldOsCreate }()
+func (a ApiRunExecSuite) TestExecNotExists() {
+	defer useTempCmdFolder(a.T())()
 
 	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	err := Exec("get_storages")
-	a.Equal(fmt.Errorf("osCreate %s", "error"), err)
+	job, err := Exec("not_exists", nil)
+	a.Nil(job)
+	a.Contains(fmt.Sprint(err), "does not exist")
 }
 
-func (a ApiRunExecSuite) TestRunCmdStdoutPipeError() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
+func (a ApiRunExecSuite) TestRunExitCodeAndStderr() {
+	defer useTempCmdFolder(a.T())()
 
-	Cleanup()
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	job, err := Run(&Entry{Name: "failing", Command: "echo out; echo err >&2; exit 3"})
+	a.Equal(nil, err)
+
+	job.Wait()
+	a.Equal(StatusFailed, job.Status)
+	a.Equal(3, job.ExitCode)
+	a.Equal("out\n", job.Output("stdout"))
+	a.Equal("err\n", job.Output("stderr"))
+	a.Contains(job.Output("output"), "out\n")
+	a.Contains(job.Output("output"), "err\n")
+}
 
-	oldCmdStdoutPipe := cmdStdoutPipe
-	cmdStdoutPipe = func(cmd *exec.Cmd) (io.ReadCloser, error) {
-		return nil, fmt.Errorf("cmdStdoutPipe %s", "error")
+func (a ApiRunExecSuite) TestRunOsCreateError() {
+	defer useTempCmdFolder(a.T())()
+
+	oldOsCreate := osCreate
+	osCreate = func(stdout string) (*os.File, error) {
+		return nil, fmt.Errorf("osCreate %s", "error")
 	}
-	defer func() { cmdStdoutPipe = oldCmdStdoutPipe }()
+	defer func() { osCreate = oldOsCreate }()
 
 	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	err := Exec("get_storages")
-	a.Equal(fmt.Errorf("cmdStdoutPipe %s", "error"), err)
+	_, err := Exec("get_storages", nil)
+	a.Equal(fmt.Errorf("osCreate %s", "error"), err)
 }
 
 func (a ApiRunExecSuite) TestRunCmdStartError() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
-
-	Cleanup()
+	defer useTempCmdFolder(a.T())()
 
 	oldCmdStart := cmdStart
 	cmdStart = func(cmd *exec.Cmd) error {
@@ -99,60 +91,75 @@ func (a ApiRunExecSuite) TestRunCmdStartError() {
 	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	err := Exec("get_storages")
+	_, err := Exec("get_storages", nil)
 	a.Equal(fmt.Errorf("cmdStart %s", "error"), err)
+
+	jobs, err := History("get_storages")
+	a.Equal(nil, err)
+	a.Equal(1, len(jobs))
+	a.Equal(StatusFailed, jobs[0].Status)
+	a.Equal("cmdStart error", jobs[0].Error)
 }
 
-func (a ApiRunExecSuite) TestRunStdoutExistsAndFinishNotExistsError() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
+func (a ApiRunExecSuite) TestRunIsRunningAlready() {
+	defer useTempCmdFolder(a.T())()
 
-	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	Cleanup()
-
-	err := createFile(CmdFolder + "get_storages.stdout")
+	job, err := Run(&Entry{Name: "sleeping", Command: "sleep 0.5"})
 	a.Equal(nil, err)
 
-	err = Exec("get_storages")
-	a.Contains(fmt.Sprint(err), "is running  already")
+	_, err = Run(&Entry{Name: "sleeping", Command: "sleep 0.5"})
+	a.Contains(fmt.Sprint(err), "is running already")
+
+	job.Wait()
+
+	job, err = Run(&Entry{Name: "sleeping", Command: "true"})
+	a.Equal(nil, err)
+	job.Wait()
 }
 
-func (a ApiRunExecSuite) TestRunStdoutExistsAndFinishExistsError() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
+func (a ApiRunExecSuite) TestRunBackgroundChild() {
+	defer useTempCmdFolder(a.T())()
 
 	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	Cleanup()
+	oldOutputWaitDelay := outputWaitDelay
+	defer func() { outputWaitDelay = oldOutputWaitDelay }()
+	outputWaitDelay = 100 * time.Millisecond
 
-	err := createFile(CmdFolder + "get_storages.stdout")
+	// The child keeps the output open, after the shell exited.
+	start := time.Now()
+	job, err := Run(&Entry{Name: "daemon", Command: "sleep 10 & echo started"})
 	a.Equal(nil, err)
+	job.Wait()
 
-	err = createFile(CmdFolder + "get_storages.finish")
-	a.Equal(nil, err)
-
-	err = Exec("get_storages")
-	a.Equal(nil, err)
+	a.Less(time.Since(start), 5*time.Second)
+	a.Equal(StatusFinished, job.Status)
+	a.Equal("started\n", job.Output("stdout"))
 }
 
-func createFile(path string) error {
-	f, err := os.Create(path)
-	if err != nil {
-		return err
+func (a ApiRunExecSuite) TestRunPrunesHistory() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run_history_limit", 2)
+	defer Cfg.Data.Set("on_runtime.run_history_limit", DefaultHistoryLimit)
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	for i := 0; i < 4; i++ {
+		job, err := Run(&Entry{Name: "echo", Command: fmt.Sprintf("echo %d", i)})
+		a.Equal(nil, err)
+		job.Wait()
 	}
-	defer f.Close()
-	return nil
+
+	// The history is pruned without a restart.
+	jobs, err := History("echo")
+	a.Equal(nil, err)
+	a.Equal(2, len(jobs))
+	a.Equal("echo 3", jobs[0].Command)
+	a.Equal("3\n", StdOut("echo"))
 }
 
 func TestApiRunExecSuite(t *testing.T) {
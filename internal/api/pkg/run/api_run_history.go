@@ -0,0 +1,51 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// jobDetails extends a job with its outputs.
+type jobDetails struct {
+	*Job
+	Output string `json:"output"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// GetHistoryJSON provides a JSON representation of the executions of a specific command.
+func GetHistoryJSON(name string) string {
+	jobs, err := History(name)
+	if err != nil {
+		L.Error(err)
+		jobs = []*Job{}
+	}
+
+	b, err := json.Marshal(map[string][]*Job{"run_history": jobs})
+	if err != nil {
+		L.Error(err)
+		return `{ "run_history": [] }`
+	}
+
+	return string(b)
+}
+
+// GetJobJSON provides a JSON representation of a specific job together with its outputs.
+func GetJobJSON(id string) (string, error) {
+	job, err := GetJob(id)
+	if err != nil {
+		return "", fmt.Errorf("the job: '%s' does not exist", id)
+	}
+
+	b, err := json.Marshal(jobDetails{
+		Job:    job,
+		Output: job.Output("output"),
+		Stdout: job.Output("stdout"),
+		Stderr: job.Output("stderr"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
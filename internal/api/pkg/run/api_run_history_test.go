@@ -0,0 +1,83 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+type (
+	ApiRunHistorySuite struct {
+		suite.Suite
+	}
+)
+
+func (a ApiRunHistorySuite) TestGetHistoryJSON() {
+	defer useTempCmdFolder(a.T())()
+
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	for i := 0; i < 3; i++ {
+		job, err := Run(&Entry{Name: "echo", Command: fmt.Sprintf("echo %d", i)})
+		a.Equal(nil, err)
+		job.Wait()
+	}
+	job, err := Run(&Entry{Name: "other", Command: "true"})
+	a.Equal(nil, err)
+	job.Wait()
+
+	history := struct {
+		RunHistory []Job `json:"run_history"`
+	}{}
+	a.Equal(nil, json.Unmarshal([]byte(GetHistoryJSON("echo")), &history))
+	a.Equal(3, len(history.RunHistory))
+	a.Equal("echo 2", history.RunHistory[0].Command)
+	a.Equal("echo 0", history.RunHistory[2].Command)
+}
+
+func (a ApiRunHistorySuite) TestGetHistoryJSONEmpty() {
+	defer useTempCmdFolder(a.T())()
+
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	a.Equal(`{"run_history":[]}`, GetHistoryJSON("echo"))
+}
+
+func (a ApiRunHistorySuite) TestGetJobJSON() {
+	defer useTempCmdFolder(a.T())()
+
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	job, err := Run(&Entry{Name: "echo", Command: "echo hello"})
+	a.Equal(nil, err)
+	job.Wait()
+
+	JSON, err := GetJobJSON(job.ID)
+	a.Equal(nil, err)
+
+	details := map[string]interface{}{}
+	a.Equal(nil, json.Unmarshal([]byte(JSON), &details))
+	a.Equal(job.ID, details["id"])
+	a.Equal(StatusFinished, details["status"])
+	a.Equal(float64(0), details["exit_code"])
+	a.Equal("hello\n", details["stdout"])
+	a.Equal("hello\n", details["output"])
+	a.Equal("", details["stderr"])
+}
+
+func (a ApiRunHistorySuite) TestGetJobJSONNotExists() {
+	defer useTempCmdFolder(a.T())()
+
+	_, err := GetJobJSON("not_exists")
+	a.Contains(fmt.Sprint(err), "does not exist")
+
+	_, err = GetJobJSON("../../etc/passwd")
+	a.Contains(fmt.Sprint(err), "does not exist")
+}
+
+func TestApiRunHistorySuite(t *testing.T) {
+	suite.Run(t, new(ApiRunHistorySuite))
+}
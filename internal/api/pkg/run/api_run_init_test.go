@@ -27,6 +27,14 @@ func getConfig(service, system string) *settings.Settings {
 	return s
 }
 
+// useTempCmdFolder points CmdFolder to a temporary directory,
+// the returned function restores the original one.
+func useTempCmdFolder(t *testing.T) func() {
+	oldCmdFolder := CmdFolder
+	CmdFolder = t.TempDir() + "/"
+	return func() { CmdFolder = oldCmdFolder }
+}
+
 func TestApiRunInitSuite(t *testing.T) {
 	suite.Run(t, new(ApiRunInitSuite))
 }
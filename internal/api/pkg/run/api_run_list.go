@@ -3,30 +3,52 @@ package run
 import (
 	"encoding/json"
 	"fmt"
-	"sort"
-	"strings"
+	"time"
 )
 
-// GetJSON provides a JSON representation of the list of existing commands that can be run.
+// listItem is the representation of a command in the run list.
+type listItem struct {
+	Command  string     `json:"command"`
+	Timeout  string     `json:"timeout,omitempty"`
+	Schedule string     `json:"schedule,omitempty"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	Params   []Param    `json:"params"`
+}
+
+// GetJSON provides a JSON representation of the list of existing commands that can be run,
+// together with the schema of their parameters and the time of their next and last scheduled run.
 func GetJSON() string {
-	var commands []string
+	commands := map[string]listItem{}
 
-	for command, _ := range Cfg.Data.GetStringMapString("on_runtime.run") {
-		slice := Cfg.Data.GetStringSlice("on_runtime.run." + command)
-		item := strings.Join(slice, ` `)
-		b, _ := json.Marshal(item)
-		commands = append(commands, `"`+command+`":`+string(b))
-	}
+	for _, name := range Entries() {
+		entry, err := GetEntry(name)
+		if err != nil {
+			L.Error(err)
+			continue
+		}
 
-	sort.Strings(commands)
-	var ret string
+		item := listItem{Command: entry.Command, Schedule: entry.Schedule, Params: entry.Params}
+		item.NextRun, item.LastRun = NextRun(entry)
+		if item.Params == nil {
+			item.Params = []Param{}
+		}
+		if entry.Timeout > 0 {
+			item.Timeout = entry.Timeout.String()
+		}
+		commands[name] = item
+	}
 
-	if len(commands) > 0 {
-		ret = `{ "run_list": {` + strings.Join(commands, ",") + `} }`
-	} else {
-		ret = `{ "run_list": {} }`
+	if len(commands) == 0 {
 		L.Error(fmt.Errorf("the lenght of the 'on_runtime.run' is: %d", len(commands)))
+		return `{ "run_list": {} }`
+	}
+
+	b, err := json.Marshal(map[string]interface{}{"run_list": commands})
+	if err != nil {
+		L.Error(err)
+		return `{ "run_list": {} }`
 	}
 
-	return ret
+	return string(b)
 }
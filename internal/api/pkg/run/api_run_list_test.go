@@ -19,6 +19,8 @@ func (a ApiRunListSuite) TestGetJSON() {
 
 	JSON := GetJSON()
 	a.Contains(JSON, "run_list")
+	a.Contains(JSON, `"ping":{"command":"ping -c {count} {host}","timeout":"2m0s","params":[{"name":"count","type":"int"`)
+	a.Contains(JSON, `"services":{"command":"systemctl list-units --type=service","params":[]}`)
 }
 
 func (a ApiRunListSuite) TestGetJSONWithoutRuns() {
@@ -1,27 +1,20 @@
 package run
 
-import (
-	"os"
+// StdOut provides the output of the latest execution of a specific command.
+func StdOut(name string) string {
+	jobsMu.Lock()
+	ids := historyIDs()[name]
+	jobsMu.Unlock()
 
-	"github.com/takattila/monitor/pkg/common"
-)
-
-// StdOut provides a specific command output.
-func StdOut(name string) (content string) {
-	stdout := CmdFolder + name + ".stdout"
-	finish := CmdFolder + name + ".finish"
-
-	if common.FileExists(stdout) {
-		if b, err := os.ReadFile(stdout); err == nil {
-			content = string(b)
-		}
+	if len(ids) == 0 {
+		return ""
 	}
 
-	if common.FileExists(finish) {
-		if b, err := os.ReadFile(finish); err == nil {
-			content = string(b)
-		}
+	job, err := GetJob(ids[0])
+	if err != nil {
+		L.Error(err)
+		return ""
 	}
 
-	return string(content)
+	return job.Output("output")
 }
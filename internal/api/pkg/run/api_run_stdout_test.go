@@ -15,44 +15,41 @@ type (
 )
 
 func (a ApiRunStdOutSuite) TestStdOutFinished() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
-
-	Cleanup()
+	defer useTempCmdFolder(a.T())()
 
 	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	err := Exec("get_storages")
+	job, err := Exec("get_storages", nil)
 	a.Equal(nil, err)
+	job.Wait()
 
 	content := StdOut("get_storages")
 	a.NotEqual("", content)
 }
 
 func (a ApiRunStdOutSuite) TestStdOutRunning() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
+	defer useTempCmdFolder(a.T())()
 
-	Cleanup()
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	go func() {
-		err := Exec("ping_10_localhost")
-		a.Equal(nil, err)
-	}()
+	job, err := Run(&Entry{Name: "counter", Command: "for i in 1 2 3; do echo $i; sleep 0.2; done"})
+	a.Equal(nil, err)
+	defer job.Wait()
 
-	time.Sleep(600 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
 
-	content := StdOut("ping_10_localhost")
-	a.NotEqual("", content)
+	content := StdOut("counter")
+	a.Equal("1\n", content)
+}
+
+func (a ApiRunStdOutSuite) TestStdOutNeverRun() {
+	defer useTempCmdFolder(a.T())()
+
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	a.Equal("", StdOut("get_storages"))
 }
 
 func TestApiRunStdOutSuite(t *testing.T) {
@@ -0,0 +1,49 @@
+package run
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// FollowInterval is the time between two checks for new output, while a job is running.
+var FollowInterval = 200 * time.Millisecond
+
+// Follow writes the combined output of a job into w as it is written,
+// until the job is finished or the context is done. The output is copied as is,
+// so ANSI escape sequences are preserved. It returns the final state of the job.
+func Follow(ctx context.Context, id string, w io.Writer) (*Job, error) {
+	job, err := GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(jobFile(job.ID, "output"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	for {
+		// The state is read before the output: when the job is finished,
+		// its output files are already closed, so the rest can be read completely.
+		if job, err = GetJob(id); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.Copy(w, f); err != nil {
+			return nil, err
+		}
+
+		if !job.Running() {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(FollowInterval):
+		}
+	}
+}
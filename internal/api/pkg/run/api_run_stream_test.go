@@ -0,0 +1,66 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+type (
+	ApiRunStreamSuite struct {
+		suite.Suite
+	}
+)
+
+func (a ApiRunStreamSuite) TestFollow() {
+	defer useTempCmdFolder(a.T())()
+
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	job, err := Run(&Entry{Name: "colored", Command: `echo first; sleep 0.5; printf '\033[31mred\033[0m\n'; exit 2`})
+	a.Equal(nil, err)
+
+	buf := &bytes.Buffer{}
+	final, err := Follow(context.Background(), job.ID, buf)
+	a.Equal(nil, err)
+	a.Equal(StatusFailed, final.Status)
+	a.Equal(2, final.ExitCode)
+	a.Equal("first\n\033[31mred\033[0m\n", buf.String())
+}
+
+func (a ApiRunStreamSuite) TestFollowContextDone() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	job, err := Run(&Entry{Name: "endless", Command: "sleep 30"})
+	a.Equal(nil, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	final, err := Follow(ctx, job.ID, &bytes.Buffer{})
+	a.Nil(final)
+	a.Equal(context.DeadlineExceeded, err)
+
+	a.Equal(nil, Cancel(job.ID))
+	job.Wait()
+}
+
+func (a ApiRunStreamSuite) TestFollowNotExists() {
+	defer useTempCmdFolder(a.T())()
+
+	final, err := Follow(context.Background(), "not_exists", &bytes.Buffer{})
+	a.Nil(final)
+	a.Contains(fmt.Sprint(err), "no such file")
+}
+
+func TestApiRunStreamSuite(t *testing.T) {
+	suite.Run(t, new(ApiRunStreamSuite))
+}
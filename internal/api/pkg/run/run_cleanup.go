@@ -2,35 +2,75 @@ package run
 
 import (
 	"os"
-	"path/filepath"
+	"time"
 )
 
-// Cleanup removes all temporary files: *.stdout, *.finish
+// DefaultHistoryLimit is used, when 'on_runtime.run_history_limit' is not set.
+const DefaultHistoryLimit = 20
+
+// Cleanup should be called when the service starts.
+// The jobs which were running when the service stopped are marked as interrupted,
+// and the history of each command is truncated to 'on_runtime.run_history_limit' entries.
 func Cleanup() {
-	deleteFilesByExtension("stdout")
-	deleteFilesByExtension("finish")
-}
+	jobs, err := History("")
+	if err != nil {
+		L.Error(err)
+		return
+	}
 
-// deleteFilesByExtension removes all files under the ./cmd directory by extension.
-func deleteFilesByExtension(ext string) {
-	ext = "." + ext
+	for _, job := range jobs {
+		if job.Running() && !isActive(job.ID) {
+			markInterrupted(job)
+		}
+	}
 
-	d, err := os.Open(CmdFolder)
-	L.Error(err)
-	defer d.Close()
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
 
-	files, err := d.Readdir(-1)
-	L.Error(err)
+	for name := range historyIDs() {
+		pruneHistory(name)
+	}
+}
 
-	L.Info("reading", CmdFolder)
+// pruneHistory truncates the history of a command to 'on_runtime.run_history_limit' entries,
+// the queued and running jobs are kept. It is called, when a job of the command is finished. jobsMu must be held.
+func pruneHistory(name string) {
+	limit := Cfg.Data.GetInt("on_runtime.run_history_limit")
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
 
-	for _, file := range files {
-		if file.Mode().IsRegular() {
-			if filepath.Ext(file.Name()) == ext {
-				filepath := CmdFolder + file.Name()
-				_ = os.Remove(filepath)
-				L.Info("deleted", filepath)
-			}
+	ids := historyIDs()
+	kept := make([]string, 0, limit)
+	for i, id := range ids[name] {
+		if _, active := running[id]; i < limit || active {
+			kept = append(kept, id)
+			continue
 		}
+		removeJob(id)
+		L.Info("deleted job:", id, "name:", name)
 	}
+	ids[name] = kept
+}
+
+// isActive reports whether a job is queued or running in this process.
+func isActive(id string) bool {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	_, ok := running[id]
+	return ok
+}
+
+// markInterrupted closes a job which was left in running state.
+func markInterrupted(job *Job) {
+	finished := time.Now()
+	if info, err := os.Stat(jobFile(job.ID, "output")); err == nil {
+		finished = info.ModTime()
+	}
+
+	job.Status = StatusInterrupted
+	job.FinishedAt = &finished
+	L.Warning("job interrupted:", job.ID, "name:", job.Name)
+	L.Error(job.save())
 }
@@ -1,8 +1,7 @@
 package run
 
 import (
-	"os"
-	"path/filepath"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -15,57 +14,62 @@ type (
 	}
 )
 
-func (a ApiRunCleanupSuite) TestCleanup() {
-	oldCmdFolder := CmdFolder
-	CmdFolder = gitRootPath + "/cmd/"
-	defer func() {
-		Cleanup()
-		CmdFolder = oldCmdFolder
-	}()
+func (a ApiRunCleanupSuite) TestCleanupKeepsHistory() {
+	defer useTempCmdFolder(a.T())()
 
 	Cfg = getConfig("api", "linux")
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	err := Exec("get_storages")
+	job, err := Exec("get_storages", nil)
 	a.Equal(nil, err)
-
-	content := StdOut("get_storages")
-	a.NotEqual("", content)
+	job.Wait()
 
 	Cleanup()
 
-	check, err := checkFiletypesInDir("stdout")
-	a.Equal(nil, err)
-	a.Equal(false, check)
+	a.NotEqual("", StdOut("get_storages"))
 
-	check, err = checkFiletypesInDir("finish")
+	stored, err := GetJob(job.ID)
 	a.Equal(nil, err)
-	a.Equal(false, check)
+	a.Equal(StatusFinished, stored.Status)
+}
+
+func (a ApiRunCleanupSuite) TestCleanupMarksInterrupted() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	job := &Job{ID: newJobID(), Name: "orphan", Status: StatusRunning}
+	a.Equal(nil, job.save())
+
+	Cleanup()
 
+	stored, err := GetJob(job.ID)
+	a.Equal(nil, err)
+	a.Equal(StatusInterrupted, stored.Status)
+	a.NotNil(stored.FinishedAt)
 }
 
-func checkFiletypesInDir(ext string) (bool, error) {
-	ext = "." + ext
+func (a ApiRunCleanupSuite) TestCleanupHistoryLimit() {
+	defer useTempCmdFolder(a.T())()
 
-	d, err := os.Open(CmdFolder)
-	if err != nil {
-		return false, err
-	}
-	defer d.Close()
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run_history_limit", 2)
+	defer Cfg.Data.Set("on_runtime.run_history_limit", DefaultHistoryLimit)
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	files, err := d.Readdir(-1)
-	if err != nil {
-		return false, err
+	for i := 0; i < 4; i++ {
+		job, err := Run(&Entry{Name: "echo", Command: fmt.Sprintf("echo %d", i)})
+		a.Equal(nil, err)
+		job.Wait()
 	}
 
-	for _, file := range files {
-		if file.Mode().IsRegular() {
-			if filepath.Ext(file.Name()) == ext {
-				return true, nil
-			}
-		}
-	}
-	return false, nil
+	Cleanup()
+
+	jobs, err := History("echo")
+	a.Equal(nil, err)
+	a.Equal(2, len(jobs))
+	a.Equal("echo 3", jobs[0].Command)
 }
 
 func TestApiRunCleanupSuite(t *testing.T) {
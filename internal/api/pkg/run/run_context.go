@@ -0,0 +1,144 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultShell runs the commands, when the entry has no 'shell' set.
+const DefaultShell = "bash"
+
+var (
+	environ     = os.Environ
+	geteuid     = os.Geteuid
+	getegid     = os.Getegid
+	userLookup  = user.Lookup
+	groupLookup = user.LookupGroup
+
+	envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// outputWaitDelay limits how long the output is read after the command exited.
+	// The background children of the command (e.g. 'daemon &') inherit the output, they do not block the job.
+	outputWaitDelay = 5 * time.Second
+)
+
+// parseEnv reads the environment variables of a run entry: a list of 'NAME=value' items.
+// A list is used instead of a map, because the keys of the maps are lowercased by the config reader.
+func parseEnv(value interface{}) ([]string, error) {
+	list, ok := value.([]interface{})
+	if value != nil && !ok {
+		return nil, fmt.Errorf("env must be a list of NAME=value items")
+	}
+
+	env := make([]string, 0, len(list))
+	for _, item := range list {
+		kv := toString(item)
+		name := strings.SplitN(kv, "=", 2)[0]
+		if !strings.Contains(kv, "=") || !envNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid environment variable: '%s'", kv)
+		}
+		env = append(env, kv)
+	}
+
+	return env, nil
+}
+
+// command prepares the process of the entry: the shell, the working directory,
+// the environment and the user and group, which the command runs as.
+func (e *Entry) command() (*exec.Cmd, error) {
+	shell := e.Shell
+	if shell == "" {
+		shell = DefaultShell
+	}
+
+	cred, u, err := e.credential()
+	if err != nil {
+		return nil, fmt.Errorf("the command: '%s': %w", e.Name, err)
+	}
+
+	cmd := exec.Command(shell, "-c", e.Command)
+	cmd.Dir = e.Cwd
+	cmd.WaitDelay = outputWaitDelay
+	cmd.Env = environ()
+	if u != nil {
+		cmd.Env = setEnv(cmd.Env, "HOME", u.HomeDir)
+		cmd.Env = setEnv(cmd.Env, "USER", u.Username)
+		cmd.Env = setEnv(cmd.Env, "LOGNAME", u.Username)
+	}
+	for _, kv := range e.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		cmd.Env = setEnv(cmd.Env, parts[0], parts[1])
+	}
+
+	// The command gets its own process group, so it can be terminated together with its children.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+
+	return cmd, nil
+}
+
+// credential returns the process credential of the 'user' and 'group' of the entry,
+// and the user entry, if it is set. It returns nil, when no identity change is needed.
+func (e *Entry) credential() (*syscall.Credential, *user.User, error) {
+	if e.User == "" && e.Group == "" {
+		return nil, nil, nil
+	}
+
+	cred := &syscall.Credential{Uid: uint32(geteuid()), Gid: uint32(getegid())}
+
+	var u *user.User
+	if e.User != "" {
+		var err error
+		if u, err = userLookup(e.User); err != nil {
+			return nil, nil, fmt.Errorf("unknown user: '%s'", e.User)
+		}
+
+		uid, errUID := strconv.ParseUint(u.Uid, 10, 32)
+		gid, errGID := strconv.ParseUint(u.Gid, 10, 32)
+		if errUID != nil || errGID != nil {
+			return nil, nil, fmt.Errorf("invalid user: '%s'", e.User)
+		}
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+
+		if groupIDs, err := u.GroupIds(); err == nil {
+			for _, id := range groupIDs {
+				if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+					cred.Groups = append(cred.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+
+	if e.Group != "" {
+		g, err := groupLookup(e.Group)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unknown group: '%s'", e.Group)
+		}
+
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid group: '%s'", e.Group)
+		}
+		cred.Gid = uint32(gid)
+	}
+
+	return cred, u, nil
+}
+
+// setEnv sets the given key/value pair in the environment, replacing any
+// previous entry with the same key.
+func setEnv(env []string, key, value string) []string {
+	out := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			out = append(out, kv)
+		}
+	}
+	return append(out, key+"="+value)
+}
@@ -0,0 +1,180 @@
+package run
+
+import (
+	"fmt"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+type (
+	ApiRunContextSuite struct {
+		suite.Suite
+	}
+)
+
+func (a ApiRunContextSuite) TestGetEntryContext() {
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	Cfg.Data.Set("on_runtime.run.context", map[string]interface{}{
+		"command":      "id",
+		"user":         "nobody",
+		"group":        "nogroup",
+		"cwd":          "/tmp",
+		"env":          []interface{}{"LANG=C", "EMPTY="},
+		"shell":        "sh",
+		"max_parallel": 3,
+	})
+
+	entry, err := GetEntry("context")
+	a.Equal(nil, err)
+	a.Equal("nobody", entry.User)
+	a.Equal("nogroup", entry.Group)
+	a.Equal("/tmp", entry.Cwd)
+	a.Equal([]string{"LANG=C", "EMPTY="}, entry.Env)
+	a.Equal("sh", entry.Shell)
+	a.Equal(3, entry.maxParallel())
+
+	Cfg.Data.Set("on_runtime.run.bad_env", map[string]interface{}{"command": "id", "env": []interface{}{"1X=y"}})
+	_, err = GetEntry("bad_env")
+	a.Contains(fmt.Sprint(err), "invalid environment variable: '1X=y'")
+}
+
+func (a ApiRunContextSuite) TestCommand() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	dir := a.T().TempDir()
+	job, err := Run(&Entry{Name: "context", Command: `pwd; echo "$FOO"; echo "$0"`, Cwd: dir, Env: []string{"FOO=bar=baz"}, Shell: "sh"})
+	a.Equal(nil, err)
+	job.Wait()
+
+	a.Equal(StatusFinished, job.Status)
+	a.Equal(dir+"\nbar=baz\nsh\n", job.Output("stdout"))
+}
+
+func (a ApiRunContextSuite) TestCredential() {
+	entry := &Entry{Name: "context", User: "not_exists"}
+	_, _, err := entry.credential()
+	a.Contains(fmt.Sprint(err), "unknown user: 'not_exists'")
+
+	entry = &Entry{Name: "context", Group: "not_exists"}
+	_, _, err = entry.credential()
+	a.Contains(fmt.Sprint(err), "unknown group: 'not_exists'")
+
+	oldUserLookup, oldGroupLookup := userLookup, groupLookup
+	defer func() { userLookup, groupLookup = oldUserLookup, oldGroupLookup }()
+	userLookup = func(name string) (*user.User, error) {
+		return &user.User{Uid: "1234", Gid: "1235", Username: name, HomeDir: "/home/" + name}, nil
+	}
+	groupLookup = func(name string) (*user.Group, error) {
+		return &user.Group{Gid: "2000", Name: name}, nil
+	}
+
+	entry = &Entry{Name: "context", User: "tester"}
+	cred, u, err := entry.credential()
+	a.Equal(nil, err)
+	a.Equal(uint32(1234), cred.Uid)
+	a.Equal(uint32(1235), cred.Gid)
+	a.Equal("tester", u.Username)
+
+	entry = &Entry{Name: "context", User: "tester", Group: "testers"}
+	cred, _, err = entry.credential()
+	a.Equal(nil, err)
+	a.Equal(uint32(1234), cred.Uid)
+	a.Equal(uint32(2000), cred.Gid)
+
+	cmd, err := (&Entry{Name: "context", Command: "id", User: "tester"}).command()
+	a.Equal(nil, err)
+	a.Contains(cmd.Env, "HOME=/home/tester")
+	a.Contains(cmd.Env, "USER=tester")
+	a.True(cmd.SysProcAttr.Setpgid)
+
+	cred, u, err = (&Entry{Name: "context"}).credential()
+	a.Equal(nil, err)
+	a.Nil(cred)
+	a.Nil(u)
+}
+
+func (a ApiRunContextSuite) TestRunAsUser() {
+	if geteuid() != 0 {
+		a.T().Skip("changing the user requires root")
+	}
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		a.T().Skip("no 'nobody' user")
+	}
+
+	job, err := Run(&Entry{Name: "as_nobody", Command: "id -u", User: "nobody", Cwd: "/"})
+	a.Equal(nil, err)
+	job.Wait()
+
+	a.Equal(StatusFinished, job.Status)
+	a.Equal(nobody.Uid+"\n", job.Output("stdout"))
+}
+
+func (a ApiRunContextSuite) TestMaxParallel() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	entry := &Entry{Name: "parallel", Command: "sleep 30", MaxParallel: 2}
+	first, err := Run(entry)
+	a.Equal(nil, err)
+	second, err := Run(entry)
+	a.Equal(nil, err)
+
+	_, err = Run(entry)
+	a.Contains(fmt.Sprint(err), "the command: 'parallel' is running 2 times already")
+
+	a.Equal(nil, Cancel(first.ID))
+	a.Equal(nil, Cancel(second.ID))
+	first.Wait()
+	second.Wait()
+}
+
+func (a ApiRunContextSuite) TestQueue() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run_max_concurrent", 1)
+	defer Cfg.Data.Set("on_runtime.run_max_concurrent", 0)
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	first, err := Run(&Entry{Name: "first", Command: "sleep 0.3; echo first"})
+	a.Equal(nil, err)
+	second, err := Run(&Entry{Name: "second", Command: "echo second"})
+	a.Equal(nil, err)
+	third, err := Run(&Entry{Name: "third", Command: "echo third"})
+	a.Equal(nil, err)
+
+	job, err := GetJob(second.ID)
+	a.Equal(nil, err)
+	a.Equal(StatusQueued, job.Status)
+	a.True(job.Running())
+
+	a.Equal(nil, Cancel(third.ID))
+	third.Wait()
+	a.Equal(StatusCancelled, third.Status)
+	a.Equal("", third.Output("output"))
+
+	second.Wait()
+	a.Equal(StatusFinished, second.Status)
+	a.Equal("second\n", second.Output("output"))
+	a.False(second.StartedAt.Before(*first.FinishedAt))
+}
+
+func TestApiRunContextSuite(t *testing.T) {
+	suite.Run(t, new(ApiRunContextSuite))
+}
@@ -0,0 +1,159 @@
+package run
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry describes a command defined under 'on_runtime.run'.
+//
+// An entry can be a list of strings, which are joined into the command:
+//
+//	services:
+//	  - systemctl list-units --type=service
+//
+// or a map with more options:
+//
+//	ping:
+//	  command: ping -c {count} {host}
+//	  timeout: 2m
+//	  schedule: "*/30 * * * *"
+//	  user: nobody
+//	  group: nogroup
+//	  cwd: /tmp
+//	  env:
+//	    - LANG=C
+//	  shell: dash
+//	  max_parallel: 1
+//	  params: ...
+//
+// The parameters of the command are described by Param,
+// the schedule is a standard cron expression, see Scheduler.
+// The command is run by the shell with '-c' as the user and group,
+// in the working directory and with the extra environment variables of the entry.
+type Entry struct {
+	Name        string
+	Command     string
+	Timeout     time.Duration
+	Schedule    string
+	User        string
+	Group       string
+	Cwd         string
+	Env         []string
+	Shell       string
+	MaxParallel int
+	Params      []Param
+}
+
+// GetEntry returns a command definition by its name.
+func GetEntry(name string) (*Entry, error) {
+	key := "on_runtime.run." + name
+	if name == "" || strings.Contains(name, ".") || !Cfg.Data.IsSet(key) {
+		return nil, fmt.Errorf("the command: '%s' does not exist", name)
+	}
+
+	entry := &Entry{Name: name}
+
+	switch value := Cfg.Data.Get(key).(type) {
+	case map[string]interface{}:
+		entry.Command = joinCommand(value["command"])
+		entry.Timeout = toDuration(value["timeout"])
+		entry.Schedule = strings.TrimSpace(toString(value["schedule"]))
+
+		if entry.Schedule != "" {
+			if _, err := parseSchedule(entry.Schedule); err != nil {
+				return nil, fmt.Errorf("the command: '%s': invalid schedule: %w", name, err)
+			}
+		}
+
+		entry.User = toString(value["user"])
+		entry.Group = toString(value["group"])
+		entry.Cwd = toString(value["cwd"])
+		entry.Shell = toString(value["shell"])
+		if n := toIntPtr(value["max_parallel"]); n != nil {
+			entry.MaxParallel = *n
+		}
+
+		env, err := parseEnv(value["env"])
+		if err != nil {
+			return nil, fmt.Errorf("the command: '%s': %w", name, err)
+		}
+		entry.Env = env
+
+		params, err := parseParams(value["params"])
+		if err != nil {
+			return nil, fmt.Errorf("the command: '%s': %w", name, err)
+		}
+		entry.Params = params
+	default:
+		entry.Command = joinCommand(value)
+	}
+
+	if strings.TrimSpace(entry.Command) == "" {
+		return nil, fmt.Errorf("the command: '%s' is empty", name)
+	}
+
+	return entry, nil
+}
+
+// Entries returns the sorted names of the commands defined under 'on_runtime.run'.
+func Entries() []string {
+	names := make([]string, 0)
+	for name := range Cfg.Data.GetStringMap("on_runtime.run") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// joinCommand makes a single command from a string or from a list of strings.
+func joinCommand(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ` `)
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, part := range v {
+			parts = append(parts, fmt.Sprint(part))
+		}
+		return strings.Join(parts, ` `)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// toDuration converts a config value into time.Duration.
+// Numbers are handled as seconds, strings are parsed by time.ParseDuration: 30s, 5m, 1h.
+func toDuration(value interface{}) time.Duration {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			L.Error(fmt.Errorf("invalid duration: '%s': %w", v, err))
+		}
+		return d
+	case int:
+		return time.Duration(v) * time.Second
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	default:
+		L.Error(fmt.Errorf("invalid duration: '%v'", v))
+		return 0
+	}
+}
+
+// maxParallel returns how many jobs of the entry can run at the same time.
+func (e *Entry) maxParallel() int {
+	if e.MaxParallel < 1 {
+		return 1
+	}
+	return e.MaxParallel
+}
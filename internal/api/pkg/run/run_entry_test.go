@@ -0,0 +1,83 @@
+package run
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+type (
+	ApiRunEntrySuite struct {
+		suite.Suite
+	}
+)
+
+func (a ApiRunEntrySuite) TestGetEntryList() {
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	entry, err := GetEntry("services")
+	a.Equal(nil, err)
+	a.Equal("services", entry.Name)
+	a.Equal("systemctl list-units --type=service", entry.Command)
+	a.Equal(time.Duration(0), entry.Timeout)
+	a.Equal(0, len(entry.Params))
+}
+
+func (a ApiRunEntrySuite) TestGetEntryMap() {
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	entry, err := GetEntry("ping")
+	a.Equal(nil, err)
+	a.Equal("ping -c {count} {host}", entry.Command)
+	a.Equal(2*time.Minute, entry.Timeout)
+	a.Equal(2, len(entry.Params))
+	a.Equal("count", entry.Params[0].Name)
+	a.Equal(ParamInt, entry.Params[0].Type)
+	a.Equal("10", entry.Params[0].Default)
+	a.Equal(100, *entry.Params[0].Max)
+	a.Equal("host", entry.Params[1].Name)
+
+	Cfg.Data.Set("on_runtime.run.numeric", map[string]interface{}{"command": []interface{}{"sleep", 1}, "timeout": 2})
+	entry, err = GetEntry("numeric")
+	a.Equal(nil, err)
+	a.Equal("sleep 1", entry.Command)
+	a.Equal(2*time.Second, entry.Timeout)
+}
+
+func (a ApiRunEntrySuite) TestGetEntryErrors() {
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	_, err := GetEntry("not_exists")
+	a.Contains(fmt.Sprint(err), "does not exist")
+
+	_, err = GetEntry("run.ping")
+	a.Contains(fmt.Sprint(err), "does not exist")
+
+	Cfg.Data.Set("on_runtime.run.empty", map[string]interface{}{"timeout": "1s"})
+	_, err = GetEntry("empty")
+	a.Contains(fmt.Sprint(err), "is empty")
+
+	Cfg.Data.Set("on_runtime.run.bad_params", map[string]interface{}{"command": "echo {x}", "params": []interface{}{
+		map[string]interface{}{"name": "x", "type": "unknown"},
+	}})
+	_, err = GetEntry("bad_params")
+	a.Contains(fmt.Sprint(err), "unknown type")
+}
+
+func (a ApiRunEntrySuite) TestEntries() {
+	Cfg = getConfig("api", "linux")
+
+	names := Entries()
+	a.Contains(names, "ping")
+	a.Contains(names, "services")
+}
+
+func TestApiRunEntrySuite(t *testing.T) {
+	suite.Run(t, new(ApiRunEntrySuite))
+}
@@ -0,0 +1,248 @@
+package run
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states.
+const (
+	StatusQueued      = "queued"
+	StatusRunning     = "running"
+	StatusFinished    = "finished"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+	StatusCancelled   = "cancelled"
+	StatusTimedOut    = "timed_out"
+)
+
+// Job triggers.
+const (
+	TriggerManual   = "manual"
+	TriggerSchedule = "schedule"
+)
+
+// Job holds the state of a single command execution.
+type Job struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Command    string            `json:"command"`
+	Params     map[string]string `json:"params,omitempty"`
+	Trigger    string            `json:"trigger,omitempty"`
+	Status     string            `json:"status"`
+	ExitCode   int               `json:"exit_code"`
+	Error      string            `json:"error,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+
+	done     chan struct{}
+	cmd      *exec.Cmd
+	out      *outputs
+	timer    *time.Timer
+	timeout  time.Duration
+	stopping string
+}
+
+var (
+	jobsMu sync.Mutex
+	// running holds the queued and the running jobs.
+	running = map[string]*Job{}
+	// queue holds the jobs waiting for a free slot, in order.
+	queue = []*Job{}
+
+	// history holds the job IDs of the commands, newest first, so a job is found without reading
+	// every job file. It is loaded from historyFolder, and loaded again, when CmdFolder is changed.
+	history       map[string][]string
+	historyFolder string
+)
+
+// Wait blocks until the job is finished.
+func (j *Job) Wait() {
+	if j.done != nil {
+		<-j.done
+	}
+}
+
+// Running reports whether the job is still in progress: queued or running.
+func (j *Job) Running() bool {
+	return j.Status == StatusRunning || j.Status == StatusQueued
+}
+
+// snapshot returns a copy of the job, which can be read safely
+// while the original is updated by the executor. jobsMu must be held.
+func (j *Job) snapshot() *Job {
+	c := *j
+	c.done, c.cmd, c.out, c.timer = nil, nil, nil, nil
+	return &c
+}
+
+// newJobID generates a unique, time ordered job ID.
+func newJobID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	ts := strings.Replace(time.Now().UTC().Format("20060102T150405.000000"), ".", "", 1)
+	return ts + "-" + hex.EncodeToString(b)
+}
+
+// jobFile returns the path of a job related file by its extension:
+// json (metadata), stdout, stderr or output (stdout and stderr combined).
+func jobFile(id, ext string) string {
+	return filepath.Join(CmdFolder, id+"."+ext)
+}
+
+// validID makes sure, that the ID cannot be used for path traversal.
+func validID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, `/\`) && !strings.HasPrefix(id, ".")
+}
+
+// save writes the job metadata to the disk.
+func (j *Job) save() error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	tmp := jobFile(j.ID, "json.tmp")
+	if err := os.WriteFile(tmp, b, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, jobFile(j.ID, "json"))
+}
+
+// GetJob returns a job by its ID. Running jobs are served from the memory,
+// finished ones from the history stored on the disk.
+func GetJob(id string) (*Job, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("invalid job id: '%s'", id)
+	}
+
+	jobsMu.Lock()
+	job, ok := running[id]
+	if ok {
+		job = job.snapshot()
+	}
+	jobsMu.Unlock()
+	if ok {
+		return job, nil
+	}
+
+	return loadJob(jobFile(id, "json"))
+}
+
+// loadJob reads job metadata from a file.
+func loadJob(path string) (*Job, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{}
+	if err := json.Unmarshal(b, job); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return job, nil
+}
+
+// History returns the executions of a specific command, newest first.
+// If the name is empty, all executions are returned. The jobs are found by the history index,
+// only their own files are read.
+func History(name string) ([]*Job, error) {
+	jobsMu.Lock()
+	ids := make([]string, 0)
+	for n, list := range historyIDs() {
+		if name == "" || n == name {
+			ids = append(ids, list...)
+		}
+	}
+	jobsMu.Unlock()
+
+	// The IDs are time ordered.
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := GetJob(id)
+		if err != nil {
+			L.Error(err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// historyIDs returns the job IDs of the commands, newest first. jobsMu must be held.
+func historyIDs() map[string][]string {
+	if history != nil && historyFolder == CmdFolder {
+		return history
+	}
+
+	history, historyFolder = map[string][]string{}, CmdFolder
+	files, err := filepath.Glob(filepath.Join(CmdFolder, "*.json"))
+	if err != nil {
+		L.Error(err)
+		return history
+	}
+
+	// The IDs are time ordered.
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	for _, file := range files {
+		job, err := loadJob(file)
+		if err != nil {
+			L.Error(err)
+			continue
+		}
+		history[job.Name] = append(history[job.Name], job.ID)
+	}
+	return history
+}
+
+// Output returns a specific output of the job: stdout, stderr or output (combined).
+func (j *Job) Output(kind string) string {
+	b, err := os.ReadFile(jobFile(j.ID, kind))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// activeByName returns the number of the queued and running jobs of a specific command.
+// jobsMu must be held.
+func activeByName(name string) int {
+	n := 0
+	for _, job := range running {
+		if job.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+// runningByName returns a queued or running job of a specific command, if any.
+func runningByName(name string) *Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	for _, job := range running {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// removeJob deletes all files belonging to a job.
+func removeJob(id string) {
+	for _, ext := range []string{"json", "stdout", "stderr", "output"} {
+		_ = os.Remove(jobFile(id, ext))
+	}
+}
@@ -0,0 +1,260 @@
+package run
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/takattila/monitor/pkg/common"
+)
+
+// Parameter types.
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamEnum   = "enum"
+	ParamHost   = "host"
+	ParamPath   = "path"
+)
+
+// Param describes a named argument of a run command.
+// The '{name}' placeholders in the command are replaced by the shell quoted values.
+//
+//	ping:
+//	  command: ping -c {count} {host}
+//	  params:
+//	    - name: count
+//	      type: int
+//	      default: 10
+//	      min: 1
+//	      max: 100
+//	    - name: host
+//	      type: host
+//	      default: localhost
+//	    - name: dir
+//	      type: path
+//	      base_dir: /srv/backup
+//
+// The path parameters must be clean relative paths without '..' elements.
+// Absolute paths are accepted only inside the base_dir of the parameter.
+type Param struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Default     string   `json:"default"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Min         *int     `json:"min,omitempty"`
+	Max         *int     `json:"max,omitempty"`
+	BaseDir     string   `json:"base_dir,omitempty"`
+
+	pattern *regexp.Regexp // Pattern compiled by parseParams, anchored to the whole value.
+}
+
+var (
+	paramNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+	hostnameRegexp  = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*\.?$`)
+)
+
+// parseParams reads the parameter definitions of a run entry.
+func parseParams(value interface{}) ([]Param, error) {
+	list, ok := value.([]interface{})
+	if value != nil && !ok {
+		return nil, fmt.Errorf("params must be a list")
+	}
+
+	params := make([]Param, 0, len(list))
+	for _, item := range list {
+		m := toStringMap(item)
+
+		p := Param{
+			Name:        strings.ToLower(toString(m["name"])),
+			Type:        toString(m["type"]),
+			Description: toString(m["description"]),
+			Default:     toString(m["default"]),
+			Pattern:     toString(m["pattern"]),
+			Min:         toIntPtr(m["min"]),
+			Max:         toIntPtr(m["max"]),
+			BaseDir:     toString(m["base_dir"]),
+		}
+		if values, ok := m["values"].([]interface{}); ok {
+			for _, v := range values {
+				p.Values = append(p.Values, toString(v))
+			}
+		}
+		if p.Type == "" {
+			p.Type = ParamString
+		}
+
+		if !paramNameRegexp.MatchString(p.Name) {
+			return nil, fmt.Errorf("invalid parameter name: '%s'", p.Name)
+		}
+		if !common.SliceContains([]string{ParamString, ParamInt, ParamEnum, ParamHost, ParamPath}, p.Type) {
+			return nil, fmt.Errorf("parameter '%s': unknown type: '%s'", p.Name, p.Type)
+		}
+		if p.Pattern != "" {
+			// The whole value must match, the values are substituted into shell commands.
+			pattern, err := regexp.Compile(`^(?:` + p.Pattern + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("parameter '%s': invalid pattern: %w", p.Name, err)
+			}
+			p.pattern = pattern
+		}
+		if p.BaseDir != "" && (!filepath.IsAbs(p.BaseDir) || filepath.Clean(p.BaseDir) != p.BaseDir) {
+			return nil, fmt.Errorf("parameter '%s': base_dir must be a clean absolute path", p.Name)
+		}
+		if p.Type == ParamEnum && len(p.Values) == 0 {
+			return nil, fmt.Errorf("parameter '%s': enum without values", p.Name)
+		}
+
+		params = append(params, p)
+	}
+
+	return params, nil
+}
+
+// Validate checks whether the value is acceptable for the parameter.
+func (p Param) Validate(value string) error {
+	if value == "" {
+		return fmt.Errorf("parameter '%s' is required", p.Name)
+	}
+	if strings.ContainsAny(value, "\x00\n\r") {
+		return fmt.Errorf("parameter '%s' contains invalid characters", p.Name)
+	}
+	// Values starting with '-' could be interpreted as options by the called program.
+	if p.Type != ParamInt && strings.HasPrefix(value, "-") {
+		return fmt.Errorf("parameter '%s' must not start with '-'", p.Name)
+	}
+
+	switch p.Type {
+	case ParamInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("parameter '%s' must be an integer", p.Name)
+		}
+		if p.Min != nil && n < *p.Min {
+			return fmt.Errorf("parameter '%s' must be at least %d", p.Name, *p.Min)
+		}
+		if p.Max != nil && n > *p.Max {
+			return fmt.Errorf("parameter '%s' must be at most %d", p.Name, *p.Max)
+		}
+	case ParamEnum:
+		if !common.SliceContains(p.Values, value) {
+			return fmt.Errorf("parameter '%s' must be one of: %s", p.Name, strings.Join(p.Values, ", "))
+		}
+	case ParamHost:
+		if net.ParseIP(value) == nil && !hostnameRegexp.MatchString(value) {
+			return fmt.Errorf("parameter '%s' must be a hostname or an IP address", p.Name)
+		}
+	case ParamPath:
+		if err := p.validatePath(value); err != nil {
+			return err
+		}
+	}
+
+	if p.pattern != nil && !p.pattern.MatchString(value) {
+		return fmt.Errorf("parameter '%s' does not match: %s", p.Name, p.Pattern)
+	}
+
+	return nil
+}
+
+// validatePath checks a path parameter: it must be clean, it must not contain '..' elements,
+// and it can be absolute only inside the base_dir of the parameter.
+func (p Param) validatePath(value string) error {
+	clean := filepath.Clean(value)
+	if clean != value && clean+"/" != value {
+		return fmt.Errorf("parameter '%s' must be a clean path", p.Name)
+	}
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("parameter '%s' must not contain '..'", p.Name)
+	}
+	if !filepath.IsAbs(clean) {
+		return nil
+	}
+
+	if p.BaseDir == "" {
+		return fmt.Errorf("parameter '%s' must be a relative path", p.Name)
+	}
+	rel, err := filepath.Rel(p.BaseDir, clean)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("parameter '%s' must be inside: %s", p.Name, p.BaseDir)
+	}
+	return nil
+}
+
+// Expand validates the given values and substitutes them into the command.
+// Missing values are replaced by the defaults. It returns the command and the used values.
+func (e *Entry) Expand(values map[string]string) (string, map[string]string, error) {
+	known := map[string]bool{}
+	for _, p := range e.Params {
+		known[p.Name] = true
+	}
+	for name := range values {
+		if !known[name] {
+			return "", nil, fmt.Errorf("the command: '%s' has no parameter: '%s'", e.Name, name)
+		}
+	}
+
+	used := map[string]string{}
+	replace := make([]string, 0, 2*len(e.Params))
+	for _, p := range e.Params {
+		value, ok := values[p.Name]
+		if !ok {
+			value = p.Default
+		}
+		if err := p.Validate(value); err != nil {
+			return "", nil, err
+		}
+		used[p.Name] = value
+		replace = append(replace, "{"+p.Name+"}", shellQuote(value))
+	}
+
+	// All placeholders are replaced in one pass, so a value cannot inject another placeholder.
+	return strings.NewReplacer(replace...).Replace(e.Command), used, nil
+}
+
+// shellQuote quotes a string, so the shell handles it as a single word without any expansion.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// toStringMap converts the maps produced by the YAML parser into map[string]interface{}.
+func toStringMap(value interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			m[strings.ToLower(key)] = val
+		}
+	case map[interface{}]interface{}:
+		for key, val := range v {
+			m[strings.ToLower(fmt.Sprint(key))] = val
+		}
+	}
+	return m
+}
+
+// toString converts a scalar config value into string.
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// toIntPtr converts a numeric config value into *int.
+func toIntPtr(value interface{}) *int {
+	if value == nil {
+		return nil
+	}
+	n, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil {
+		L.Error(fmt.Errorf("invalid number: '%v'", value))
+		return nil
+	}
+	return &n
+}
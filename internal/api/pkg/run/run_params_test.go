@@ -0,0 +1,139 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+type (
+	ApiRunParamsSuite struct {
+		suite.Suite
+	}
+)
+
+func (a ApiRunParamsSuite) TestValidate() {
+	min, max := 1, 100
+
+	for _, tc := range []struct {
+		param Param
+		value string
+		err   string
+	}{
+		{Param{Name: "s", Type: ParamString}, "any text; $(rm -rf /)", ""},
+		{Param{Name: "s", Type: ParamString}, "", "is required"},
+		{Param{Name: "s", Type: ParamString}, "-rf", "must not start with '-'"},
+		{Param{Name: "s", Type: ParamString}, "a\nb", "invalid characters"},
+		{Param{Name: "s", Type: ParamString, Pattern: `^[a-z]+$`, pattern: regexp.MustCompile(`^[a-z]+$`)}, "abc", ""},
+		{Param{Name: "s", Type: ParamString, Pattern: `^[a-z]+$`, pattern: regexp.MustCompile(`^[a-z]+$`)}, "abc1", "does not match"},
+		{Param{Name: "i", Type: ParamInt, Min: &min, Max: &max}, "10", ""},
+		{Param{Name: "i", Type: ParamInt, Min: &min, Max: &max}, "0", "at least 1"},
+		{Param{Name: "i", Type: ParamInt, Min: &min, Max: &max}, "101", "at most 100"},
+		{Param{Name: "i", Type: ParamInt}, "ten", "must be an integer"},
+		{Param{Name: "e", Type: ParamEnum, Values: []string{"a", "b"}}, "b", ""},
+		{Param{Name: "e", Type: ParamEnum, Values: []string{"a", "b"}}, "c", "must be one of: a, b"},
+		{Param{Name: "h", Type: ParamHost}, "localhost", ""},
+		{Param{Name: "h", Type: ParamHost}, "example.com", ""},
+		{Param{Name: "h", Type: ParamHost}, "::1", ""},
+		{Param{Name: "h", Type: ParamHost}, "192.168.1.1", ""},
+		{Param{Name: "h", Type: ParamHost}, "localhost;reboot", "hostname or an IP"},
+		{Param{Name: "p", Type: ParamPath}, "backup/daily", ""},
+		{Param{Name: "p", Type: ParamPath}, "backup/", ""},
+		{Param{Name: "p", Type: ParamPath}, "backup/../../etc", "clean path"},
+		{Param{Name: "p", Type: ParamPath}, "../etc", "must not contain '..'"},
+		{Param{Name: "p", Type: ParamPath}, "..", "must not contain '..'"},
+		{Param{Name: "p", Type: ParamPath}, "/etc/shadow", "must be a relative path"},
+		{Param{Name: "p", Type: ParamPath, BaseDir: "/home/pi"}, "/home/pi", ""},
+		{Param{Name: "p", Type: ParamPath, BaseDir: "/home/pi"}, "/home/pi/", ""},
+		{Param{Name: "p", Type: ParamPath, BaseDir: "/home/pi"}, "/home/pi/docs", ""},
+		{Param{Name: "p", Type: ParamPath, BaseDir: "/home/pi"}, "/home/pi2", "must be inside: /home/pi"},
+		{Param{Name: "p", Type: ParamPath, BaseDir: "/home/pi"}, "/etc", "must be inside: /home/pi"},
+	} {
+		err := tc.param.Validate(tc.value)
+		if tc.err == "" {
+			a.Equal(nil, err, tc.value)
+		} else {
+			a.Contains(fmt.Sprint(err), tc.err, tc.value)
+		}
+	}
+}
+
+func (a ApiRunParamsSuite) TestParseParams() {
+	params, err := parseParams([]interface{}{
+		map[interface{}]interface{}{"name": "dir", "type": "path", "base_dir": "/srv", "pattern": "^/srv/[a-z]+$"},
+	})
+	a.Equal(nil, err)
+	a.Equal(nil, params[0].Validate("/srv/backup"))
+	a.Contains(fmt.Sprint(params[0].Validate("/srv/Backup")), "does not match")
+
+	// The pattern must match the whole value, not only a part of it.
+	params, err = parseParams([]interface{}{map[interface{}]interface{}{"name": "s", "pattern": "[a-z]+|[0-9]+"}})
+	a.Equal(nil, err)
+	a.Equal(nil, params[0].Validate("abc"))
+	a.Equal(nil, params[0].Validate("123"))
+	a.Contains(fmt.Sprint(params[0].Validate("a;rm -rf /")), "does not match")
+	a.Contains(fmt.Sprint(params[0].Validate("abc123")), "does not match")
+
+	_, err = parseParams([]interface{}{map[interface{}]interface{}{"name": "s", "pattern": "[a-"}})
+	a.Contains(fmt.Sprint(err), "invalid pattern")
+
+	_, err = parseParams([]interface{}{map[interface{}]interface{}{"name": "dir", "type": "path", "base_dir": "srv"}})
+	a.Contains(fmt.Sprint(err), "base_dir must be a clean absolute path")
+}
+
+func (a ApiRunParamsSuite) TestExpand() {
+	entry := &Entry{
+		Name:    "echo",
+		Command: "echo {text} {count}",
+		Params: []Param{
+			{Name: "text", Type: ParamString, Default: "hello"},
+			{Name: "count", Type: ParamInt, Default: "1"},
+		},
+	}
+
+	command, used, err := entry.Expand(nil)
+	a.Equal(nil, err)
+	a.Equal(`echo 'hello' '1'`, command)
+	a.Equal(map[string]string{"text": "hello", "count": "1"}, used)
+
+	command, _, err = entry.Expand(map[string]string{"text": `it's {count} $(id)`})
+	a.Equal(nil, err)
+	a.Equal(`echo 'it'"'"'s {count} $(id)' '1'`, command)
+
+	_, _, err = entry.Expand(map[string]string{"unknown": "x"})
+	a.Contains(fmt.Sprint(err), "has no parameter: 'unknown'")
+
+	_, _, err = entry.Expand(map[string]string{"count": "x"})
+	a.Contains(fmt.Sprint(err), "must be an integer")
+}
+
+func (a ApiRunParamsSuite) TestExecWithParams() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	Cfg.Data.Set("on_runtime.run.say", map[string]interface{}{
+		"command": "echo {text}",
+		"params": []interface{}{
+			map[interface{}]interface{}{"name": "text", "default": "hello"},
+		},
+	})
+
+	job, err := Exec("say", map[string]string{"text": "$(echo injected)"})
+	a.Equal(nil, err)
+	job.Wait()
+
+	a.Equal("$(echo injected)\n", job.Output("stdout"))
+	a.Equal(map[string]string{"text": "$(echo injected)"}, job.Params)
+
+	_, err = Exec("say", map[string]string{"text": "-n"})
+	a.Contains(fmt.Sprint(err), "must not start with '-'")
+}
+
+func TestApiRunParamsSuite(t *testing.T) {
+	suite.Run(t, new(ApiRunParamsSuite))
+}
@@ -0,0 +1,185 @@
+package run
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
+)
+
+// scheduled holds the state of a command, that has a schedule.
+type scheduled struct {
+	expr     string
+	schedule cron.Schedule
+	next     time.Time
+}
+
+var (
+	// SchedulerSleep is the time between two checks of the schedules.
+	SchedulerSleep = 1 * time.Second
+
+	scheduleMu sync.Mutex
+	schedules  = map[string]*scheduled{}
+
+	// lastRuns holds the start time of the latest scheduled job of each command, it is guarded by scheduleMu.
+	// It is loaded from the history of lastRunsFolder once, and kept up to date by tick.
+	lastRuns       map[string]*time.Time
+	lastRunsFolder string
+
+	timeNow = time.Now
+)
+
+// Scheduler starts the commands, that have a 'schedule' defined.
+// The schedule is a standard cron expression with five fields:
+// minute, hour, day of month, month and day of week; descriptors
+// like @hourly or @daily and 'CRON_TZ=' prefixes are accepted too.
+// A scheduled run is skipped, if the previous one is still running.
+// It should be run in the background by starting with: 'go Scheduler()', it returns at the shutdown.
+func Scheduler() {
+	for {
+		tick(timeNow())
+
+		select {
+		case <-lifecycle.Stopping():
+			return
+		case <-time.After(SchedulerSleep):
+		}
+	}
+}
+
+// tick synchronizes the schedules with the config and starts the commands which are due.
+func tick(now time.Time) {
+	due := make([]string, 0)
+
+	loadLastRuns()
+	scheduleMu.Lock()
+	active := map[string]bool{}
+	for _, name := range Entries() {
+		entry, err := GetEntry(name)
+		if err != nil || entry.Schedule == "" {
+			continue
+		}
+		active[name] = true
+
+		s, ok := schedules[name]
+		if !ok || s.expr != entry.Schedule {
+			if s, err = newScheduled(entry.Schedule, now); err != nil {
+				L.Error(err)
+				delete(schedules, name)
+				continue
+			}
+			schedules[name] = s
+		}
+
+		if !now.Before(s.next) {
+			s.next = s.schedule.Next(now)
+			due = append(due, name)
+		}
+	}
+	for name := range schedules {
+		if !active[name] {
+			delete(schedules, name)
+		}
+	}
+	scheduleMu.Unlock()
+
+	for _, name := range due {
+		// Scheduled runs do not overlap, even if the command allows parallel runs.
+		if job := runningByName(name); job != nil {
+			L.Warning("scheduled run of:", name, "skipped, job:", job.ID, "is in progress")
+			continue
+		}
+
+		job, err := execute(name, nil, TriggerSchedule)
+		if err != nil {
+			L.Warning("scheduled run of:", name, "skipped:", err)
+			continue
+		}
+
+		// A queued job gets a new start time, when it is started, so a copy is stored.
+		jobsMu.Lock()
+		started := job.StartedAt
+		jobsMu.Unlock()
+
+		scheduleMu.Lock()
+		if lastRuns != nil {
+			lastRuns[name] = &started
+		}
+		scheduleMu.Unlock()
+	}
+}
+
+// newScheduled parses the expression and calculates the next run.
+func newScheduled(expr string, now time.Time) (*scheduled, error) {
+	schedule, err := parseSchedule(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scheduled{
+		expr:     expr,
+		schedule: schedule,
+		next:     schedule.Next(now),
+	}, nil
+}
+
+// parseSchedule parses a cron expression.
+func parseSchedule(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(strings.TrimSpace(expr))
+}
+
+// loadLastRuns looks up the latest scheduled jobs from the history, when they are not loaded yet.
+// The job files are read without holding scheduleMu, so the scheduler and the callers of NextRun
+// are not blocked by the disk.
+func loadLastRuns() {
+	scheduleMu.Lock()
+	loaded := lastRuns != nil && lastRunsFolder == CmdFolder
+	scheduleMu.Unlock()
+	if loaded {
+		return
+	}
+
+	folder := CmdFolder
+	runs := map[string]*time.Time{}
+	jobs, err := History("")
+	if err != nil {
+		L.Error(err)
+	}
+	for _, job := range jobs {
+		if job.Trigger == TriggerSchedule && runs[job.Name] == nil {
+			started := job.StartedAt
+			runs[job.Name] = &started
+		}
+	}
+
+	scheduleMu.Lock()
+	if lastRuns == nil || lastRunsFolder != folder {
+		lastRuns, lastRunsFolder = runs, folder
+	}
+	scheduleMu.Unlock()
+}
+
+// NextRun returns the time of the next and the last scheduled run of a command.
+func NextRun(entry *Entry) (next, last *time.Time) {
+	if entry.Schedule == "" {
+		return nil, nil
+	}
+
+	loadLastRuns()
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+
+	s, ok := schedules[entry.Name]
+	if !ok || s.expr != entry.Schedule {
+		var err error
+		if s, err = newScheduled(entry.Schedule, timeNow()); err != nil {
+			L.Error(err)
+			return nil, nil
+		}
+	}
+
+	n := s.next
+	return &n, lastRuns[entry.Name]
+}
@@ -0,0 +1,132 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+type (
+	ApiRunScheduleSuite struct {
+		suite.Suite
+	}
+)
+
+func (a ApiRunScheduleSuite) TestTick() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run", map[string]interface{}{
+		"scheduled": map[string]interface{}{"command": "echo scheduled", "schedule": "*/5 * * * *"},
+		"manual":    map[string]interface{}{"command": "echo manual"},
+	})
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	now := time.Date(2024, 1, 1, 10, 1, 0, 0, time.Local)
+	tick(now)
+
+	entry, err := GetEntry("scheduled")
+	a.Equal(nil, err)
+	next, last := NextRun(entry)
+	a.Equal(time.Date(2024, 1, 1, 10, 5, 0, 0, time.Local), *next)
+	a.Nil(last)
+
+	tick(*next)
+	job := runningByName("scheduled")
+	a.NotNil(job)
+	job.Wait()
+
+	jobs, err := History("scheduled")
+	a.Equal(nil, err)
+	a.Equal(1, len(jobs))
+	a.Equal(TriggerSchedule, jobs[0].Trigger)
+	a.Equal("scheduled\n", jobs[0].Output("output"))
+
+	next, last = NextRun(entry)
+	a.Equal(time.Date(2024, 1, 1, 10, 10, 0, 0, time.Local), *next)
+	a.Equal(jobs[0].StartedAt.Unix(), last.Unix())
+
+	jobs, err = History("manual")
+	a.Equal(nil, err)
+	a.Equal(0, len(jobs))
+}
+
+func (a ApiRunScheduleSuite) TestLastRunFromHistory() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run", map[string]interface{}{
+		"scheduled": map[string]interface{}{"command": "echo scheduled", "schedule": "*/5 * * * *"},
+	})
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	now := time.Date(2024, 1, 1, 10, 1, 0, 0, time.Local)
+	tick(now)
+	tick(now.Add(4 * time.Minute))
+	runningByName("scheduled").Wait()
+
+	// After a restart, the last run is looked up from the history once.
+	scheduleMu.Lock()
+	schedules, lastRuns = map[string]*scheduled{}, nil
+	scheduleMu.Unlock()
+
+	entry, err := GetEntry("scheduled")
+	a.Equal(nil, err)
+	_, last := NextRun(entry)
+	a.NotNil(last)
+
+	// Later, the history is not read again.
+	files, _ := filepath.Glob(filepath.Join(CmdFolder, "*.json"))
+	for _, f := range files {
+		a.Equal(nil, os.Remove(f))
+	}
+	_, cached := NextRun(entry)
+	a.Equal(last, cached)
+}
+
+func (a ApiRunScheduleSuite) TestTickSkipsRunning() {
+	defer useTempCmdFolder(a.T())()
+
+	Cfg = getConfig("api", "linux")
+	Cfg.Data.Set("on_runtime.run", map[string]interface{}{
+		"long": map[string]interface{}{"command": "sleep 10", "schedule": "* * * * *"},
+	})
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	now := time.Date(2024, 1, 1, 10, 0, 30, 0, time.Local)
+	tick(now)
+	tick(now.Add(30 * time.Second))
+	job := runningByName("long")
+	a.NotNil(job)
+
+	tick(now.Add(90 * time.Second))
+
+	jobs, err := History("long")
+	a.Equal(nil, err)
+	a.Equal(1, len(jobs))
+
+	a.Equal(nil, Cancel(job.ID))
+	job.Wait()
+}
+
+func (a ApiRunScheduleSuite) TestInvalidSchedule() {
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	Cfg.Data.Set("on_runtime.run.invalid", map[string]interface{}{"command": "echo", "schedule": "* * *"})
+	_, err := GetEntry("invalid")
+	a.Contains(fmt.Sprint(err), "invalid schedule")
+
+	next, last := NextRun(&Entry{Name: "manual", Command: "echo"})
+	a.Nil(next)
+	a.Nil(last)
+}
+
+func TestApiRunScheduleSuite(t *testing.T) {
+	suite.Run(t, new(ApiRunScheduleSuite))
+}
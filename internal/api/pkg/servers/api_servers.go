@@ -1,23 +1,119 @@
 package servers
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/user"
+	"strconv"
 
 	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
 	"github.com/takattila/monitor/pkg/logger"
 )
 
 var L logger.Logger
 
-// ServeHTTP will run service on specific port.
-func ServeHTTP(port int, router chi.Router) {
-	L.Info("ServeHTTP", "Port:", port)
+// Socket describes the Unix domain socket of the service.
+type Socket struct {
+	Path  string      // The socket file, it is replaced, if a socket is left there by a previous run.
+	Mode  os.FileMode // The permissions of the socket file, the clients need write permission.
+	Owner string      // The user, who owns the socket file, empty: the user of the service.
+	Group string      // The group of the socket file, empty: the group of the service.
+}
+
+// ServeHTTP will run service on specific address and port, empty address means: all interfaces.
+// With a TLS config, the service is served over HTTPS. It returns after the graceful shutdown.
+func ServeHTTP(address string, port int, tlsConfig *tls.Config, router chi.Router) {
+	L.Info("ServeHTTP", "Address:", address, "Port:", port, "TLS:", tlsConfig != nil)
+
+	server := &http.Server{
+		Addr:      net.JoinHostPort(address, strconv.Itoa(port)),
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		L.Fatal(err)
+		return
+	}
+	L.Fatal(lifecycle.Serve(server, listener, tlsConfig != nil))
+}
+
+// ServeUnix will run service on a Unix domain socket instead of a TCP port.
+// With a TLS config, the service is served over HTTPS. It returns after the graceful shutdown.
+func ServeUnix(socket Socket, tlsConfig *tls.Config, router chi.Router) {
+	L.Info("ServeUnix", "Socket:", socket.Path, "Mode:", socket.Mode, "TLS:", tlsConfig != nil)
+
+	listener, err := ListenUnix(socket)
+	if err != nil {
+		L.Fatal(err)
+		return
+	}
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: router,
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+
+	L.Fatal(lifecycle.Serve(server, listener, tlsConfig != nil)) // The socket file is removed, when the listener is closed.
+}
+
+// ListenUnix creates the socket file, and sets its permissions and its owner.
+func ListenUnix(socket Socket) (net.Listener, error) {
+	if info, err := os.Lstat(socket.Path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("the socket path exists, and it is not a socket: %s", socket.Path)
+		}
+		if err := os.Remove(socket.Path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("stat socket: %w", err)
+	}
+
+	uid, gid, err := lookupOwner(socket.Owner, socket.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socket.Path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on socket: %w", err)
+	}
+	if err := os.Chmod(socket.Path, socket.Mode); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(socket.Path, uid, gid); err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("chown socket: %w", err)
+		}
 	}
+	return listener, nil
+}
 
-	L.Fatal(server.ListenAndServe())
+// lookupOwner returns the ids of the user and the group, -1 is returned for the empty names.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return 0, 0, fmt.Errorf("socket owner: %w", err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("socket group: %w", err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
 }
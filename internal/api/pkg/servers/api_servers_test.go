@@ -2,7 +2,10 @@ package servers
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -10,6 +13,7 @@ import (
 	"github.com/phayes/freeport"
 	"github.com/stretchr/testify/suite"
 	"github.com/takattila/monitor/internal/api/pkg/handlers"
+	"github.com/takattila/monitor/internal/common/pkg/apiauth"
 	"github.com/takattila/monitor/pkg/logger"
 )
 
@@ -30,7 +34,7 @@ func (a ApiServersSuite) TestServeHTTP() {
 
 	r := chi.NewRouter()
 	r.Get(fmt.Sprintf("%s", endpoint), handlers.Playground)
-	go ServeHTTP(port, r)
+	go ServeHTTP("127.0.0.1", port, nil, r)
 	time.Sleep(100 * time.Millisecond)
 
 	requestURL := fmt.Sprintf("http://localhost:%d%s", port, endpoint)
@@ -43,6 +47,51 @@ func (a ApiServersSuite) TestServeHTTP() {
 	a.NotNil(res.Body)
 }
 
+func (a ApiServersSuite) TestServeUnix() {
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	socket := filepath.Join(a.T().TempDir(), "api.sock")
+	r := chi.NewRouter()
+	r.Get("/playground", handlers.Playground)
+	go ServeUnix(Socket{Path: socket, Mode: 0600}, nil, r)
+	time.Sleep(100 * time.Millisecond)
+
+	info, err := os.Stat(socket)
+	a.Equal(nil, err)
+	a.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// The host of the URL is not dialed, the requests are sent through the socket.
+	res, err := apiauth.NewClient(nil, nil, socket).Get("http://127.0.0.1:1/playground")
+	a.Equal(nil, err)
+	a.Equal(200, res.StatusCode)
+}
+
+func (a ApiServersSuite) TestListenUnix() {
+	dir := a.T().TempDir()
+
+	// A socket left by a previous run is replaced.
+	socket := filepath.Join(dir, "api.sock")
+	stale, err := net.Listen("unix", socket)
+	a.Require().NoError(err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	listener, err := ListenUnix(Socket{Path: socket, Mode: 0660})
+	a.Equal(nil, err)
+	_ = listener.Close()
+
+	// Other files are not removed.
+	file := filepath.Join(dir, "api.db")
+	a.Equal(nil, os.WriteFile(file, []byte("data"), 0600))
+	_, err = ListenUnix(Socket{Path: file, Mode: 0660})
+	a.Contains(err.Error(), "not a socket")
+
+	_, err = ListenUnix(Socket{Path: socket, Mode: 0660, Owner: "not-existing-user"})
+	a.Contains(err.Error(), "socket owner")
+	_, err = ListenUnix(Socket{Path: socket, Mode: 0660, Group: "not-existing-group"})
+	a.Contains(err.Error(), "socket group")
+}
+
 func TestApiServersSuite(t *testing.T) {
 	suite.Run(t, new(ApiServersSuite))
 }
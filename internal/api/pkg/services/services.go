@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
 	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
@@ -37,7 +38,7 @@ var (
 )
 
 // Watcher collects services data into output variable.
-// It should be run in the background by starting with: 'go Watcher()'.
+// It should be run in the background by starting with: 'go Watcher()', it returns at the shutdown.
 func Watcher() {
 	ServicesList := config.GetStringSlice(Cfg, "on_runtime.services_list")
 	output = getProcessesStatus(ServicesList)
@@ -46,7 +47,12 @@ func Watcher() {
 			ServicesList := config.GetStringSlice(Cfg, "on_runtime.services_list")
 			output = getProcessesStatus(ServicesList)
 		}
-		time.Sleep(Sleep)
+
+		select {
+		case <-lifecycle.Stopping():
+			return
+		case <-time.After(Sleep):
+		}
 	}
 }
 
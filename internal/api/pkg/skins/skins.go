@@ -2,10 +2,11 @@ package skins
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"io/fs"
 	"path/filepath"
 	"strings"
 
+	"github.com/takattila/monitor/internal/common/pkg/assets"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
 )
@@ -13,12 +14,15 @@ import (
 var (
 	Cfg       *settings.Settings
 	L         logger.Logger
-	SkinsPath = "./web/css"
+	SkinsPath = "css"
+
+	// Assets are the sources of the web interface, the embedded ones by default.
+	Assets fs.FS = assets.New("")
 )
 
 // GetJSON returns with a JSON that holds information from available skins.
 func GetJSON() string {
-	files, err := ioutil.ReadDir(SkinsPath)
+	files, err := fs.ReadDir(Assets, SkinsPath)
 	L.Error(err)
 
 	excluded := []string{"progress-presets", "light-mode", "fancy", "xterm"}
@@ -2,10 +2,13 @@ package skins
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/internal/common/pkg/assets"
 	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
@@ -26,10 +29,6 @@ func (a ApiSkinSuite) TestGetJSON() {
 	Cfg = s
 	L = logger.New(logger.NoneLevel, logger.ColorOff)
 
-	oldSkinsPath := SkinsPath
-	SkinsPath = gitRootPath + "/web/css"
-	defer func() { SkinsPath = oldSkinsPath }()
-
 	JSON := GetJSON()
 	a.Contains(JSON, "skins")
 
@@ -38,6 +37,24 @@ func (a ApiSkinSuite) TestGetJSON() {
 	a.Equal(err, nil)
 }
 
+func (a ApiSkinSuite) TestGetJSONOverride() {
+	Cfg = getConfig("api", "linux")
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	// The custom skins of the web sources directory are listed with the embedded ones.
+	dir := a.T().TempDir()
+	a.Equal(nil, os.MkdirAll(filepath.Join(dir, "css"), 0755))
+	a.Equal(nil, os.WriteFile(filepath.Join(dir, "css", "custom.css"), []byte("body {}"), 0644))
+
+	oldAssets := Assets
+	Assets = assets.New(dir)
+	defer func() { Assets = oldAssets }()
+
+	JSON := GetJSON()
+	a.Contains(JSON, `"custom"`)
+	a.Contains(JSON, `"dark"`)
+}
+
 func getConfig(service, system string) *settings.Settings {
 	configPath := gitRootPath + "/configs/" + service + "." + system + ".yaml"
 	s := settings.New(configPath)
@@ -0,0 +1,273 @@
+// Package apiauth authenticates the requests of the web service to the API service with HMAC signatures,
+// and configures the transport between the two services: the optional mutual TLS, or a Unix domain socket.
+package apiauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of the signed requests.
+const (
+	HeaderTimestamp = "X-Monitor-Timestamp"
+	HeaderNonce     = "X-Monitor-Nonce"
+	HeaderSignature = "X-Monitor-Signature"
+)
+
+// MaxSkew is the accepted age of a signed request, the nonces are remembered this long.
+const MaxSkew = 30 * time.Second
+
+var timeNow = time.Now
+
+// LoadSecret reads the shared secret from the file, and creates it with a random secret, if it does not exist yet.
+// Both services may create it at the same time, only one of them wins.
+func LoadSecret(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err == nil {
+		return validSecret(path, secret)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read API secret: %w", err)
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("generate API secret: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return LoadSecret(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create API secret: %w", err)
+	}
+	defer f.Close()
+
+	secret = []byte(hex.EncodeToString(random))
+	if _, err := f.Write(append(secret, '\n')); err != nil {
+		return nil, fmt.Errorf("write API secret: %w", err)
+	}
+	return secret, nil
+}
+
+func validSecret(path string, secret []byte) ([]byte, error) {
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("the API secret is too short, at least 16 characters are needed: %s", path)
+	}
+	return secret, nil
+}
+
+// Sign adds the timestamp, the nonce and the signature headers to the request.
+func Sign(r *http.Request, secret []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(timeNow().Unix(), 10)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+	r.Header.Set(HeaderSignature, signature(secret, r.Method, r.URL.RequestURI(), timestamp, r.Header.Get(HeaderNonce)))
+	return nil
+}
+
+// signature is the hex HMAC-SHA256 of the method, the request URI, the timestamp and the nonce.
+func signature(secret []byte, method, requestURI, timestamp, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Transport signs the requests of a client.
+type Transport struct {
+	Secret []byte
+	Base   http.RoundTripper
+}
+
+// RoundTrip signs a copy of the request, and sends it with the base transport.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	signed := r.Clone(r.Context())
+	if err := Sign(signed, t.Secret); err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// NewClient returns a client, which signs its requests, if the secret is set, and uses the TLS config for https.
+// If the socket is set, every request is sent through that Unix domain socket, the host of the URL is not dialed.
+func NewClient(secret []byte, tlsConfig *tls.Config, socket string) *http.Client {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = tlsConfig
+	if socket != "" {
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		base.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	if len(secret) == 0 {
+		return &http.Client{Transport: base}
+	}
+	return &http.Client{Transport: &Transport{Secret: secret, Base: base}}
+}
+
+// Verifier checks the signatures of the requests, and rejects the replayed ones.
+type Verifier struct {
+	secret []byte
+	mu     sync.Mutex
+	seen   map[string]time.Time
+}
+
+// NewVerifier returns a verifier of the secret.
+func NewVerifier(secret []byte) *Verifier {
+	return &Verifier{secret: secret, seen: map[string]time.Time{}}
+}
+
+// Verify checks the signature and the age of the request, and that its nonce was not used yet.
+func (v *Verifier) Verify(r *http.Request) error {
+	timestamp, nonce := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce)
+	if timestamp == "" || nonce == "" || r.Header.Get(HeaderSignature) == "" {
+		return errors.New("the request is not signed")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %s", timestamp)
+	}
+	now := timeNow()
+	if age := now.Sub(time.Unix(unix, 0)); age > MaxSkew || age < -MaxSkew {
+		return fmt.Errorf("the request is expired: %s", age)
+	}
+
+	requestURI := r.RequestURI
+	if requestURI == "" {
+		requestURI = r.URL.RequestURI()
+	}
+	expected := signature(v.secret, r.Method, requestURI, timestamp, nonce)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return errors.New("invalid signature")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for n, t := range v.seen {
+		if now.Sub(t) > 2*MaxSkew {
+			delete(v.seen, n)
+		}
+	}
+	if _, ok := v.seen[nonce]; ok {
+		return errors.New("the request is replayed")
+	}
+	v.seen[nonce] = now
+	return nil
+}
+
+// Middleware rejects the requests without a valid signature with 401.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "{\"error\":%q}\n", "unauthorized: "+err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServerTLSConfig loads the certificate of the API service. If the client CA file is set,
+// the clients must present a certificate signed by it (mutual TLS). Without a certificate, it returns nil.
+// The files are relative to the directory.
+func ServerTLSConfig(dir, certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	certFile, keyFile, clientCAFile = inDir(dir, certFile), inDir(dir, keyFile), inDir(dir, clientCAFile)
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("the client CA file needs the certificate and the key of the server")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load API certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if clientCAFile != "" {
+		config.ClientCAs, err = loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLSConfig trusts the CA of the API service, and presents the client certificate, if they are set.
+// Without any of them, it returns nil: the system CAs are trusted. The files are relative to the directory.
+func ClientTLSConfig(dir, caFile, certFile, keyFile string) (*tls.Config, error) {
+	caFile, certFile, keyFile = inDir(dir, caFile), inDir(dir, certFile), inDir(dir, keyFile)
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// inDir joins the file with the directory, if it is set.
+func inDir(dir, file string) string {
+	if file == "" {
+		return ""
+	}
+	return filepath.Join(dir, file)
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in the CA file: %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,207 @@
+package apiauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	ApiAuthSuite struct {
+		suite.Suite
+	}
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func (s ApiAuthSuite) TestLoadSecret() {
+	path := filepath.Join(s.T().TempDir(), "api.secret")
+
+	// The secret is created at the first start, and it is reused later.
+	secret, err := LoadSecret(path)
+	s.Equal(nil, err)
+	s.Equal(64, len(secret))
+
+	info, err := os.Stat(path)
+	s.Equal(nil, err)
+	s.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	reused, err := LoadSecret(path)
+	s.Equal(nil, err)
+	s.Equal(secret, reused)
+
+	s.Equal(nil, os.WriteFile(path, []byte(" short \n"), 0600))
+	_, err = LoadSecret(path)
+	s.Contains(err.Error(), "too short")
+
+	_, err = LoadSecret(filepath.Join(filepath.Dir(path), "not_exists", "api.secret"))
+	s.Contains(err.Error(), "create API secret")
+}
+
+func (s ApiAuthSuite) TestVerify() {
+	defer func() { timeNow = time.Now }()
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+
+	v := NewVerifier(testSecret)
+	signed := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/run/exec/backup?x=1", nil)
+		s.Require().NoError(Sign(r, testSecret))
+		return r
+	}
+
+	s.Equal(nil, v.Verify(signed()))
+	s.Contains(v.Verify(httptest.NewRequest(http.MethodGet, "/cpu", nil)).Error(), "not signed")
+
+	// The same request can not be sent twice.
+	r := signed()
+	s.Equal(nil, v.Verify(r))
+	s.Contains(v.Verify(r).Error(), "replayed")
+
+	// The signature covers the method and the request URI.
+	r = signed()
+	r.Method = http.MethodPost
+	s.Contains(v.Verify(r).Error(), "invalid signature")
+	r = signed()
+	r.RequestURI = "/run/exec/reboot?x=1"
+	s.Contains(v.Verify(r).Error(), "invalid signature")
+
+	r = httptest.NewRequest(http.MethodGet, "/cpu", nil)
+	s.Require().NoError(Sign(r, []byte("other secret of 16+ chars")))
+	s.Contains(v.Verify(r).Error(), "invalid signature")
+
+	r = signed()
+	r.Header.Set(HeaderTimestamp, "yesterday")
+	s.Contains(v.Verify(r).Error(), "invalid timestamp")
+
+	// Old requests are rejected, and their nonces are forgotten.
+	r = signed()
+	now = now.Add(MaxSkew + time.Second)
+	s.Contains(v.Verify(r).Error(), "expired")
+	now = now.Add(2 * MaxSkew)
+	s.Equal(nil, v.Verify(signed()))
+	s.Equal(1, len(v.seen))
+}
+
+func (s ApiAuthSuite) TestClient() {
+	server := httptest.NewServer(NewVerifier(testSecret).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})))
+	defer server.Close()
+
+	resp, err := NewClient(testSecret, nil, "").Get(server.URL + "/cpu?x=1")
+	s.Equal(nil, err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	resp, err = NewClient(nil, nil, "").Get(server.URL + "/cpu")
+	s.Equal(nil, err)
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+	s.Equal("application/json", resp.Header.Get("Content-Type"))
+}
+
+func (s ApiAuthSuite) TestMutualTLS() {
+	dir := s.T().TempDir()
+	ca, caKey := s.writeCert(dir, "ca", nil, nil)
+	s.writeCert(dir, "server", ca, caKey)
+	s.writeCert(dir, "client", ca, caKey)
+
+	serverConfig, err := ServerTLSConfig(dir, "server.pem", "server.key", "ca.pem")
+	s.Require().NoError(err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.TLS = serverConfig
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // The failed handshakes are expected.
+	server.StartTLS()
+	defer server.Close()
+
+	clientConfig, err := ClientTLSConfig(dir, "ca.pem", "client.pem", "client.key")
+	s.Require().NoError(err)
+	resp, err := NewClient(testSecret, clientConfig, "").Get(server.URL)
+	s.Equal(nil, err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	// Without a client certificate, the handshake fails.
+	clientConfig, err = ClientTLSConfig(dir, "ca.pem", "", "")
+	s.Require().NoError(err)
+	_, err = NewClient(testSecret, clientConfig, "").Get(server.URL)
+	s.NotEqual(nil, err)
+
+	// Without the CA, the server is not trusted.
+	_, err = NewClient(testSecret, nil, "").Get(server.URL)
+	s.NotEqual(nil, err)
+}
+
+func (s ApiAuthSuite) TestTLSConfig() {
+	config, err := ServerTLSConfig("/", "", "", "")
+	s.Equal(nil, err)
+	s.Nil(config)
+
+	config, err = ClientTLSConfig("/", "", "", "")
+	s.Equal(nil, err)
+	s.Nil(config)
+
+	_, err = ServerTLSConfig("/", "", "", "ca.pem")
+	s.Contains(err.Error(), "needs the certificate")
+
+	_, err = ServerTLSConfig(s.T().TempDir(), "server.pem", "server.key", "")
+	s.Contains(err.Error(), "load API certificate")
+
+	dir := s.T().TempDir()
+	s.Equal(nil, os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("not a certificate"), 0600))
+	_, err = ClientTLSConfig(dir, "ca.pem", "", "")
+	s.Contains(err.Error(), "no certificates")
+}
+
+// writeCert writes a certificate and its key into the directory. Without a parent, it is a self-signed CA.
+func (s ApiAuthSuite) writeCert(dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	s.Require().NoError(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	s.Require().NoError(err)
+
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	cert, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	return cert, key
+}
+
+func TestApiAuthSuite(t *testing.T) {
+	suite.Run(t, new(ApiAuthSuite))
+}
@@ -0,0 +1,132 @@
+// Package assets serves the sources of the web interface from the binary.
+// An optional directory on the disk overrides the embedded files, e.g. with custom skins and logos.
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/takattila/monitor/web"
+)
+
+// FS layers the override directory over the embedded assets, the files of the override directory win.
+type FS struct {
+	override fs.FS
+	embedded fs.FS
+}
+
+// New returns the embedded assets, overridden by the files of the directory. Empty directory: only the embedded assets.
+// The directory does not have to exist.
+func New(overrideDir string) *FS {
+	f := &FS{embedded: web.Assets}
+	if overrideDir != "" {
+		f.override = os.DirFS(overrideDir)
+	}
+	return f
+}
+
+// Open opens the file of the override directory, or the embedded one, if it is not overridden.
+func (f *FS) Open(name string) (fs.File, error) {
+	if f.override != nil {
+		file, err := f.override.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return f.embedded.Open(name)
+}
+
+// ReadDir lists the files of both layers, sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	embedded, err := fs.ReadDir(f.embedded, name)
+	if f.override == nil {
+		return embedded, err
+	}
+
+	override, overrideErr := fs.ReadDir(f.override, name)
+	if err != nil && overrideErr != nil {
+		return nil, err
+	}
+
+	entries := map[string]fs.DirEntry{}
+	for _, e := range append(embedded, override...) {
+		entries[e.Name()] = e
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
+
+// etags caches the ETags of the files by their name, size and modification time.
+var etags sync.Map
+
+// Handler serves the files of the file system, the directories are not listed.
+// The responses carry an ETag, and the browsers must revalidate them, so the changed skins are shown immediately.
+func Handler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		content, seekable := file.(io.ReadSeeker)
+		if err != nil || info.IsDir() || !seekable {
+			http.NotFound(w, r)
+			return
+		}
+
+		etag, err := etagOf(name, info, content)
+		if err != nil {
+			http.Error(w, "read error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+	})
+}
+
+// etagOf returns the hash of the content. The embedded files have no modification time, so it is computed only once.
+func etagOf(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := fmt.Sprintf("%s|%d|%d", name, info.Size(), info.ModTime().UnixNano())
+	if etag, ok := etags.Load(key); ok {
+		return etag.(string), nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	etag := `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+	etags.Store(key, etag)
+	return etag, nil
+}
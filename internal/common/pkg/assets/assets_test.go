@@ -0,0 +1,115 @@
+package assets
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	AssetsSuite struct {
+		suite.Suite
+	}
+)
+
+func (s AssetsSuite) TestFS() {
+	embedded := New("")
+	_, err := fs.Stat(embedded, "html/login.html")
+	s.Equal(nil, err)
+	_, err = fs.Stat(embedded, "css/custom.css")
+	s.True(os.IsNotExist(err))
+
+	// The override directory may not exist.
+	missing := New(filepath.Join(s.T().TempDir(), "not_exists"))
+	_, err = fs.Stat(missing, "html/login.html")
+	s.Equal(nil, err)
+	entries, err := fs.ReadDir(missing, "css")
+	s.Equal(nil, err)
+	s.NotEmpty(entries)
+
+	dir := s.overrideDir()
+	layered := New(dir)
+
+	data, err := fs.ReadFile(layered, "css/dark.css")
+	s.Equal(nil, err)
+	s.Equal("overridden", string(data))
+
+	entries, err = fs.ReadDir(layered, "css")
+	s.Equal(nil, err)
+	names := map[string]bool{}
+	for i, e := range entries {
+		names[e.Name()] = true
+		if i > 0 {
+			s.Less(entries[i-1].Name(), e.Name())
+		}
+	}
+	s.True(names["custom.css"])
+	s.True(names["dark.css"])
+	s.True(names["light-mode.css"])
+
+	_, err = fs.ReadDir(layered, "not_exists")
+	s.NotEqual(nil, err)
+}
+
+func (s AssetsSuite) TestHandler() {
+	dir := s.overrideDir()
+	handler := Handler(New(dir))
+
+	serve := func(path, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/html/login.html", "")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("no-cache", w.Header().Get("Cache-Control"))
+	s.Contains(w.Header().Get("Content-Type"), "text/html")
+	etag := w.Header().Get("ETag")
+	s.NotEmpty(etag)
+
+	// The unchanged file is not sent again.
+	s.Equal(http.StatusNotModified, serve("/html/login.html", etag).Code)
+
+	w = serve("/css/custom.css", "")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("custom", w.Body.String())
+	etag = w.Header().Get("ETag")
+
+	// The ETag follows the changes of the override directory.
+	later := time.Now().Add(time.Minute)
+	s.Equal(nil, os.WriteFile(filepath.Join(dir, "css", "custom.css"), []byte("changed"), 0644))
+	s.Equal(nil, os.Chtimes(filepath.Join(dir, "css", "custom.css"), later, later))
+	w = serve("/css/custom.css", etag)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("changed", w.Body.String())
+
+	// The directories are not listed, and the paths can not leave the file system.
+	s.Equal(http.StatusNotFound, serve("/css/", "").Code)
+	s.Equal(http.StatusNotFound, serve("/", "").Code)
+	s.Equal(http.StatusNotFound, serve("/../configs/web.linux.yaml", "").Code)
+	s.Equal(http.StatusNotFound, serve("/not_exists.js", "").Code)
+}
+
+// overrideDir returns a directory, which overrides a skin, and adds a new one.
+func (s AssetsSuite) overrideDir() string {
+	dir := s.T().TempDir()
+	s.Require().NoError(os.MkdirAll(filepath.Join(dir, "css"), 0755))
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "css", "dark.css"), []byte("overridden"), 0644))
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "css", "custom.css"), []byte("custom"), 0644))
+	return dir
+}
+
+func TestAssetsSuite(t *testing.T) {
+	suite.Run(t, new(AssetsSuite))
+}
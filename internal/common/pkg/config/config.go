@@ -8,6 +8,18 @@ import (
 	"github.com/takattila/settings-manager"
 )
 
+// Load reads the configuration of the service, and reloads it, when the file changes.
+func Load(service string) *settings.Settings {
+	s := settings.New(common.GetConfigPath(service))
+	s.AutoReload()
+	return s
+}
+
+// NewLogger returns the logger configured by the on_start.logger settings.
+func NewLogger(s *settings.Settings) logger.Logger {
+	return logger.New(GetLogLevel(s, "on_start.logger.level"), GetLogColor(s, "on_start.logger.color"))
+}
+
 // GetBool returns the value associated with the key as a boolean.
 func GetBool(s *settings.Settings, key string) bool {
 	ret, err := s.GetBool(key)
@@ -0,0 +1,116 @@
+package lifecycle
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// HealthPath is answered by the servers of Serve before their own handler, without authentication.
+	HealthPath = "/healthz"
+
+	// healthServerName is the TLS server name of the self-requests.
+	healthServerName = "healthz.monitor.invalid"
+)
+
+var (
+	healthCertOnce sync.Once
+	healthCert     *tls.Certificate
+	healthCertErr  error
+)
+
+// serveHealth answers HealthPath with 204 No Content, the other requests are passed to the handler of the server.
+// The certificate of a TLS server may be limited to its domain (autocert), so the self-requests get their own one.
+func serveHealth(server *http.Server) {
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == HealthPath {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	if server.TLSConfig == nil || server.TLSConfig.GetCertificate == nil {
+		return
+	}
+	getCertificate := server.TLSConfig.GetCertificate
+	server.TLSConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == healthServerName {
+			return selfSignedCert()
+		}
+		return getCertificate(hello)
+	}
+}
+
+// selfRequest returns a liveness check, which requests HealthPath through the listener:
+// the server must accept the connection, and answer the request.
+// It can not detect, if only some of the handlers are stuck, e.g. on a lock.
+func selfRequest(addr net.Addr, useTLS bool) func(ctx context.Context) error {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, addr.Network(), addr.String())
+		},
+		// The server requests itself, its own certificate is not verified.
+		TLSClientConfig:   &tls.Config{ServerName: healthServerName, InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+healthServerName+HealthPath, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// selfSignedCert makes the certificate of the self-requests once.
+func selfSignedCert() (*tls.Certificate, error) {
+	healthCertOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			healthCertErr = err
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     []string{healthServerName},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(100 * 365 * 24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			healthCertErr = err
+			return
+		}
+		healthCert = &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	})
+	return healthCert, healthCertErr
+}
@@ -0,0 +1,160 @@
+// Package lifecycle stops the services gracefully on SIGINT and SIGTERM,
+// and reports their state to systemd: READY, WATCHDOG and STOPPING.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+// DefaultTimeout is used, when the shutdown timeout is not set.
+const DefaultTimeout = 30 * time.Second
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	// L is set by Start, the shutdown is logged with it.
+	L = logger.New(logger.NoneLevel, logger.ColorOff)
+
+	mu       sync.Mutex
+	hooks    []hook
+	stopping = make(chan struct{})
+	stopped  = make(chan struct{})
+	once     sync.Once
+)
+
+// OnShutdown registers a function, which is called at the shutdown.
+// The functions are called at the same time, they must return, when the context is done.
+func OnShutdown(name string, fn func(ctx context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = append(hooks, hook{name: name, fn: fn})
+}
+
+// Stopping is closed, when the shutdown begins. The background loops return, when it is closed.
+func Stopping() <-chan struct{} {
+	return stopping
+}
+
+// IsStopping reports whether the shutdown has begun.
+func IsStopping() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start handles SIGINT and SIGTERM with a graceful shutdown, which may take at most the timeout,
+// and pings the systemd watchdog, if it is enabled. A second signal stops the service immediately.
+func Start(l logger.Logger, timeout time.Duration) {
+	L = l
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		L.Info("Shutdown:", sig)
+		Shutdown(timeout)
+	}()
+
+	if watchdogInterval > 0 {
+		go watchdog(watchdogInterval)
+	}
+}
+
+// Shutdown notifies systemd, closes Stopping, and calls the registered functions.
+// It returns, when all of them are returned, or the timeout is over. It runs only once.
+func Shutdown(timeout time.Duration) {
+	once.Do(func() {
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		start := time.Now()
+		L.Error(notify(StateStopping))
+		close(stopping)
+
+		mu.Lock()
+		registered := append([]hook(nil), hooks...)
+		mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for _, h := range registered {
+			wg.Add(1)
+			go func(h hook) {
+				defer wg.Done()
+				if err := h.fn(ctx); err != nil {
+					L.Error(fmt.Errorf("shutdown of %s: %w", h.name, err))
+				}
+			}(h)
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			L.Warning("Shutdown timed out after:", timeout)
+		}
+
+		L.Info("Shutdown completed in:", time.Since(start).Round(time.Millisecond))
+		close(stopped)
+	})
+}
+
+// Serve serves the listener with the server, and notifies systemd, that the service is ready.
+// The server answers HealthPath, and the watchdog is pinged only, when it is answered through the listener.
+// At the shutdown, the server stops accepting new connections, and the active requests are drained.
+// After the shutdown, it returns nil, when all the registered functions are returned.
+func Serve(server *http.Server, listener net.Listener, useTLS bool) error {
+	serveHealth(server)
+	OnShutdown("server: "+listener.Addr().String(), server.Shutdown)
+	OnWatchdog("server: "+listener.Addr().String(), selfRequest(listener.Addr(), useTLS))
+	Ready()
+
+	var err error
+	if useTLS {
+		err = server.ServeTLS(listener, "", "") // The certificate is in the TLS config.
+	} else {
+		err = server.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	<-stopped
+	return nil
+}
+
+// reset restores the initial state, it is used by the tests.
+func reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = nil
+	checks = nil
+	stopping = make(chan struct{})
+	stopped = make(chan struct{})
+	once = sync.Once{}
+	readyOnce = sync.Once{}
+	notifySocket = ""
+}
@@ -0,0 +1,273 @@
+package lifecycle
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	LifecycleSuite struct {
+		suite.Suite
+	}
+)
+
+func (s LifecycleSuite) SetupTest() {
+	reset()
+}
+
+func (s LifecycleSuite) TestShutdown() {
+	quick, slow := make(chan struct{}), make(chan error, 1)
+	OnShutdown("quick", func(ctx context.Context) error {
+		close(quick)
+		return nil
+	})
+	OnShutdown("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		slow <- ctx.Err()
+		return ctx.Err()
+	})
+	s.False(IsStopping())
+
+	start := time.Now()
+	Shutdown(100 * time.Millisecond)
+	s.GreaterOrEqual(time.Since(start), 100*time.Millisecond)
+	s.True(IsStopping())
+	<-quick
+	s.Equal(context.DeadlineExceeded, <-slow)
+
+	// It runs only once.
+	start = time.Now()
+	Shutdown(time.Second)
+	s.Less(time.Since(start), 100*time.Millisecond)
+}
+
+func (s LifecycleSuite) TestShutdownStuckHook() {
+	OnShutdown("stuck", func(ctx context.Context) error {
+		select {} // It ignores the context.
+	})
+
+	start := time.Now()
+	Shutdown(50 * time.Millisecond)
+	s.Less(time.Since(start), time.Second)
+}
+
+func (s LifecycleSuite) TestServe() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("drained"))
+	})}
+
+	served := make(chan error, 1)
+	go func() { served <- Serve(server, listener, false) }()
+
+	url := "http://" + listener.Addr().String()
+	response := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		response <- string(body)
+	}()
+	<-started
+
+	shutdown := make(chan struct{})
+	go func() {
+		Shutdown(5 * time.Second)
+		close(shutdown)
+	}()
+	<-Stopping()
+	time.Sleep(50 * time.Millisecond)
+
+	// The new connections are refused, the active request is completed.
+	_, err = http.Get(url)
+	s.NotEqual(nil, err)
+	close(release)
+	s.Equal("drained", <-response)
+	s.Equal(nil, <-served)
+	<-shutdown
+}
+
+func (s LifecycleSuite) TestServeHealth() {
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	secure, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	// Like autocert, the certificate is given only for the domain of the server.
+	domainOnly := &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return nil, fmt.Errorf("host not configured: %s", hello.ServerName)
+	}}
+
+	served := make(chan error, 2)
+	go func() { served <- Serve(&http.Server{Handler: handler}, plain, false) }()
+	go func() { served <- Serve(&http.Server{Handler: handler, TLSConfig: domainOnly}, secure, true) }()
+
+	// The health path is answered before the handler, both servers are alive.
+	s.Eventually(func() bool {
+		mu.Lock()
+		registered := len(checks)
+		mu.Unlock()
+		return registered == 2 && alive(time.Second) == nil
+	}, time.Second, 10*time.Millisecond)
+	resp, err := http.Get("http://" + plain.Addr().String() + "/internal")
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	// The servers do not answer after the shutdown.
+	Shutdown(time.Second)
+	s.Equal(nil, <-served)
+	s.Equal(nil, <-served)
+	s.Contains(fmt.Sprint(alive(time.Second)), "liveness check of server: 127.0.0.1:")
+}
+
+func (s LifecycleSuite) TestWatchdogLiveness() {
+	socket := filepath.Join(s.T().TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	s.Require().NoError(err)
+	defer conn.Close()
+	notifySocket = socket
+
+	healthy := make(chan bool, 1)
+	healthy <- false
+	OnWatchdog("test", func(ctx context.Context) error {
+		ok := <-healthy
+		healthy <- ok
+		if !ok {
+			return errors.New("not answered")
+		}
+		return nil
+	})
+
+	pinged := make(chan struct{})
+	go func() {
+		watchdog(10 * time.Millisecond)
+		close(pinged)
+	}()
+
+	// Without a passing check, systemd is not pinged.
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)))
+	buf := make([]byte, 64)
+	_, err = conn.Read(buf)
+	s.NotEqual(nil, err)
+
+	<-healthy
+	healthy <- true
+	s.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	s.Equal(nil, err)
+	s.Equal(StateWatchdog, string(buf[:n]))
+
+	Shutdown(time.Second)
+	<-pinged
+}
+
+func (s LifecycleSuite) TestServeError() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	listener.Close()
+
+	s.NotEqual(nil, Serve(&http.Server{}, listener, false))
+}
+
+func (s LifecycleSuite) TestNotify() {
+	defer func() { getenv, unsetenv = os.Getenv, os.Unsetenv }()
+
+	// Without systemd, nothing is sent.
+	s.Equal(nil, notify(StateReady))
+
+	socket := filepath.Join(s.T().TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	s.Require().NoError(err)
+	defer conn.Close()
+	env := map[string]string{"NOTIFY_SOCKET": socket, "WATCHDOG_USEC": "1000", "OTHER": "kept"}
+	getenv = func(key string) string { return env[key] }
+	unsetenv = func(key string) error {
+		delete(env, key)
+		return nil
+	}
+
+	// The child processes do not inherit the variables of the notifications.
+	useNotifySocket()
+	s.Equal(map[string]string{"OTHER": "kept"}, env)
+
+	read := func() string {
+		s.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err.Error()
+		}
+		return string(buf[:n])
+	}
+
+	// READY is sent only once.
+	Ready()
+	Ready()
+	s.Equal(StateReady, read())
+
+	pinged := make(chan struct{})
+	go func() {
+		watchdog(10 * time.Millisecond)
+		close(pinged)
+	}()
+	s.Equal(StateWatchdog, read())
+
+	// The watchdog stops at the shutdown.
+	Shutdown(time.Second)
+	<-pinged
+	s.Contains([]string{StateStopping, StateWatchdog}, read())
+
+	notifySocket = filepath.Join(filepath.Dir(socket), "not_exists.sock")
+	s.NotEqual(nil, notify(StateReady))
+}
+
+func (s LifecycleSuite) TestWatchdogInterval() {
+	defer func() { getenv, getpid = os.Getenv, os.Getpid }()
+	env := map[string]string{}
+	getenv = func(key string) string { return env[key] }
+	getpid = func() int { return 42 }
+
+	s.Equal(time.Duration(0), WatchdogInterval())
+
+	env["WATCHDOG_USEC"] = "30000000"
+	s.Equal(15*time.Second, WatchdogInterval())
+
+	env["WATCHDOG_PID"] = strconv.Itoa(42)
+	s.Equal(15*time.Second, WatchdogInterval())
+
+	// The watchdog is enabled for another process.
+	env["WATCHDOG_PID"] = "43"
+	s.Equal(time.Duration(0), WatchdogInterval())
+
+	env["WATCHDOG_USEC"], env["WATCHDOG_PID"] = "invalid", ""
+	s.Equal(time.Duration(0), WatchdogInterval())
+}
+
+func TestLifecycleSuite(t *testing.T) {
+	suite.Run(t, new(LifecycleSuite))
+}
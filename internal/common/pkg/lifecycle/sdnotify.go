@@ -0,0 +1,131 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// States of the service, which are sent to systemd.
+const (
+	StateReady    = "READY=1"
+	StateStopping = "STOPPING=1"
+	StateWatchdog = "WATCHDOG=1"
+)
+
+var (
+	getenv    = os.Getenv
+	getpid    = os.Getpid
+	unsetenv  = os.Unsetenv
+	readyOnce sync.Once
+
+	// notifySocket is the socket of systemd, and watchdogInterval is the interval of the watchdog pings.
+	notifySocket     string
+	watchdogInterval time.Duration
+
+	// checks are the liveness checks of the watchdog, they are guarded by mu.
+	checks []hook
+)
+
+// The variables are read before the main package runs any commands (e.g. hostnamectl to find the config).
+func init() {
+	watchdogInterval = WatchdogInterval()
+	useNotifySocket()
+}
+
+// useNotifySocket reads the socket of systemd, and removes the variables of the notifications
+// from the environment, so the child processes (e.g. systemctl) do not send notifications in the name of the service.
+func useNotifySocket() {
+	notifySocket = getenv("NOTIFY_SOCKET")
+	for _, key := range []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID"} {
+		_ = unsetenv(key)
+	}
+}
+
+// notify sends the state to the socket of systemd. Without NOTIFY_SOCKET
+// (the unit is not Type=notify, or the service is not started by systemd), it does nothing.
+func notify(state string) error {
+	socket := notifySocket
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // Abstract socket.
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Ready notifies systemd, that the service is ready: it listens on its port. It is sent only once.
+func Ready() {
+	readyOnce.Do(func() {
+		L.Error(notify(StateReady))
+	})
+}
+
+// WatchdogInterval returns the half of WatchdogSec of the unit, 0 if the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// OnWatchdog registers a liveness check. The watchdog is pinged only, when all the checks pass,
+// so systemd restarts the service, when e.g. its server does not answer. The check must return, when the context is done.
+func OnWatchdog(name string, fn func(ctx context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks = append(checks, hook{name: name, fn: fn})
+}
+
+// alive runs the liveness checks one after the other, all of them must pass within the timeout.
+func alive(timeout time.Duration) error {
+	mu.Lock()
+	registered := append([]hook(nil), checks...)
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, c := range registered {
+		if err := c.fn(ctx); err != nil {
+			return fmt.Errorf("liveness check of %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// watchdog pings systemd until the shutdown, if the liveness checks pass.
+// If the pings stop, systemd restarts the service.
+func watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-Stopping():
+			return
+		case <-ticker.C:
+			if err := alive(interval); err != nil {
+				L.Error(err)
+				continue
+			}
+			L.Error(notify(StateWatchdog))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// The all-in-one binary runs the API and the web service in one process.
+// The web routes call the API handlers directly, there is no HTTP hop between the services.
+package main
+
+import (
+	"time"
+
+	"github.com/go-chi/chi"
+	apiroutes "github.com/takattila/monitor/internal/api/pkg/routes"
+	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
+	webroutes "github.com/takattila/monitor/internal/web/pkg/routes"
+	"github.com/takattila/monitor/pkg/common"
+	"github.com/takattila/monitor/pkg/logger"
+	"github.com/takattila/settings-manager"
+)
+
+var (
+	dir    string
+	apiCfg *settings.Settings
+	webCfg *settings.Settings
+	l      logger.Logger
+)
+
+func init() {
+	dir = common.GetProgramDir()
+
+	// Both services use their own config file, the logger of web.yaml is shared.
+	apiCfg = config.Load("api")
+	webCfg = config.Load("web")
+	l = config.NewLogger(webCfg)
+
+	apiroutes.Configure(dir, apiCfg, l)
+	if err := webroutes.Configure(dir, webCfg); err != nil {
+		l.Fatal(err)
+	}
+}
+
+func main() {
+	// SIGTERM drains the requests, closes the terminal sessions, and interrupts the running jobs, before the service exits.
+	lifecycle.Start(l, time.Duration(config.GetInt(webCfg, "on_start.shutdown_timeout_seconds"))*time.Second)
+
+	apiRouter := chi.NewRouter()
+	apiroutes.Register(apiRouter, apiCfg)
+
+	h := webroutes.NewHandler(dir, webCfg, l)
+	h.ApiRouter = apiRouter
+
+	router := chi.NewRouter()
+	webroutes.Register(router, h, webCfg)
+	webroutes.Serve(dir, router, webCfg, l)
+}
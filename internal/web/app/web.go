@@ -1,13 +1,12 @@
 package main
 
 import (
-	"path/filepath"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/takattila/monitor/internal/common/pkg/config"
-	"github.com/takattila/monitor/internal/web/pkg/auth"
-	"github.com/takattila/monitor/internal/web/pkg/handlers"
-	"github.com/takattila/monitor/internal/web/pkg/servers"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
+	"github.com/takattila/monitor/internal/web/pkg/routes"
 	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
@@ -22,59 +21,29 @@ var (
 func init() {
 	dir = common.GetProgramDir()
 
-	s = settings.New(common.GetConfigPath("web"))
-	s.AutoReload()
+	s = config.Load("web")
+	l = config.NewLogger(s)
 
-	if err := auth.SaveCredentials((filepath.Join(dir, config.GetString(s, "on_start.auth_file"))), config.GetBool(s, "on_start.save_credentials")); err != nil {
+	if err := routes.Configure(dir, s); err != nil {
 		l.Fatal(err)
 	}
 }
 
 func main() {
-	router := chi.NewRouter()
-
-	l := logger.New(
-		config.GetLogLevel(s, "on_start.logger.level"),
-		config.GetLogColor(s, "on_start.logger.color"),
-	)
+	// SIGTERM drains the requests, and closes the terminal sessions, before the service exits.
+	lifecycle.Start(l, time.Duration(config.GetInt(s, "on_start.shutdown_timeout_seconds"))*time.Second)
 
-	h := handlers.Handler{
-		ProgramDir:    dir,
-		FilesDir:      config.GetString(s, "on_start.web_sources_directory"),
-		AuthFile:      config.GetString(s, "on_start.auth_file"),
-		LoginPage:     config.GetString(s, "on_start.pages.login"),
-		InternalPage:  config.GetString(s, "on_start.pages.internal"),
-		LoginRoute:    config.GetString(s, "on_start.routes.login"),
-		InternalRoute: config.GetString(s, "on_start.routes.internal"),
-		Cfg:           s,
-		L:             l,
-	}
+	router := chi.NewRouter()
 
-	router.HandleFunc(config.GetString(s, "on_start.routes.index"), h.Index)
-	router.Get(config.GetString(s, "on_start.routes.login"), h.Login)
-	router.Get(config.GetString(s, "on_start.routes.logout"), h.Logout)
-	router.Get(config.GetString(s, "on_start.routes.internal"), h.Internal)
-	router.Get(config.GetString(s, "on_start.routes.api"), h.Api)
-	router.Get(config.GetString(s, "on_start.routes.toggle"), h.Toggle)
-	router.Get(config.GetString(s, "on_start.routes.settings"), h.SettingsGET)
-	router.Post(config.GetString(s, "on_start.routes.settings"), h.SettingsPOST)
-	router.Post(config.GetString(s, "on_start.routes.systemctl"), h.SystemCtl)
-	router.Post(config.GetString(s, "on_start.routes.power"), h.Power)
-	router.Post(config.GetString(s, "on_start.routes.kill"), h.Kill)
-	router.Get(config.GetString(s, "on_start.routes.run"), h.Run)
-	router.Get(config.GetString(s, "on_start.routes.terminal"), h.Terminal)
+	h := routes.NewHandler(dir, s, l)
 
-	s := servers.Server{
-		Port:       config.GetInt(s, "on_start.port"),
-		Domain:     config.GetString(s, "on_start.domain"),
-		Router:     router,
-		RoutePath:  config.GetString(s, "on_start.routes.web"),
-		ProgramDir: dir,
-		FilesDir:   config.GetString(s, "on_start.web_sources_directory"),
-		Cfg:        s,
-		L:          l,
+	// The requests of the MONITOR-API service are signed with the shared secret.
+	apiClient, err := routes.NewApiClient(dir, s)
+	if err != nil {
+		l.Fatal(err)
 	}
+	h.ApiClient = apiClient
 
-	s.Files()
-	s.Start()
+	routes.Register(router, h, s)
+	routes.Serve(dir, router, s, l)
 }
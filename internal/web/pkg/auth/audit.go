@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Actions of the audit log.
+const (
+	AuditLogin         = "login"
+	AuditLoginFailed   = "login_failed"
+	AuditLogout        = "logout"
+	AuditService       = "service"
+	AuditPower         = "power"
+	AuditKill          = "kill"
+	AuditToggle        = "toggle"
+	AuditRun           = "run"
+	AuditTerminalOpen  = "terminal_open"
+	AuditTerminalClose = "terminal_close"
+	AuditUser          = "user"
+	AuditToken         = "token"
+)
+
+// AuditEntry is a record of the audit log.
+type AuditEntry struct {
+	ID     int64     `json:"id"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	IP     string    `json:"ip"`
+	Action string    `json:"action"`
+	Params string    `json:"params"`
+	Result string    `json:"result"`
+}
+
+// AuditFilter selects the records of the audit log, the empty fields match every record.
+type AuditFilter struct {
+	User   string
+	IP     string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// AddAudit appends a record to the audit log. The time is set, if it is zero.
+func AddAudit(authFile string, entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = timeNow()
+	}
+
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"INSERT INTO audit_log (time, username, ip, action, params, result) VALUES (?, ?, ?, ?, ?, ?)",
+		entry.Time.Unix(), entry.User, entry.IP, entry.Action, entry.Params, entry.Result,
+	)
+	if err != nil {
+		return fmt.Errorf("INSERT audit_log: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns the records of the audit log, which match the filter, the newest first.
+func ListAudit(authFile string, filter AuditFilter) ([]AuditEntry, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	where := []string{"1 = 1"}
+	args := []interface{}{}
+	for column, value := range map[string]string{"username": filter.User, "ip": filter.IP, "action": filter.Action} {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, filter.Until.Unix())
+	}
+
+	query := "SELECT id, time, username, ip, action, params, result FROM audit_log WHERE " +
+		strings.Join(where, " AND ") + " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("SELECT audit_log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var t int64
+		if err := rows.Scan(&e.ID, &t, &e.User, &e.IP, &e.Action, &e.Params, &e.Result); err != nil {
+			return nil, fmt.Errorf("scan audit_log row: %w", err)
+		}
+		e.Time = time.Unix(t, 0)
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return entries, nil
+}
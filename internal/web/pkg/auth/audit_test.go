@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	WebAuditSuite struct {
+		suite.Suite
+	}
+)
+
+func (s WebAuditSuite) TestAddListAudit() {
+	authdb := "test_audit.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	now := time.Unix(1700000000, 0)
+	for i, e := range []AuditEntry{
+		{User: "alice", IP: "10.0.0.1", Action: AuditLogin, Result: "ok"},
+		{User: "alice", IP: "10.0.0.1", Action: AuditKill, Params: "1234", Result: "killed"},
+		{User: "bob", IP: "10.0.0.2", Action: AuditPower, Params: "reboot", Result: "requested"},
+	} {
+		e.Time = now.Add(time.Duration(i) * time.Hour)
+		s.Equal(nil, AddAudit(authdb, e))
+	}
+
+	entries, err := ListAudit(authdb, AuditFilter{})
+	s.Equal(nil, err)
+	s.Equal(3, len(entries))
+	s.Equal(AuditPower, entries[0].Action)
+	s.Equal("reboot", entries[0].Params)
+	s.Equal(now.Add(2*time.Hour).Unix(), entries[0].Time.Unix())
+	s.Equal(AuditLogin, entries[2].Action)
+
+	entries, err = ListAudit(authdb, AuditFilter{User: "alice"})
+	s.Equal(nil, err)
+	s.Equal(2, len(entries))
+
+	entries, err = ListAudit(authdb, AuditFilter{IP: "10.0.0.2"})
+	s.Equal(nil, err)
+	s.Equal(1, len(entries))
+	s.Equal("bob", entries[0].User)
+
+	entries, err = ListAudit(authdb, AuditFilter{User: "alice", Action: AuditKill})
+	s.Equal(nil, err)
+	s.Equal(1, len(entries))
+	s.Equal("killed", entries[0].Result)
+
+	entries, err = ListAudit(authdb, AuditFilter{Since: now.Add(time.Hour), Until: now.Add(2 * time.Hour)})
+	s.Equal(nil, err)
+	s.Equal(1, len(entries))
+	s.Equal(AuditKill, entries[0].Action)
+
+	entries, err = ListAudit(authdb, AuditFilter{Limit: 2})
+	s.Equal(nil, err)
+	s.Equal(2, len(entries))
+	s.Equal(AuditPower, entries[0].Action)
+}
+
+func (s WebAuditSuite) TestAuditIsAppendOnly() {
+	authdb := "test_audit.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	s.Equal(nil, AddAudit(authdb, AuditEntry{User: "alice", Action: AuditLogin, Result: "ok"}))
+
+	db, err := initDB(authdb)
+	s.Equal(nil, err)
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE audit_log SET username = 'mallory'")
+	s.Contains(err.Error(), "append-only")
+
+	_, err = db.Exec("DELETE FROM audit_log")
+	s.Contains(err.Error(), "append-only")
+
+	entries, err := ListAudit(authdb, AuditFilter{})
+	s.Equal(nil, err)
+	s.Equal(1, len(entries))
+	s.Equal("alice", entries[0].User)
+}
+
+func TestWebAuditSuite(t *testing.T) {
+	suite.Run(t, new(WebAuditSuite))
+}
@@ -47,27 +47,9 @@ func initDB(authFile string) (*sql.DB, error) {
 		return nil, fmt.Errorf("sql.Open: %w", err)
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			username TEXT PRIMARY KEY,
-			password_hash TEXT NOT NULL
-		)
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("CREATE TABLE users: %w", err)
-	}
-
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS user_settings (
-			username  TEXT NOT NULL,
-			key_name  TEXT NOT NULL,
-			value     TEXT,
-			PRIMARY KEY (username, key_name),
-			FOREIGN KEY (username) REFERENCES users(username)
-		)
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("CREATE TABLE user_settings: %w", err)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	return db, nil
@@ -92,8 +74,13 @@ func authenticateLegacy(authFile, name, pass string) bool {
 	return false
 }
 
-// Authenticate checks whether credentials exist in the auth database or not.
+// Authenticate checks the credentials with the configured authenticator, see SetAuthenticator.
 func Authenticate(authFile, name, pass string) bool {
+	return authenticator.Authenticate(authFile, name, pass)
+}
+
+// Authenticate checks whether credentials exist in the auth database or not.
+func (DatabaseAuthenticator) Authenticate(authFile, name, pass string) bool {
 	db, err := initDB(authFile)
 	if err != nil {
 		return authenticateLegacy(authFile, name, pass)
@@ -101,13 +88,17 @@ func Authenticate(authFile, name, pass string) bool {
 	defer db.Close()
 
 	var hash string
-	err = db.QueryRow("SELECT password_hash FROM users WHERE username = ?", name).Scan(&hash)
+	var disabled bool
+	err = db.QueryRow("SELECT password_hash, disabled FROM users WHERE username = ?", name).Scan(&hash, &disabled)
 	if err == sql.ErrNoRows {
 		return false
 	}
 	if err != nil {
 		return authenticateLegacy(authFile, name, pass)
 	}
+	if disabled {
+		return false
+	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
 	return err == nil
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"fmt"
+	"os/user"
+)
+
+// Backends of the authentication, the values of the 'on_start.authentication.backend' setting.
+const (
+	BackendDatabase = "database"
+	BackendPAM      = "pam"
+	BackendLDAP     = "ldap"
+)
+
+// Authenticator checks the password of a user.
+type Authenticator interface {
+	Authenticate(authFile, name, pass string) bool
+}
+
+// DatabaseAuthenticator checks the bcrypt password hashes of the users table. It is the default.
+type DatabaseAuthenticator struct{}
+
+// PAMAuthenticator checks the credentials of the system users with PAM.
+// Only the members of the allowed Unix groups can log in. They are added to the users table
+// at their first login with the default role, so the roles, the two-factor authentication
+// and the disabling work the same way as for the database users.
+type PAMAuthenticator struct {
+	Service       string   // The PAM service, e.g. login: /etc/pam.d/login.
+	AllowedGroups []string // The Unix groups, whose members can log in.
+	DefaultRole   string   // The role of the users, who log in the first time.
+}
+
+var authenticator Authenticator = DatabaseAuthenticator{}
+
+// pamAuthenticate is implemented with cgo, when the program is built with the 'pam' tag.
+var pamAuthenticate = pamAuthenticateSystem
+
+// SetAuthenticator replaces the authenticator used by Authenticate.
+func SetAuthenticator(a Authenticator) {
+	authenticator = a
+}
+
+// NewAuthenticator returns the authenticator of the backend.
+func NewAuthenticator(backend string, pam PAMAuthenticator, ldapConfig LDAPConfig) (Authenticator, error) {
+	switch backend {
+	case "", BackendDatabase:
+		return DatabaseAuthenticator{}, nil
+	case BackendPAM:
+		if pam.Service == "" {
+			return nil, fmt.Errorf("the PAM service is not set")
+		}
+		if len(pam.AllowedGroups) == 0 {
+			return nil, fmt.Errorf("the allowed groups of PAM are not set")
+		}
+		if !ValidRole(pam.DefaultRole) {
+			return nil, fmt.Errorf("invalid role: %s", pam.DefaultRole)
+		}
+		if !pamAvailable {
+			return nil, fmt.Errorf("PAM support is not compiled in, build the web service with: -tags pam")
+		}
+		return pam, nil
+	case BackendLDAP:
+		return NewLDAPAuthenticator(ldapConfig)
+	}
+	return nil, fmt.Errorf("invalid authentication backend: %s", backend)
+}
+
+// Authenticate checks the group membership and the password of a system user,
+// and adds the user to the users table, if it is not there yet. Disabled users can not log in.
+func (p PAMAuthenticator) Authenticate(authFile, name, pass string) bool {
+	if name == "" || pass == "" || !inGroups(name, p.AllowedGroups) {
+		return false
+	}
+
+	db, err := initDB(authFile)
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+
+	var disabled bool
+	err = db.QueryRow("SELECT disabled FROM users WHERE username = ?", name).Scan(&disabled)
+	if err == nil && disabled {
+		return false
+	}
+
+	if err := pamAuthenticate(p.Service, name, pass); err != nil {
+		return false
+	}
+
+	return provisionUser(db, name, BackendPAM, p.DefaultRole) == nil
+}
+
+// inGroups checks whether the system user is a member of any of the groups.
+func inGroups(name string, groups []string) bool {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return false
+	}
+
+	ids, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+
+	for _, id := range ids {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			continue
+		}
+		for _, allowed := range groups {
+			if g.Name == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
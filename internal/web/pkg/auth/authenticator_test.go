@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	WebAuthenticatorSuite struct {
+		suite.Suite
+	}
+)
+
+func (s WebAuthenticatorSuite) TestNewAuthenticator() {
+	a, err := NewAuthenticator("", PAMAuthenticator{}, LDAPConfig{})
+	s.Equal(nil, err)
+	s.Equal(DatabaseAuthenticator{}, a)
+
+	pam := PAMAuthenticator{Service: "login", AllowedGroups: []string{"monitor"}, DefaultRole: RoleViewer}
+	a, err = NewAuthenticator(BackendPAM, pam, LDAPConfig{})
+	if pamAvailable {
+		s.Equal(nil, err)
+		s.Equal(pam, a)
+	} else {
+		// The release binaries are built without cgo, the service must not start with a backend, which always fails.
+		s.Contains(fmt.Sprint(err), "PAM support is not compiled in")
+	}
+
+	_, err = NewAuthenticator(BackendPAM, PAMAuthenticator{Service: "login", DefaultRole: RoleViewer}, LDAPConfig{})
+	s.Contains(err.Error(), "allowed groups")
+
+	_, err = NewAuthenticator(BackendPAM, PAMAuthenticator{Service: "login", AllowedGroups: []string{"monitor"}, DefaultRole: "root"}, LDAPConfig{})
+	s.Contains(err.Error(), "invalid role")
+
+	_, err = NewAuthenticator(BackendLDAP, pam, LDAPConfig{URL: "http://ldap.example.net"})
+	s.Contains(err.Error(), "invalid LDAP URL")
+
+	_, err = NewAuthenticator("kerberos", pam, LDAPConfig{})
+	s.Contains(err.Error(), "invalid authentication backend")
+}
+
+func (s WebAuthenticatorSuite) TestPAMAuthenticator() {
+	authdb := "test_pam.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	current, err := user.Current()
+	s.Require().NoError(err)
+	group, err := user.LookupGroupId(current.Gid)
+	s.Require().NoError(err)
+
+	oldPamAuthenticate := pamAuthenticate
+	defer func() { pamAuthenticate = oldPamAuthenticate }()
+	pamAuthenticate = func(service, name, pass string) error {
+		if service == "login" && name == current.Username && pass == "system password" {
+			return nil
+		}
+		return errors.New("PAM authentication failed: 7")
+	}
+
+	oldAuthenticator := authenticator
+	defer SetAuthenticator(oldAuthenticator)
+	SetAuthenticator(PAMAuthenticator{Service: "login", AllowedGroups: []string{"nogroup_" + group.Name, group.Name}, DefaultRole: RoleOperator})
+
+	s.False(Authenticate(authdb, current.Username, "wrong"))
+	s.False(Authenticate(authdb, current.Username, ""))
+	s.False(Authenticate(authdb, "not_exists", "system password"))
+
+	// The user is added at the first login with the default role, and the stored hash never matches.
+	s.True(Authenticate(authdb, current.Username, "system password"))
+	role, err := GetUserRole(authdb, current.Username)
+	s.Equal(nil, err)
+	s.Equal(RoleOperator, role)
+	s.False(DatabaseAuthenticator{}.Authenticate(authdb, current.Username, "system password"))
+
+	// The role is kept, and disabled users can not log in.
+	s.Equal(nil, SetUserRole(authdb, current.Username, RoleAdmin))
+	s.True(Authenticate(authdb, current.Username, "system password"))
+	role, _ = GetUserRole(authdb, current.Username)
+	s.Equal(RoleAdmin, role)
+
+	s.Equal(nil, CreateUser(authdb, "other_admin", "password", RoleAdmin))
+	s.Equal(nil, SetUserDisabled(authdb, current.Username, true))
+	s.False(Authenticate(authdb, current.Username, "system password"))
+
+	// Only the members of the allowed groups can log in.
+	SetAuthenticator(PAMAuthenticator{Service: "login", AllowedGroups: []string{"nogroup_" + group.Name}, DefaultRole: RoleViewer})
+	s.Equal(nil, SetUserDisabled(authdb, current.Username, false))
+	s.False(Authenticate(authdb, current.Username, "system password"))
+}
+
+func TestWebAuthenticatorSuite(t *testing.T) {
+	suite.Run(t, new(WebAuthenticatorSuite))
+}
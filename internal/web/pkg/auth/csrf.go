@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// CSRFToken returns the synchronizer token of the session of the request,
+// or an empty string, if there is no valid session.
+func CSRFToken(request *http.Request) string {
+	id := SessionID(request)
+	if id == "" {
+		return ""
+	}
+
+	db, err := initDB(Sessions.AuthFile)
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+
+	var token string
+	if err := db.QueryRow("SELECT csrf_token FROM sessions WHERE id = ?", id).Scan(&token); err != nil {
+		return ""
+	}
+
+	// The sessions created before the CSRF tokens were introduced get one now.
+	if token == "" {
+		if token, err = randomToken(); err != nil {
+			return ""
+		}
+		if _, err := db.Exec("UPDATE sessions SET csrf_token = ? WHERE id = ?", token, id); err != nil {
+			return ""
+		}
+	}
+	return token
+}
+
+// ValidCSRFToken checks the token sent with the request against the token of its session.
+func ValidCSRFToken(request *http.Request, token string) bool {
+	expected := CSRFToken(request)
+	if expected == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
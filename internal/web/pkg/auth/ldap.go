@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// errLDAPUnavailable marks the failures, when the directory can not be reached, so the local accounts can be used.
+var errLDAPUnavailable = errors.New("LDAP directory is unavailable")
+
+// LDAPConfig describes the directory, how its users are found, and how their groups are mapped to roles.
+type LDAPConfig struct {
+	URL                string              // ldap://host:389 or ldaps://host:636
+	StartTLS           bool                // Upgrades the ldap:// connections to TLS.
+	CAFile             string              // The PEM certificates of the trusted CAs, empty: the system CAs.
+	BindDN             string              // The service account, which searches the users, empty: anonymous.
+	BindPassword       string              // The password of the service account.
+	BaseDN             string              // The users are searched under this DN.
+	UserFilter         string              // The filter of the users, %s is the escaped username.
+	GroupAttribute     string              // The attribute of the user, which lists its groups, e.g. memberOf.
+	RoleGroups         map[string][]string // The group DNs of each role, the most privileged matching role wins.
+	DefaultRole        string              // The role without a matching group, empty: these users can not log in.
+	PoolSize           int                 // The count of the idle connections kept open.
+	Timeout            time.Duration       // The timeout of the connection and of the operations.
+	FallbackToDatabase bool                // Checks the local accounts, when the directory is unavailable.
+}
+
+// LDAPAuthenticator checks the passwords of the directory users with a bind.
+// The users are searched with the service account on pooled connections, then their DN is bound with the password.
+// They are added to the users table at their first login, like the PAM users.
+type LDAPAuthenticator struct {
+	Config LDAPConfig
+
+	tlsConfig *tls.Config
+	mu        sync.Mutex
+	idle      []*ldap.Conn
+}
+
+// NewLDAPAuthenticator checks the configuration, and returns an authenticator with an empty pool.
+func NewLDAPAuthenticator(config LDAPConfig) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("invalid LDAP URL: %s", config.URL)
+	}
+	if config.StartTLS && u.Scheme == "ldaps" {
+		return nil, fmt.Errorf("StartTLS can not be used with ldaps: %s", config.URL)
+	}
+	if config.BaseDN == "" {
+		return nil, fmt.Errorf("the LDAP base DN is not set")
+	}
+	if strings.Count(config.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("invalid LDAP user filter, it must contain one %%s: %s", config.UserFilter)
+	}
+	if _, err := ldap.CompileFilter(fmt.Sprintf(config.UserFilter, "user")); err != nil {
+		return nil, fmt.Errorf("invalid LDAP user filter: %w", err)
+	}
+	if config.DefaultRole != "" && !ValidRole(config.DefaultRole) {
+		return nil, fmt.Errorf("invalid role: %s", config.DefaultRole)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	a := &LDAPAuthenticator{Config: config, tlsConfig: &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()}}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read LDAP CA file: %w", err)
+		}
+		a.tlsConfig.RootCAs = x509.NewCertPool()
+		if !a.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in the LDAP CA file: %s", config.CAFile)
+		}
+	}
+	return a, nil
+}
+
+// Authenticate searches the user, and binds its DN with the password. The role follows the groups at every login.
+// If the directory is unavailable, and the fallback is enabled, the local accounts of the users table are checked.
+func (a *LDAPAuthenticator) Authenticate(authFile, name, pass string) bool {
+	if name == "" || pass == "" {
+		return false
+	}
+
+	groups, err := a.bind(name, pass)
+	if err != nil {
+		if a.Config.FallbackToDatabase && errors.Is(err, errLDAPUnavailable) {
+			return DatabaseAuthenticator{}.Authenticate(authFile, name, pass)
+		}
+		return false
+	}
+
+	role := roleOfGroups(a.Config.RoleGroups, groups, strings.EqualFold)
+	mapped := role != ""
+	if !mapped {
+		role = a.Config.DefaultRole
+	}
+	if !ValidRole(role) {
+		return false
+	}
+	return syncExternalUser(authFile, name, BackendLDAP, role, mapped) == nil
+}
+
+// bind checks the password of the user in the directory, and returns its groups.
+func (a *LDAPAuthenticator) bind(name, pass string) ([]string, error) {
+	conn, err := a.serviceConn()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.Config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.Config.UserFilter, ldap.EscapeFilter(name)),
+		[]string{a.Config.GroupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, a.release(conn, err)
+	}
+	if len(result.Entries) != 1 {
+		a.put(conn)
+		return nil, fmt.Errorf("LDAP user not found: %s", name)
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, pass); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			a.put(conn)
+			return nil, err
+		}
+		return nil, a.release(conn, err)
+	}
+	a.put(conn)
+	return entry.GetEqualFoldAttributeValues(a.Config.GroupAttribute), nil
+}
+
+// serviceConn returns a connection bound with the service account.
+// A pooled connection may have been closed by the server, then a new one is dialed.
+func (a *LDAPAuthenticator) serviceConn() (*ldap.Conn, error) {
+	if conn := a.get(); conn != nil {
+		err := a.serviceBind(conn)
+		if err == nil {
+			return conn, nil
+		}
+		if err := a.release(conn, err); !errors.Is(err, errLDAPUnavailable) {
+			return nil, err
+		}
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errLDAPUnavailable, err)
+	}
+	if err := a.serviceBind(conn); err != nil {
+		return nil, a.release(conn, err)
+	}
+	return conn, nil
+}
+
+// serviceBind binds the service account, or binds anonymously without a bind DN.
+func (a *LDAPAuthenticator) serviceBind(conn *ldap.Conn) error {
+	if a.Config.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(a.Config.BindDN, a.Config.BindPassword)
+}
+
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.Config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.Config.Timeout}),
+		ldap.DialWithTLSConfig(a.tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.Config.Timeout)
+	if a.Config.StartTLS {
+		if err := conn.StartTLS(a.tlsConfig); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// release closes a connection after an error, and marks the error, if the directory is unavailable.
+// The results of the directory, e.g. invalid credentials, are returned as they are.
+func (a *LDAPAuthenticator) release(conn *ldap.Conn, err error) error {
+	_ = conn.Close()
+
+	var result *ldap.Error
+	if !errors.As(err, &result) || result.ResultCode == ldap.ErrorNetwork ||
+		result.ResultCode == ldap.LDAPResultBusy || result.ResultCode == ldap.LDAPResultUnavailable {
+		return fmt.Errorf("%w: %v", errLDAPUnavailable, err)
+	}
+	return err
+}
+
+func (a *LDAPAuthenticator) get() *ldap.Conn {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.idle) == 0 {
+		return nil
+	}
+	conn := a.idle[len(a.idle)-1]
+	a.idle = a.idle[:len(a.idle)-1]
+	return conn
+}
+
+func (a *LDAPAuthenticator) put(conn *ldap.Conn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.idle) >= a.Config.PoolSize {
+		_ = conn.Close()
+		return
+	}
+	a.idle = append(a.idle, conn)
+}
@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testLDAPEntry is an entry of the mock directory.
+type testLDAPEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// values returns the values of the attribute, the names are case-insensitive.
+func (e testLDAPEntry) values(name string) []string {
+	for n, values := range e.Attributes {
+		if strings.EqualFold(n, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// testLDAPServer is a mock LDAP directory on 127.0.0.1 for the tests of the LDAP authentication.
+// It supports simple binds, searches with and, or, not, equality and presence filters, and StartTLS.
+type testLDAPServer struct {
+	URL        string            // The ldap:// URL of the server.
+	CACert     []byte            // The PEM certificate of StartTLS, the clients must trust it.
+	Passwords  map[string]string // The passwords of the DNs.
+	Entries    []testLDAPEntry   // The entries of the directory.
+	RequireTLS bool              // Rejects the binds before StartTLS.
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	mu        sync.Mutex
+	conns     map[net.Conn]bool
+	binds     map[string]int // The count of the successful binds of each DN.
+	dials     int            // The count of the accepted connections.
+}
+
+// newTestLDAPDirectory starts a mock directory, it must be closed by the caller.
+func newTestLDAPDirectory() *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	cert, pemCert := selfSignedTestCert()
+	s := &testLDAPServer{
+		URL:       "ldap://" + listener.Addr().String(),
+		CACert:    pemCert,
+		Passwords: map[string]string{},
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		conns:     map[net.Conn]bool{},
+		binds:     map[string]int{},
+	}
+	go s.serve()
+	return s
+}
+
+// Close stops the server, and closes its connections.
+func (s *testLDAPServer) Close() {
+	_ = s.listener.Close()
+	s.CloseConnections()
+}
+
+// CloseConnections closes the open connections, like a server restart or an idle timeout.
+func (s *testLDAPServer) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+// Stats returns the count of the accepted connections, and the successful binds of the DN.
+func (s *testLDAPServer) Stats(dn string) (dials, binds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials, s.binds[dn]
+}
+
+func (s *testLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.dials++
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *testLDAPServer) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	secure := false
+	for {
+		message, err := ber.ReadPacket(reader)
+		if err != nil || len(message.Children) < 2 {
+			return
+		}
+		id, op := message.Children[0].Value, message.Children[1]
+
+		reply := func(op *ber.Packet) bool {
+			packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+			packet.AppendChild(op)
+			_, err := conn.Write(packet.Bytes())
+			return err == nil
+		}
+
+		switch {
+		case op.ClassType != ber.ClassApplication:
+			return
+		case op.Tag == ldap.ApplicationBindRequest:
+			if !reply(s.bind(op, secure)) {
+				return
+			}
+		case op.Tag == ldap.ApplicationSearchRequest:
+			for _, entry := range s.search(op) {
+				if !reply(entry) {
+					return
+				}
+			}
+			if !reply(testLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "")) {
+				return
+			}
+		case op.Tag == ldap.ApplicationExtendedRequest && !secure && op.Children[0].Data.String() == "1.3.6.1.4.1.1466.20037":
+			if !reply(testLDAPResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess, "")) {
+				return
+			}
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.conns[tlsConn] = true
+			s.mu.Unlock()
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		default:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) bind(op *ber.Packet, secure bool) *ber.Packet {
+	dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+	if s.RequireTLS && !secure {
+		return testLDAPResult(ldap.ApplicationBindResponse, ldap.LDAPResultConfidentialityRequired, "StartTLS is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dn == "" && password == "" {
+		return testLDAPResult(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "")
+	}
+	if p, ok := s.Passwords[dn]; !ok || p != password || password == "" {
+		return testLDAPResult(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials, "invalid credentials")
+	}
+	s.binds[dn]++
+	return testLDAPResult(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "")
+}
+
+func (s *testLDAPServer) search(op *ber.Packet) []*ber.Packet {
+	baseDN, filter := strings.ToLower(op.Children[0].Data.String()), op.Children[6]
+
+	var requested []string
+	for _, a := range op.Children[7].Children {
+		requested = append(requested, a.Data.String())
+	}
+
+	var entries []*ber.Packet
+	for _, e := range s.Entries {
+		if !strings.HasSuffix(strings.ToLower(e.DN), baseDN) || !testLDAPMatches(e, filter) {
+			continue
+		}
+
+		attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		for name, values := range e.Attributes {
+			if !testLDAPRequested(requested, name) {
+				continue
+			}
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+			}
+			attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, ""))
+		entry.AppendChild(attributes)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// testLDAPMatches evaluates the and, or, not, equality and presence filters, the others never match.
+func testLDAPMatches(e testLDAPEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, f := range filter.Children {
+			if !testLDAPMatches(e, f) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, f := range filter.Children {
+			if testLDAPMatches(e, f) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !testLDAPMatches(e, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		for _, v := range e.values(filter.Children[0].Data.String()) {
+			if strings.EqualFold(v, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(e.values(filter.Data.String())) > 0
+	}
+	return false
+}
+
+func testLDAPRequested(requested []string, name string) bool {
+	if len(requested) == 0 {
+		return true
+	}
+	for _, r := range requested {
+		if strings.EqualFold(r, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func testLDAPResult(tag ber.Tag, code uint16, message string) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, ""))
+	return result
+}
+
+func selfSignedTestCert() (tls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
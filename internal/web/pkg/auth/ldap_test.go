@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	WebLDAPSuite struct {
+		suite.Suite
+	}
+)
+
+const (
+	testServiceDN = "cn=monitor,ou=services,dc=example,dc=net"
+	testAliceDN   = "uid=alice,ou=people,dc=example,dc=net"
+)
+
+func newTestLDAPServer() *testLDAPServer {
+	server := newTestLDAPDirectory()
+	server.Passwords[testServiceDN] = "service password"
+	server.Passwords[testAliceDN] = "alice password"
+	server.Entries = []testLDAPEntry{
+		{DN: testAliceDN, Attributes: map[string][]string{
+			"objectClass": {"person"}, "uid": {"alice"}, "memberOf": {"CN=Ops,OU=Groups,DC=example,DC=net"},
+		}},
+		{DN: "uid=bob,ou=people,dc=example,dc=net", Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"bob"}}},
+	}
+	return server
+}
+
+func newTestLDAPConfig(server *testLDAPServer) LDAPConfig {
+	return LDAPConfig{
+		URL:            server.URL,
+		BindDN:         testServiceDN,
+		BindPassword:   "service password",
+		BaseDN:         "ou=people,dc=example,dc=net",
+		UserFilter:     "(&(objectClass=person)(uid=%s))",
+		GroupAttribute: "memberOf",
+		RoleGroups:     map[string][]string{RoleOperator: {"cn=ops,ou=groups,dc=example,dc=net"}},
+		PoolSize:       2,
+		Timeout:        time.Second,
+	}
+}
+
+func (s WebLDAPSuite) TestNewLDAPAuthenticator() {
+	config := LDAPConfig{URL: "ldaps://ldap.example.net", BaseDN: "dc=example,dc=net", UserFilter: "(uid=%s)"}
+	_, err := NewLDAPAuthenticator(config)
+	s.Equal(nil, err)
+
+	for _, invalid := range []struct {
+		change   func(c *LDAPConfig)
+		expected string
+	}{
+		{func(c *LDAPConfig) { c.URL = "ldap://" }, "invalid LDAP URL"},
+		{func(c *LDAPConfig) { c.StartTLS = true }, "StartTLS can not be used with ldaps"},
+		{func(c *LDAPConfig) { c.BaseDN = "" }, "base DN is not set"},
+		{func(c *LDAPConfig) { c.UserFilter = "(uid=alice)" }, "it must contain one %s"},
+		{func(c *LDAPConfig) { c.UserFilter = "uid=%s" }, "invalid LDAP user filter"},
+		{func(c *LDAPConfig) { c.DefaultRole = "root" }, "invalid role"},
+		{func(c *LDAPConfig) { c.CAFile = "not_exists.pem" }, "read LDAP CA file"},
+	} {
+		c := config
+		invalid.change(&c)
+		_, err := NewLDAPAuthenticator(c)
+		s.Contains(err.Error(), invalid.expected)
+	}
+}
+
+func (s WebLDAPSuite) TestLDAPAuthenticator() {
+	authdb := "test_ldap.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	server := newTestLDAPServer()
+	defer server.Close()
+
+	a, err := NewLDAPAuthenticator(newTestLDAPConfig(server))
+	s.Require().NoError(err)
+
+	s.False(a.Authenticate(authdb, "alice", "wrong"))
+	s.False(a.Authenticate(authdb, "alice", ""))
+	s.False(a.Authenticate(authdb, "not_exists", "alice password"))
+	s.False(a.Authenticate(authdb, "*", "alice password"))
+
+	// The user is added at the first login with the role of its groups, the group DNs are case-insensitive.
+	s.True(a.Authenticate(authdb, "alice", "alice password"))
+	role, err := GetUserRole(authdb, "alice")
+	s.Equal(nil, err)
+	s.Equal(RoleOperator, role)
+	s.False(DatabaseAuthenticator{}.Authenticate(authdb, "alice", "!"+BackendLDAP))
+
+	// Without a matching group, and without a default role, the user can not log in.
+	server.Passwords["uid=bob,ou=people,dc=example,dc=net"] = "bob password"
+	s.False(a.Authenticate(authdb, "bob", "bob password"))
+	a.Config.DefaultRole = RoleViewer
+	s.True(a.Authenticate(authdb, "bob", "bob password"))
+	role, _ = GetUserRole(authdb, "bob")
+	s.Equal(RoleViewer, role)
+
+	// The connections are reused.
+	dials, binds := server.Stats(testServiceDN)
+	s.Equal(1, dials)
+	s.Equal(6, binds)
+
+	// Disabled users can not log in.
+	s.Equal(nil, SetUserDisabled(authdb, "alice", true))
+	s.False(a.Authenticate(authdb, "alice", "alice password"))
+}
+
+func (s WebLDAPSuite) TestLDAPReconnect() {
+	authdb := "test_ldap.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	server := newTestLDAPServer()
+	defer server.Close()
+
+	a, err := NewLDAPAuthenticator(newTestLDAPConfig(server))
+	s.Require().NoError(err)
+	s.True(a.Authenticate(authdb, "alice", "alice password"))
+
+	// The pooled connection was closed by the server, a new one is dialed.
+	server.CloseConnections()
+	time.Sleep(10 * time.Millisecond)
+	s.True(a.Authenticate(authdb, "alice", "alice password"))
+	dials, _ := server.Stats(testServiceDN)
+	s.Equal(2, dials)
+
+	// A wrong service password is not a reason to use the local accounts.
+	a.Config.BindPassword = "wrong"
+	a.Config.FallbackToDatabase = true
+	s.False(a.Authenticate(authdb, "alice", "alice password"))
+	s.False(a.Authenticate(authdb, "username", "password"))
+}
+
+func (s WebLDAPSuite) TestLDAPFallback() {
+	authdb := "test_ldap.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	server := newTestLDAPServer()
+	a, err := NewLDAPAuthenticator(newTestLDAPConfig(server))
+	s.Require().NoError(err)
+	s.True(a.Authenticate(authdb, "alice", "alice password"))
+	server.Close()
+
+	// When the directory is unavailable, only the local accounts can log in, and only with the fallback.
+	s.False(a.Authenticate(authdb, "username", "password"))
+
+	a.Config.FallbackToDatabase = true
+	s.True(a.Authenticate(authdb, "username", "password"))
+	s.False(a.Authenticate(authdb, "username", "wrong"))
+	s.False(a.Authenticate(authdb, "alice", "alice password"))
+}
+
+func (s WebLDAPSuite) TestLDAPStartTLS() {
+	authdb := "test_ldap.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	server := newTestLDAPServer()
+	defer server.Close()
+	server.RequireTLS = true
+
+	caFile := "test_ldap_ca.pem"
+	defer os.Remove(caFile)
+	s.Require().NoError(os.WriteFile(caFile, server.CACert, 0600))
+
+	config := newTestLDAPConfig(server)
+	a, err := NewLDAPAuthenticator(config)
+	s.Require().NoError(err)
+	s.False(a.Authenticate(authdb, "alice", "alice password"))
+
+	// The certificate of the server must be trusted.
+	config.StartTLS = true
+	a, err = NewLDAPAuthenticator(config)
+	s.Require().NoError(err)
+	s.False(a.Authenticate(authdb, "alice", "alice password"))
+
+	config.CAFile = caFile
+	a, err = NewLDAPAuthenticator(config)
+	s.Require().NoError(err)
+	s.True(a.Authenticate(authdb, "alice", "alice password"))
+}
+
+func TestWebLDAPSuite(t *testing.T) {
+	suite.Run(t, new(WebLDAPSuite))
+}
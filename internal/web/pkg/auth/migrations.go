@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migrations hold the schema changes of the auth database in order.
+// The number of the applied migrations is stored in the 'user_version' pragma of the database,
+// so new migrations must be appended to the end of the list, and the existing ones must not be changed.
+// The first migrations can run on databases created before the versioning was introduced,
+// therefore they must not fail when their changes are already there.
+var migrations = []func(tx *sql.Tx) error{
+	// 1: users and their UI settings.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS users (
+				username TEXT PRIMARY KEY,
+				password_hash TEXT NOT NULL
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE users: %w", err)
+		}
+
+		_, err = tx.Exec(`
+			CREATE TABLE IF NOT EXISTS user_settings (
+				username  TEXT NOT NULL,
+				key_name  TEXT NOT NULL,
+				value     TEXT,
+				PRIMARY KEY (username, key_name),
+				FOREIGN KEY (username) REFERENCES users(username)
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE user_settings: %w", err)
+		}
+		return nil
+	},
+	// 2: roles, the users created before the roles were introduced keep full access.
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "users", "role", "TEXT NOT NULL DEFAULT 'admin'")
+	},
+	// 3: disabled users.
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "users", "disabled", "INTEGER NOT NULL DEFAULT 0")
+	},
+	// 4: TOTP two-factor authentication and the hashed recovery codes.
+	func(tx *sql.Tx) error {
+		for _, column := range [][2]string{
+			{"totp_secret", "TEXT NOT NULL DEFAULT ''"},
+			{"totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+			{"totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+		} {
+			if err := addColumn(tx, "users", column[0], column[1]); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				username  TEXT NOT NULL,
+				code_hash TEXT NOT NULL,
+				PRIMARY KEY (username, code_hash),
+				FOREIGN KEY (username) REFERENCES users(username)
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE recovery_codes: %w", err)
+		}
+		return nil
+	},
+	// 5: server-side sessions, and the persistent keys of the cookies.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS sessions (
+				id         TEXT PRIMARY KEY,
+				username   TEXT NOT NULL,
+				ip         TEXT NOT NULL,
+				user_agent TEXT NOT NULL,
+				created_at INTEGER NOT NULL,
+				last_seen  INTEGER NOT NULL,
+				FOREIGN KEY (username) REFERENCES users(username)
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE sessions: %w", err)
+		}
+
+		_, err = tx.Exec(`
+			CREATE TABLE IF NOT EXISTS secrets (
+				name  TEXT PRIMARY KEY,
+				value BLOB NOT NULL
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE secrets: %w", err)
+		}
+		return nil
+	},
+	// 6: failed login attempts per IP and per user.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS login_failures (
+				key           TEXT PRIMARY KEY,
+				failures      INTEGER NOT NULL,
+				last_failure  INTEGER NOT NULL,
+				blocked_until INTEGER NOT NULL,
+				locked        INTEGER NOT NULL DEFAULT 0
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE login_failures: %w", err)
+		}
+		return nil
+	},
+	// 7: CSRF tokens of the sessions.
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "sessions", "csrf_token", "TEXT NOT NULL DEFAULT ''")
+	},
+	// 8: append-only audit log of the logins and the privileged actions.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS audit_log (
+				id       INTEGER PRIMARY KEY AUTOINCREMENT,
+				time     INTEGER NOT NULL,
+				username TEXT NOT NULL,
+				ip       TEXT NOT NULL,
+				action   TEXT NOT NULL,
+				params   TEXT NOT NULL,
+				result   TEXT NOT NULL
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE audit_log: %w", err)
+		}
+
+		for _, statement := range []string{"UPDATE", "DELETE"} {
+			_, err = tx.Exec(fmt.Sprintf(`
+				CREATE TRIGGER IF NOT EXISTS audit_log_no_%s BEFORE %s ON audit_log
+				BEGIN
+					SELECT RAISE(ABORT, 'the audit log is append-only');
+				END
+			`, strings.ToLower(statement), statement))
+			if err != nil {
+				return fmt.Errorf("CREATE TRIGGER audit_log: %w", err)
+			}
+		}
+
+		_, err = tx.Exec("CREATE INDEX IF NOT EXISTS audit_log_time ON audit_log (time)")
+		if err != nil {
+			return fmt.Errorf("CREATE INDEX audit_log: %w", err)
+		}
+		return nil
+	},
+	// 9: personal API tokens, only their hashes are stored.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id         TEXT PRIMARY KEY,
+				token_hash TEXT NOT NULL UNIQUE,
+				username   TEXT NOT NULL,
+				name       TEXT NOT NULL,
+				scope      TEXT NOT NULL,
+				created_at INTEGER NOT NULL,
+				expires_at INTEGER NOT NULL,
+				last_used  INTEGER NOT NULL DEFAULT 0,
+				FOREIGN KEY (username) REFERENCES users(username)
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("CREATE TABLE api_tokens: %w", err)
+		}
+		return nil
+	},
+}
+
+// migrate applies the missing migrations, each of them in its own transaction.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("PRAGMA user_version: %w", err)
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("the schema version of the auth database: %d is newer than the supported: %d", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		if err := migrations[i](tx); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		// PRAGMA does not support parameters, the version is a number.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// addColumn adds a column to a table, if it does not exist yet.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("table_info %s: %w", table, err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("ALTER TABLE %s: %w", table, err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// BackendOIDC is the name of the OpenID Connect login in the audit log and in the users table.
+const BackendOIDC = "oidc"
+
+// OIDCConfig describes the OpenID Connect provider, and how its users are mapped to local users.
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string              // The claim used as the local username, e.g. email.
+	GroupsClaim   string              // The claim, which lists the groups of the user.
+	RoleGroups    map[string][]string // The groups of each role, the most privileged matching role wins.
+	DefaultRole   string              // The role without a matching group, empty: these users can not log in.
+}
+
+// OIDCProvider runs the authorization code flow with PKCE against an OpenID Connect provider.
+// It is safe for concurrent use, the discovery document is fetched once, and the keys are cached by go-oidc.
+type OIDCProvider struct {
+	Config OIDCConfig
+	Client *http.Client
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// OIDCLogin holds the secrets of a login in progress, between the redirect to the provider and the callback.
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// OIDCIdentity is the verified identity of a user from the ID token.
+type OIDCIdentity struct {
+	Subject  string
+	UserName string
+	Groups   []string
+}
+
+// NewOIDCProvider returns a provider, its discovery document is fetched at the first login.
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewOIDCLogin generates the state, the nonce and the PKCE code verifier of a new login.
+func NewOIDCLogin() (OIDCLogin, error) {
+	var login OIDCLogin
+	for _, v := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		token, err := randomToken()
+		if err != nil {
+			return OIDCLogin{}, err
+		}
+		*v = token
+	}
+	return login, nil
+}
+
+// SetOIDCLogin stores the secrets of the login in a short-lived signed and encrypted cookie.
+func SetOIDCLogin(path string, login OIDCLogin, response http.ResponseWriter) error {
+	encoded, err := PendingCookieHandler.Encode("oidc", login)
+	if err != nil {
+		return fmt.Errorf("encode oidc cookie: %w", err)
+	}
+
+	// Lax, so the cookie is sent, when the provider redirects back to the callback.
+	http.SetCookie(response, &http.Cookie{
+		Name:     "oidc",
+		Value:    encoded,
+		Path:     path,
+		MaxAge:   PendingLoginSeconds,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// GetOIDCLogin returns the secrets of the login in progress from its cookie.
+func GetOIDCLogin(request *http.Request) (login OIDCLogin) {
+	if cookie, err := request.Cookie("oidc"); err == nil {
+		_ = PendingCookieHandler.Decode("oidc", cookie.Value, &login)
+	}
+	return login
+}
+
+// ClearOIDCLogin removes the cookie of the login in progress, so its state can be used only once.
+func ClearOIDCLogin(path string, response http.ResponseWriter) {
+	http.SetCookie(response, &http.Cookie{
+		Name:     "oidc",
+		Value:    "",
+		Path:     path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// AuthCodeURL returns the URL of the provider, where the browser is redirected to log in.
+func (p *OIDCProvider) AuthCodeURL(login OIDCLogin) (string, error) {
+	provider, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(provider).AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier)), nil
+}
+
+// ValidState checks the state returned by the provider against the state of the login, in constant time.
+func (login OIDCLogin) ValidState(state string) bool {
+	return login.State != "" && subtle.ConstantTimeCompare([]byte(login.State), []byte(state)) == 1
+}
+
+// Exchange redeems the authorization code at the provider, and verifies the returned ID token.
+func (p *OIDCProvider) Exchange(code string, login OIDCLogin) (OIDCIdentity, error) {
+	provider, err := p.discover()
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	ctx := p.context()
+	token, err := p.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("token request: %w", err)
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return OIDCIdentity{}, fmt.Errorf("token request: no id_token in the response")
+	}
+
+	claims, err := p.verifyIDToken(provider, rawIDToken)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	return p.identity(claims, login.Nonce)
+}
+
+// MapUser returns the local user of the identity, and adds it to the users table at its first login.
+// The role of the user follows its groups at every login, if any of them is mapped to a role.
+// Disabled users, and the users without a role can not log in.
+func (p *OIDCProvider) MapUser(authFile string, identity OIDCIdentity) (string, error) {
+	role := roleOfGroups(p.Config.RoleGroups, identity.Groups, func(a, b string) bool { return a == b })
+	mapped := role != ""
+	if !mapped {
+		role = p.Config.DefaultRole
+	}
+	if !ValidRole(role) {
+		return "", fmt.Errorf("no role for the groups of the user: %s", identity.UserName)
+	}
+
+	if err := syncExternalUser(authFile, identity.UserName, BackendOIDC, role, mapped); err != nil {
+		return "", err
+	}
+	return identity.UserName, nil
+}
+
+// discover fetches the discovery document of the provider at the first login.
+// If it fails, e.g. the provider is unavailable, it is fetched again at the next login.
+func (p *OIDCProvider) discover() (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(p.context(), p.Config.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("OIDC discovery: %w", err)
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+// context returns the context of the requests to the provider, they are sent with the client of the provider.
+func (p *OIDCProvider) context() context.Context {
+	ctx := oidc.ClientContext(context.Background(), p.Client)
+	return context.WithValue(ctx, oauth2.HTTPClient, p.Client)
+}
+
+// oauth2Config sends the secret of a confidential client with basic auth, the public clients send only their ID.
+func (p *OIDCProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	endpoint := provider.Endpoint()
+	endpoint.AuthStyle = oauth2.AuthStyleInParams
+	if p.Config.ClientSecret != "" {
+		endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	}
+	return &oauth2.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		RedirectURL:  p.Config.RedirectURL,
+		Scopes:       p.Config.Scopes,
+		Endpoint:     endpoint,
+	}
+}
+
+// verifyIDToken checks the signature, the issuer, the audience and the expiry of the ID token, and returns its claims.
+func (p *OIDCProvider) verifyIDToken(provider *oidc.Provider, rawIDToken string) (map[string]interface{}, error) {
+	verifier := provider.Verifier(&oidc.Config{ClientID: p.Config.ClientID, Now: timeNow})
+	idToken, err := verifier.Verify(p.context(), rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("ID token: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("ID token claims: %w", err)
+	}
+	return claims, nil
+}
+
+// identity maps the claims of a verified ID token to the identity of the user.
+func (p *OIDCProvider) identity(claims map[string]interface{}, nonce string) (OIDCIdentity, error) {
+	if n, _ := claims["nonce"].(string); n == "" || subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return OIDCIdentity{}, fmt.Errorf("ID token: nonce mismatch")
+	}
+
+	usernameClaim := p.Config.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "email"
+	}
+	userName, _ := claims[usernameClaim].(string)
+	if userName == "" {
+		return OIDCIdentity{}, fmt.Errorf("ID token: no %s claim", usernameClaim)
+	}
+	if verified, ok := claims["email_verified"].(bool); usernameClaim == "email" && ok && !verified {
+		return OIDCIdentity{}, fmt.Errorf("ID token: the email is not verified: %s", userName)
+	}
+
+	identity := OIDCIdentity{UserName: userName}
+	identity.Subject, _ = claims["sub"].(string)
+
+	switch groups := claims[p.Config.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+	return identity, nil
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/takattila/monitor/internal/web/pkg/oidctest"
+)
+
+type (
+	WebOIDCSuite struct {
+		suite.Suite
+	}
+)
+
+func newTestOIDCProvider(mock *oidctest.Provider) *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Issuer:        mock.Issuer(),
+		ClientID:      mock.ClientID,
+		ClientSecret:  mock.ClientSecret,
+		RedirectURL:   "http://monitor.test/monitor/oidc/callback",
+		Scopes:        []string{"openid", "email", "groups"},
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+		RoleGroups: map[string][]string{
+			RoleAdmin:    {"monitor-admins"},
+			RoleOperator: {"monitor-operators"},
+		},
+		DefaultRole: RoleViewer,
+	})
+}
+
+// authorize follows the redirect to the mock provider, and returns the code and the state of the callback.
+func authorize(t *testing.T, p *OIDCProvider, login OIDCLogin) (code, state string) {
+	target, err := p.AuthCodeURL(login)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	defer resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(callback.String(), p.Config.RedirectURL) {
+		t.Fatalf("authorize: status: %d, location: %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func (s WebOIDCSuite) TestOIDCLogin() {
+	authdb := "test_oidc.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	mock := oidctest.NewProvider("monitor", "secret")
+	defer mock.Close()
+	mock.Claims = map[string]interface{}{"email": "alice@example.net", "email_verified": true, "groups": []string{"monitor-operators"}}
+
+	p := newTestOIDCProvider(mock)
+	login, err := NewOIDCLogin()
+	s.Require().NoError(err)
+
+	code, state := authorize(s.T(), p, login)
+	s.True(login.ValidState(state))
+	s.False(login.ValidState(state + "x"))
+	s.False(OIDCLogin{}.ValidState(""))
+
+	identity, err := p.Exchange(code, login)
+	s.Require().NoError(err)
+	s.Equal("alice@example.net", identity.UserName)
+	s.Equal("subject", identity.Subject)
+	s.Equal([]string{"monitor-operators"}, identity.Groups)
+
+	// The user is added at the first login, with the role of its groups, and the stored hash never matches.
+	userName, err := p.MapUser(authdb, identity)
+	s.Equal(nil, err)
+	s.Equal("alice@example.net", userName)
+	role, err := GetUserRole(authdb, userName)
+	s.Equal(nil, err)
+	s.Equal(RoleOperator, role)
+	s.False(DatabaseAuthenticator{}.Authenticate(authdb, userName, "!"+BackendOIDC))
+
+	// The codes can be redeemed only once, and only with the verifier of the login.
+	_, err = p.Exchange(code, login)
+	s.Contains(err.Error(), "invalid_grant")
+
+	code, _ = authorize(s.T(), p, login)
+	_, err = p.Exchange(code, OIDCLogin{Nonce: login.Nonce, Verifier: "wrong"})
+	s.Contains(err.Error(), "invalid_grant")
+
+	// The nonce of the ID token must match the nonce of the login.
+	code, _ = authorize(s.T(), p, login)
+	_, err = p.Exchange(code, OIDCLogin{Nonce: "other", Verifier: login.Verifier})
+	s.Contains(err.Error(), "nonce mismatch")
+
+	// A wrong client secret is rejected by the provider.
+	p.Config.ClientSecret = "wrong"
+	code, _ = authorize(s.T(), p, login)
+	_, err = p.Exchange(code, login)
+	s.Contains(err.Error(), "invalid_client")
+}
+
+func (s WebOIDCSuite) TestOIDCDiscovery() {
+	mock := oidctest.NewProvider("monitor", "")
+	defer mock.Close()
+
+	p := newTestOIDCProvider(mock)
+	p.Config.Issuer = mock.Issuer() + "/"
+	_, err := p.AuthCodeURL(OIDCLogin{})
+	s.Contains(err.Error(), "did not match the issuer URL")
+
+	p = newTestOIDCProvider(mock)
+	target, err := p.AuthCodeURL(OIDCLogin{State: "state", Nonce: "nonce", Verifier: "verifier"})
+	s.Equal(nil, err)
+	u, err := url.Parse(target)
+	s.Require().NoError(err)
+	s.Equal(mock.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	s.Equal("S256", u.Query().Get("code_challenge_method"))
+	s.Equal("openid email groups", u.Query().Get("scope"))
+	s.Equal("state", u.Query().Get("state"))
+	s.NotEqual("verifier", u.Query().Get("code_challenge"))
+}
+
+func (s WebOIDCSuite) TestOIDCDiscoveryConcurrent() {
+	mock := oidctest.NewProvider("monitor", "")
+	defer mock.Close()
+
+	// The discovery document is fetched once, the logins may start at the same time.
+	p := newTestOIDCProvider(mock)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.AuthCodeURL(OIDCLogin{State: "state", Nonce: "nonce", Verifier: "verifier"})
+			s.Equal(nil, err)
+		}()
+	}
+	wg.Wait()
+
+	provider, err := p.discover()
+	s.Equal(nil, err)
+	s.Equal(p.provider, provider)
+}
+
+func (s WebOIDCSuite) TestVerifyIDToken() {
+	mock := oidctest.NewProvider("monitor", "")
+	defer mock.Close()
+
+	p := newTestOIDCProvider(mock)
+	provider, err := p.discover()
+	s.Require().NoError(err)
+
+	now := time.Unix(1700000000, 0)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": mock.Issuer(), "aud": "monitor", "sub": "subject",
+			"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(),
+		}
+		for k, v := range changes {
+			c[k] = v
+		}
+		return c
+	}
+
+	_, err = p.verifyIDToken(provider, mock.SignIDToken(claims(nil)))
+	s.Equal(nil, err)
+	_, err = p.verifyIDToken(provider, mock.SignIDToken(claims(map[string]interface{}{"aud": []string{"other", "monitor"}})))
+	s.Equal(nil, err)
+
+	_, err = p.verifyIDToken(provider, mock.SignIDToken(claims(map[string]interface{}{"iss": "https://evil.example.net"})))
+	s.Contains(err.Error(), "issued by a different provider")
+	_, err = p.verifyIDToken(provider, mock.SignIDToken(claims(map[string]interface{}{"aud": "other"})))
+	s.Contains(err.Error(), "expected audience")
+	_, err = p.verifyIDToken(provider, mock.SignIDToken(claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})))
+	s.Contains(err.Error(), "expired")
+	_, err = p.verifyIDToken(provider, mock.SignIDToken(claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})))
+	s.Contains(err.Error(), "before the nbf")
+
+	// A tampered payload, and the tokens without signature are rejected.
+	token := strings.Split(mock.SignIDToken(claims(nil)), ".")
+	other := strings.Split(mock.SignIDToken(claims(map[string]interface{}{"sub": "admin"})), ".")
+	_, err = p.verifyIDToken(provider, token[0]+"."+other[1]+"."+token[2])
+	s.Contains(err.Error(), "failed to verify signature")
+	_, err = p.verifyIDToken(provider, token[0]+"."+token[1]+".")
+	s.Contains(err.Error(), "failed to verify signature")
+	_, err = p.verifyIDToken(provider, "not a token")
+	s.Contains(err.Error(), "malformed")
+}
+
+func (s WebOIDCSuite) TestOIDCIdentity() {
+	p := NewOIDCProvider(OIDCConfig{GroupsClaim: "groups"})
+
+	identity, err := p.identity(map[string]interface{}{"nonce": "n", "email": "bob@example.net", "groups": "ops"}, "n")
+	s.Equal(nil, err)
+	s.Equal([]string{"ops"}, identity.Groups)
+
+	_, err = p.identity(map[string]interface{}{"email": "bob@example.net"}, "")
+	s.Contains(err.Error(), "nonce mismatch")
+
+	_, err = p.identity(map[string]interface{}{"nonce": "n"}, "n")
+	s.Contains(err.Error(), "no email claim")
+
+	_, err = p.identity(map[string]interface{}{"nonce": "n", "email": "bob@example.net", "email_verified": false}, "n")
+	s.Contains(err.Error(), "not verified")
+
+	p.Config.UsernameClaim = "preferred_username"
+	identity, err = p.identity(map[string]interface{}{"nonce": "n", "preferred_username": "bob"}, "n")
+	s.Equal(nil, err)
+	s.Equal("bob", identity.UserName)
+}
+
+func (s WebOIDCSuite) TestOIDCMapUser() {
+	authdb := "test_oidc.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	p := NewOIDCProvider(OIDCConfig{RoleGroups: map[string][]string{
+		RoleAdmin:    {"admins"},
+		RoleOperator: {"operators"},
+	}})
+
+	// Without a matching group, and without a default role, the user can not log in.
+	_, err := p.MapUser(authdb, OIDCIdentity{UserName: "carol", Groups: []string{"staff"}})
+	s.Contains(err.Error(), "no role for the groups of the user")
+
+	// The most privileged matching role wins, and the role follows the groups at every login.
+	_, err = p.MapUser(authdb, OIDCIdentity{UserName: "carol", Groups: []string{"operators", "admins"}})
+	s.Equal(nil, err)
+	role, _ := GetUserRole(authdb, "carol")
+	s.Equal(RoleAdmin, role)
+
+	_, err = p.MapUser(authdb, OIDCIdentity{UserName: "carol", Groups: []string{"operators"}})
+	s.Equal(nil, err)
+	role, _ = GetUserRole(authdb, "carol")
+	s.Equal(RoleOperator, role)
+
+	// Without a matching group, the default role is used for the new users, and the role of the others is kept.
+	p.Config.DefaultRole = RoleViewer
+	_, err = p.MapUser(authdb, OIDCIdentity{UserName: "carol"})
+	s.Equal(nil, err)
+	role, _ = GetUserRole(authdb, "carol")
+	s.Equal(RoleOperator, role)
+
+	_, err = p.MapUser(authdb, OIDCIdentity{UserName: "dave"})
+	s.Equal(nil, err)
+	role, _ = GetUserRole(authdb, "dave")
+	s.Equal(RoleViewer, role)
+
+	// Disabled users, and the invalid usernames are rejected.
+	s.Equal(nil, SetUserDisabled(authdb, "carol", true))
+	_, err = p.MapUser(authdb, OIDCIdentity{UserName: "carol", Groups: []string{"admins"}})
+	s.Contains(err.Error(), "user is disabled")
+
+	_, err = p.MapUser(authdb, OIDCIdentity{UserName: "bad name"})
+	s.Contains(err.Error(), "invalid username")
+}
+
+func (s WebOIDCSuite) TestOIDCLoginCookie() {
+	authdb := "test_oidc.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	login := OIDCLogin{State: "state", Nonce: "nonce", Verifier: "verifier"}
+	recorder := httptest.NewRecorder()
+	s.Equal(nil, SetOIDCLogin("/monitor", login, recorder))
+
+	request := httptest.NewRequest("GET", "/monitor/oidc/callback", nil)
+	for _, c := range recorder.Result().Cookies() {
+		s.Equal(http.SameSiteLaxMode, c.SameSite)
+		s.True(c.HttpOnly)
+		s.NotContains(c.Value, "verifier")
+		request.AddCookie(c)
+	}
+	s.Equal(login, GetOIDCLogin(request))
+
+	recorder = httptest.NewRecorder()
+	ClearOIDCLogin("/monitor", recorder)
+	s.Equal(-1, recorder.Result().Cookies()[0].MaxAge)
+
+	s.Equal(OIDCLogin{}, GetOIDCLogin(httptest.NewRequest("GET", "/monitor/oidc/callback", nil)))
+}
+
+func TestWebOIDCSuite(t *testing.T) {
+	suite.Run(t, new(WebOIDCSuite))
+}
@@ -0,0 +1,66 @@
+//go:build pam
+
+package auth
+
+/*
+#cgo LDFLAGS: -lpam
+#include <security/pam_appl.h>
+#include <stdlib.h>
+#include <string.h>
+
+// monitor_pam_conv answers the password prompts of PAM with the password in appdata_ptr.
+static int monitor_pam_conv(int n, const struct pam_message **msg, struct pam_response **resp, void *appdata_ptr) {
+	struct pam_response *r = calloc(n, sizeof(struct pam_response));
+	if (r == NULL) {
+		return PAM_BUF_ERR;
+	}
+	for (int i = 0; i < n; i++) {
+		if (msg[i]->msg_style == PAM_PROMPT_ECHO_OFF) {
+			r[i].resp = strdup((const char *)appdata_ptr);
+		}
+	}
+	*resp = r;
+	return PAM_SUCCESS;
+}
+
+static int monitor_pam_authenticate(const char *service, const char *user, const char *pass) {
+	struct pam_conv conv = { monitor_pam_conv, (void *)pass };
+	pam_handle_t *pamh = NULL;
+
+	int ret = pam_start(service, user, &conv, &pamh);
+	if (ret != PAM_SUCCESS) {
+		return ret;
+	}
+
+	ret = pam_authenticate(pamh, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	if (ret == PAM_SUCCESS) {
+		ret = pam_acct_mgmt(pamh, PAM_SILENT);
+	}
+
+	pam_end(pamh, ret);
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// pamAvailable reports whether PAM is compiled in, the PAM backend can not be used without it.
+const pamAvailable = true
+
+// pamAuthenticateSystem checks the password of a system user with the PAM service,
+// and whether the account is valid (e.g. not expired).
+func pamAuthenticateSystem(service, name, pass string) error {
+	cService, cName, cPass := C.CString(service), C.CString(name), C.CString(pass)
+	defer C.free(unsafe.Pointer(cService))
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cPass))
+
+	if ret := C.monitor_pam_authenticate(cService, cName, cPass); ret != C.PAM_SUCCESS {
+		return fmt.Errorf("PAM authentication failed: %d", int(ret))
+	}
+	return nil
+}
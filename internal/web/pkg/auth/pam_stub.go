@@ -0,0 +1,13 @@
+//go:build !pam
+
+package auth
+
+import "fmt"
+
+// pamAvailable reports whether PAM is compiled in, the PAM backend can not be used without it.
+const pamAvailable = false
+
+// pamAuthenticateSystem is not available without the 'pam' build tag: go build -tags pam ...
+func pamAuthenticateSystem(service, name, pass string) error {
+	return fmt.Errorf("PAM support is not compiled in, build with: -tags pam")
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Roles of the users.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// Permissions, which are required by the web routes.
+const (
+	PermView     = "view"
+	PermOperate  = "operate" // Turning the sections of the API on and off.
+	PermRun      = "run"
+	PermServices = "services"
+	PermKill     = "kill"
+	PermPower    = "power"
+	PermTerminal = "terminal"
+	PermUsers    = "users"
+	PermAudit    = "audit"
+)
+
+// rolePermissions describes what the users of each role are allowed to do.
+var rolePermissions = map[string][]string{
+	RoleViewer:   {PermView},
+	RoleOperator: {PermView, PermOperate, PermRun, PermServices, PermKill},
+	RoleAdmin:    {PermView, PermOperate, PermRun, PermServices, PermKill, PermPower, PermTerminal, PermUsers, PermAudit},
+}
+
+// Roles returns the names of the roles, from the least to the most privileged.
+func Roles() []string {
+	return []string{RoleViewer, RoleOperator, RoleAdmin}
+}
+
+// roleOfGroups returns the most privileged role, which has any of the groups, or "".
+func roleOfGroups(roleGroups map[string][]string, groups []string, equal func(a, b string) bool) string {
+	roles := Roles()
+	for i := len(roles) - 1; i >= 0; i-- {
+		for _, roleGroup := range roleGroups[roles[i]] {
+			for _, group := range groups {
+				if equal(group, roleGroup) {
+					return roles[i]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// ValidRole checks whether the role exists.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Permissions returns the permissions of a role.
+func Permissions(role string) []string {
+	return append([]string{}, rolePermissions[role]...)
+}
+
+// HasPermission checks whether the role grants the permission.
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUserRole returns the role of a user from the users table.
+// Disabled users have no role.
+func GetUserRole(authFile, username string) (string, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var role string
+	var disabled bool
+	err = db.QueryRow("SELECT role, disabled FROM users WHERE username = ?", username).Scan(&role, &disabled)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return "", fmt.Errorf("SELECT users: %w", err)
+	}
+	if disabled {
+		return "", fmt.Errorf("%w: %s", ErrUserDisabled, username)
+	}
+	return role, nil
+}
+
+// SetUserRole changes the role of a user.
+func SetUserRole(authFile, username, role string) error {
+	return UpdateUser(authFile, username, UserUpdate{Role: &role})
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	_ "modernc.org/sqlite"
+)
+
+type (
+	WebRolesSuite struct {
+		suite.Suite
+	}
+)
+
+func (s WebRolesSuite) TestHasPermission() {
+	s.True(HasPermission(RoleViewer, PermView))
+	s.False(HasPermission(RoleViewer, PermRun))
+	s.True(HasPermission(RoleOperator, PermRun))
+	s.True(HasPermission(RoleOperator, PermKill))
+	s.False(HasPermission(RoleOperator, PermPower))
+	s.False(HasPermission(RoleOperator, PermTerminal))
+	s.True(HasPermission(RoleAdmin, PermPower))
+	s.True(HasPermission(RoleAdmin, PermTerminal))
+	s.False(HasPermission("", PermView))
+	s.False(HasPermission("unknown", PermView))
+}
+
+func (s WebRolesSuite) TestValidRole() {
+	for _, role := range Roles() {
+		s.True(ValidRole(role))
+	}
+	s.False(ValidRole("root"))
+	s.Equal([]string{PermView}, Permissions(RoleViewer))
+	s.Equal(0, len(Permissions("unknown")))
+}
+
+func (s WebRolesSuite) TestGetAndSetUserRole() {
+	authdb := "test_roles.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	db, err := sql.Open("sqlite", authdb)
+	s.Require().NoError(err)
+	_, err = db.Exec("CREATE TABLE users (username TEXT PRIMARY KEY, password_hash TEXT NOT NULL)")
+	s.Require().NoError(err)
+	_, err = db.Exec("INSERT INTO users (username, password_hash) VALUES ('legacy', 'x')")
+	s.Require().NoError(err)
+	db.Close()
+
+	// Users created before the roles were introduced are admins.
+	role, err := GetUserRole(authdb, "legacy")
+	s.Equal(nil, err)
+	s.Equal(RoleAdmin, role)
+
+	// The last admin can not be demoted.
+	s.Contains(SetUserRole(authdb, "legacy", RoleViewer).Error(), "the last admin can not be removed")
+	s.Equal(nil, CreateUser(authdb, "admin", "password", RoleAdmin))
+
+	s.Equal(nil, SetUserRole(authdb, "legacy", RoleViewer))
+	role, err = GetUserRole(authdb, "legacy")
+	s.Equal(nil, err)
+	s.Equal(RoleViewer, role)
+
+	s.Contains(SetUserRole(authdb, "legacy", "root").Error(), "invalid role")
+	s.Contains(SetUserRole(authdb, "not_exists", RoleViewer).Error(), "user does not exist")
+
+	_, err = GetUserRole(authdb, "not_exists")
+	s.Contains(err.Error(), "user does not exist")
+}
+
+func (s WebRolesSuite) TestSaveCredentialsKeepsRole() {
+	authdb := "test_roles_credentials.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	oldPrompt := terminalPrompt
+	defer func() { terminalPrompt = oldPrompt }()
+	terminalPrompt = func(prompt string) string { return "user" }
+
+	s.Equal(nil, SaveCredentials(authdb, true))
+	role, err := GetUserRole(authdb, "user")
+	s.Equal(nil, err)
+	s.Equal(RoleAdmin, role)
+
+	s.Equal(nil, CreateUser(authdb, "admin", "password", RoleAdmin))
+	s.Equal(nil, SetUserRole(authdb, "user", RoleOperator))
+	s.Equal(nil, SaveCredentials(authdb, true))
+	role, err = GetUserRole(authdb, "user")
+	s.Equal(nil, err)
+	s.Equal(RoleOperator, role)
+}
+
+func TestWebRolesSuite(t *testing.T) {
+	suite.Run(t, new(WebRolesSuite))
+}
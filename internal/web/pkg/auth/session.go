@@ -13,27 +13,84 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// PendingLoginSeconds is the time to enter the second factor after the password.
+const PendingLoginSeconds = 5 * 60
+
 var (
 	CookieHandler = securecookie.New(
 		securecookie.GenerateRandomKey(64),
 		securecookie.GenerateRandomKey(32))
 
+	// PendingCookieHandler signs the cookie of the logins, which wait for the second factor.
+	PendingCookieHandler = securecookie.New(
+		securecookie.GenerateRandomKey(64),
+		securecookie.GenerateRandomKey(32)).MaxAge(PendingLoginSeconds)
+
 	terminalPrompt = func(prompt string) string {
 		return terminal.Prompt(prompt)
 	}
 )
 
-// SetSession creates session cookie.
-func SetSession(path, userName string, response http.ResponseWriter) {
+// SetSession creates a server-side session, and sends its token in the session cookie.
+func SetSession(path, userName string, response http.ResponseWriter, request *http.Request) error {
+	token, err := createSession(userName, request.RemoteAddr, request.UserAgent())
+	if err != nil {
+		return err
+	}
+
+	value := map[string]string{
+		"token": token,
+	}
+	encoded, err := CookieHandler.Encode("session", value)
+	if err != nil {
+		return fmt.Errorf("encode session cookie: %w", err)
+	}
+
+	cookie := &http.Cookie{
+		Name:     "session",
+		Value:    encoded,
+		Path:     path,
+		MaxAge:   int(Sessions.AbsoluteTimeout.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	http.SetCookie(response, cookie)
+	return nil
+}
+
+// ClearSession removes the session of the request, and its cookie.
+func ClearSession(path string, response http.ResponseWriter, request *http.Request) {
+	if token := sessionToken(request); token != "" {
+		if db, err := initDB(Sessions.AuthFile); err == nil {
+			_, _ = db.Exec("DELETE FROM sessions WHERE id = ?", sessionID(token))
+			db.Close()
+		}
+	}
+
+	cookie := &http.Cookie{
+		Name:     "session",
+		Value:    "",
+		Path:     path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(response, cookie)
+}
+
+// SetPendingLogin creates a short-lived cookie for a user,
+// whose password was accepted, but the second factor is still needed.
+func SetPendingLogin(path, userName string, response http.ResponseWriter) {
 	value := map[string]string{
 		"name": userName,
 	}
-	if encoded, err := CookieHandler.Encode("session", value); err == nil {
+	if encoded, err := PendingCookieHandler.Encode("pending", value); err == nil {
 		cookie := &http.Cookie{
-			Name:     "session",
+			Name:     "pending",
 			Value:    encoded,
 			Path:     path,
-			MaxAge:   30 * 24 * 60 * 60,
+			MaxAge:   PendingLoginSeconds,
 			HttpOnly: true,
 			SameSite: http.SameSiteLaxMode,
 		}
@@ -42,10 +99,10 @@ func SetSession(path, userName string, response http.ResponseWriter) {
 	}
 }
 
-// ClearSession removes session cookie.
-func ClearSession(path string, response http.ResponseWriter) {
+// ClearPendingLogin removes the pending login cookie.
+func ClearPendingLogin(path string, response http.ResponseWriter) {
 	cookie := &http.Cookie{
-		Name:     "session",
+		Name:     "pending",
 		Value:    "",
 		Path:     path,
 		MaxAge:   -1,
@@ -55,17 +112,29 @@ func ClearSession(path string, response http.ResponseWriter) {
 	http.SetCookie(response, cookie)
 }
 
-// GetUserName takes out userName from session cookie.
-func GetUserName(request *http.Request) (userName string) {
-	if cookie, err := request.Cookie("session"); err == nil {
+// GetPendingLogin takes out the userName from the pending login cookie.
+func GetPendingLogin(request *http.Request) (userName string) {
+	if cookie, err := request.Cookie("pending"); err == nil {
 		cookieValue := make(map[string]string)
-		if err = CookieHandler.Decode("session", cookie.Value, &cookieValue); err == nil {
+		if err = PendingCookieHandler.Decode("pending", cookie.Value, &cookieValue); err == nil {
 			userName = cookieValue["name"]
 		}
 	}
 	return userName
 }
 
+// GetUserName returns the user of the session, if the session is valid,
+// or the user of the API token in the Authorization header.
+func GetUserName(request *http.Request) (userName string) {
+	if token := sessionToken(request); token != "" {
+		return lookupSession(token)
+	}
+	if token := BearerToken(request); token != "" {
+		userName, _ = lookupAPIToken(token)
+	}
+	return userName
+}
+
 // SaveCredentials writes user credentials into the AuthFile (SQLite database).
 func SaveCredentials(authFile string, saveCredentials bool) error {
 	if saveCredentials == true || !fileExists(authFile) {
@@ -90,7 +159,10 @@ func SaveCredentials(authFile string, saveCredentials bool) error {
 			return fmt.Errorf("bcrypt.GenerateFromPassword: %w", err)
 		}
 
-		_, err = db.Exec("INSERT OR REPLACE INTO users (username, password_hash) VALUES (?, ?)", user, string(hash))
+		_, err = db.Exec(`
+			INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)
+			ON CONFLICT (username) DO UPDATE SET password_hash = excluded.password_hash
+		`, user, string(hash), RoleAdmin)
 		if err != nil {
 			return fmt.Errorf("INSERT: %w", err)
 		}
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"bou.ke/monkey"
 	"golang.org/x/crypto/bcrypt"
@@ -23,8 +24,12 @@ type (
 )
 
 func (s WebSessionSuite) TestSetSessionGetSession() {
+	defer useTestSessions(s.T(), "test_sessions.db")()
+
 	recorder := httptest.NewRecorder()
-	SetSession("/monitor/", "username", recorder)
+	login := httptest.NewRequest("POST", "/monitor", nil)
+	login.Header.Set("User-Agent", "test-agent")
+	s.Equal(nil, SetSession("/monitor/", "username", recorder, login))
 
 	cookies := recorder.Result().Cookies()
 	s.Require().Len(cookies, 1)
@@ -32,16 +37,134 @@ func (s WebSessionSuite) TestSetSessionGetSession() {
 
 	request := &http.Request{Header: http.Header{"Cookie": recorder.HeaderMap["Set-Cookie"]}}
 	s.Equal("username", GetUserName(request))
+
+	sessions, err := ListSessions(Sessions.AuthFile, "username", request)
+	s.Equal(nil, err)
+	s.Require().Len(sessions, 1)
+	s.Equal("192.0.2.1:1234", sessions[0].IP)
+	s.Equal("test-agent", sessions[0].UserAgent)
+	s.True(sessions[0].Current)
+	s.Equal(SessionID(request), sessions[0].ID)
 }
 
 func (s WebSessionSuite) TestClearSession() {
-	recorder := httptest.NewRecorder()
-	ClearSession("/monitor/", recorder)
+	defer useTestSessions(s.T(), "test_sessions.db")()
 
+	recorder := httptest.NewRecorder()
+	s.Equal(nil, SetSession("/monitor/", "username", recorder, httptest.NewRequest("POST", "/monitor", nil)))
 	request := &http.Request{Header: http.Header{"Cookie": recorder.HeaderMap["Set-Cookie"]}}
+
+	recorder = httptest.NewRecorder()
+	ClearSession("/monitor/", recorder, request)
+	s.Equal(-1, recorder.Result().Cookies()[0].MaxAge)
+
+	// The old cookie is not accepted anymore.
 	s.Equal("", GetUserName(request))
 }
 
+func (s WebSessionSuite) TestSessionTimeouts() {
+	defer useTestSessions(s.T(), "test_sessions.db")()
+
+	now := time.Unix(1700000000, 0)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	newSession := func() *http.Request {
+		recorder := httptest.NewRecorder()
+		s.Require().NoError(SetSession("/monitor/", "username", recorder, httptest.NewRequest("POST", "/monitor", nil)))
+		return &http.Request{Header: http.Header{"Cookie": recorder.HeaderMap["Set-Cookie"]}}
+	}
+
+	// A session, which is used, lives until the absolute timeout.
+	request := newSession()
+	for i := 0; i < 10; i++ {
+		now = now.Add(Sessions.IdleTimeout - time.Minute)
+		s.Equal("username", GetUserName(request))
+	}
+	now = now.Add(Sessions.AbsoluteTimeout)
+	s.Equal("", GetUserName(request))
+
+	// An idle session expires.
+	request = newSession()
+	now = now.Add(Sessions.IdleTimeout + time.Second)
+	s.Equal("", GetUserName(request))
+}
+
+func (s WebSessionSuite) TestRevokeSessions() {
+	defer useTestSessions(s.T(), "test_sessions.db")()
+
+	requests := []*http.Request{}
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		s.Require().NoError(SetSession("/monitor/", "username", recorder, httptest.NewRequest("POST", "/monitor", nil)))
+		requests = append(requests, &http.Request{Header: http.Header{"Cookie": recorder.HeaderMap["Set-Cookie"]}})
+	}
+
+	s.Equal(nil, RevokeSession(Sessions.AuthFile, "username", SessionID(requests[0])))
+	s.Equal("", GetUserName(requests[0]))
+	s.Contains(RevokeSession(Sessions.AuthFile, "other", SessionID(requests[1])).Error(), "session does not exist")
+
+	n, err := RevokeSessions(Sessions.AuthFile, "username", SessionID(requests[2]))
+	s.Equal(nil, err)
+	s.Equal(int64(1), n)
+	s.Equal("", GetUserName(requests[1]))
+	s.Equal("username", GetUserName(requests[2]))
+
+	// Changing the password logs out the user everywhere.
+	s.Equal(nil, SetPassword(Sessions.AuthFile, "username", "new_password"))
+	s.Equal("", GetUserName(requests[2]))
+}
+
+func (s WebSessionSuite) TestCSRFToken() {
+	defer useTestSessions(s.T(), "test_sessions.db")()
+
+	s.Equal("", CSRFToken(&http.Request{}))
+
+	newSession := func() *http.Request {
+		recorder := httptest.NewRecorder()
+		s.Require().NoError(SetSession("/monitor/", "username", recorder, httptest.NewRequest("POST", "/monitor", nil)))
+		return &http.Request{Header: http.Header{"Cookie": recorder.HeaderMap["Set-Cookie"]}}
+	}
+
+	first, second := newSession(), newSession()
+	token := CSRFToken(first)
+	s.NotEqual("", token)
+	s.Equal(token, CSRFToken(first))
+	s.NotEqual(token, CSRFToken(second))
+
+	s.True(ValidCSRFToken(first, token))
+	s.False(ValidCSRFToken(first, ""))
+	s.False(ValidCSRFToken(second, token))
+	s.False(ValidCSRFToken(&http.Request{}, ""))
+}
+
+func (s WebSessionSuite) TestConfigureSessionsPersistentKeys() {
+	defer useTestSessions(s.T(), "test_sessions.db")()
+
+	recorder := httptest.NewRecorder()
+	s.Require().NoError(SetSession("/monitor/", "username", recorder, httptest.NewRequest("POST", "/monitor", nil)))
+	request := &http.Request{Header: http.Header{"Cookie": recorder.HeaderMap["Set-Cookie"]}}
+
+	// The keys are loaded again, like after a restart.
+	s.Equal(nil, ConfigureSessions(Sessions.AuthFile, time.Hour, 24*time.Hour))
+	s.Equal("username", GetUserName(request))
+}
+
+func (s WebSessionSuite) TestConfigureSessionsMissingTimeouts() {
+	defer useTestSessions(s.T(), "test_sessions.db")()
+
+	recorder := httptest.NewRecorder()
+	s.Require().NoError(SetSession("/monitor/", "username", recorder, httptest.NewRequest("POST", "/monitor", nil)))
+	request := &http.Request{Header: http.Header{"Cookie": recorder.HeaderMap["Set-Cookie"]}}
+
+	// The timeouts are missing from the config, so they are 0.
+	s.Equal(nil, ConfigureSessions(Sessions.AuthFile, 0, -time.Second))
+	s.Equal(DefaultIdleTimeout, Sessions.IdleTimeout)
+	s.Equal(DefaultAbsoluteTimeout, Sessions.AbsoluteTimeout)
+	s.Equal("username", GetUserName(request))
+}
+
 func (s WebSessionSuite) TestSaveCredentials() {
 	authdb := "testauth.db"
 	defer func() { _ = os.Remove(authdb) }()
@@ -315,6 +438,24 @@ func (s WebSessionSuite) TestSaveCredentialsLegacyInsertError() {
 	_ = os.Remove(triggerdb)
 }
 
+// useTestSessions stores the sessions in a new database with a user, and returns a function to restore the defaults.
+func useTestSessions(t *testing.T, authFile string) func() {
+	_ = os.Remove(authFile)
+	if err := CreateUser(authFile, "username", "password", RoleAdmin); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	oldSessions, oldCookieHandler, oldPendingCookieHandler := Sessions, CookieHandler, PendingCookieHandler
+	if err := ConfigureSessions(authFile, time.Hour, 24*time.Hour); err != nil {
+		t.Fatalf("ConfigureSessions: %v", err)
+	}
+
+	return func() {
+		Sessions, CookieHandler, PendingCookieHandler = oldSessions, oldCookieHandler, oldPendingCookieHandler
+		_ = os.Remove(authFile)
+	}
+}
+
 func TestWebSessionSuite(t *testing.T) {
 	suite.Run(t, new(WebSessionSuite))
 }
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// lastSeenResolution limits the writes of the database: the last seen time of a session
+// is updated only, when it is older than this.
+const lastSeenResolution = time.Minute
+
+// The default timeouts of the sessions, they are used, when the timeouts are not configured.
+const (
+	DefaultIdleTimeout     = 2 * time.Hour
+	DefaultAbsoluteTimeout = 30 * 24 * time.Hour
+)
+
+// ErrSessionNotFound is returned, when the session does not exist, or it belongs to another user.
+var ErrSessionNotFound = errors.New("session does not exist")
+
+// SessionConfig describes where the sessions are stored, and how long they are valid.
+type SessionConfig struct {
+	AuthFile        string
+	IdleTimeout     time.Duration // The session expires, if it is not used for this long.
+	AbsoluteTimeout time.Duration // The session expires after this, even if it is used.
+}
+
+// Sessions is the configuration of the server-side sessions, it is set by ConfigureSessions.
+var Sessions = SessionConfig{
+	IdleTimeout:     DefaultIdleTimeout,
+	AbsoluteTimeout: DefaultAbsoluteTimeout,
+}
+
+// Session is a login of a user.
+type Session struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"`
+}
+
+// ConfigureSessions sets the storage and the timeouts of the sessions,
+// and loads the cookie keys from the auth database, so the sessions survive the restarts.
+// The keys are generated at the first start. The timeouts, which are not set (e.g. missing from
+// the config of an older version), get the default values, so the sessions do not expire at once.
+func ConfigureSessions(authFile string, idle, absolute time.Duration) error {
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	if absolute <= 0 {
+		absolute = DefaultAbsoluteTimeout
+	}
+
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys := map[string][]byte{}
+	for name, size := range map[string]int{
+		"session_hash_key":  64,
+		"session_block_key": 32,
+		"pending_hash_key":  64,
+		"pending_block_key": 32,
+	} {
+		if keys[name], err = loadSecret(db, name, size); err != nil {
+			return err
+		}
+	}
+
+	CookieHandler = securecookie.New(keys["session_hash_key"], keys["session_block_key"]).
+		MaxAge(int(absolute.Seconds()))
+	PendingCookieHandler = securecookie.New(keys["pending_hash_key"], keys["pending_block_key"]).
+		MaxAge(PendingLoginSeconds)
+
+	Sessions = SessionConfig{
+		AuthFile:        authFile,
+		IdleTimeout:     idle,
+		AbsoluteTimeout: absolute,
+	}
+	return nil
+}
+
+// loadSecret returns a random secret from the secrets table, and creates it, if it does not exist.
+func loadSecret(db *sql.DB, name string, size int) ([]byte, error) {
+	secret := securecookie.GenerateRandomKey(size)
+	if secret == nil {
+		return nil, fmt.Errorf("generate secret: %s", name)
+	}
+
+	_, err := db.Exec("INSERT OR IGNORE INTO secrets (name, value) VALUES (?, ?)", name, secret)
+	if err != nil {
+		return nil, fmt.Errorf("INSERT secrets: %w", err)
+	}
+
+	err = db.QueryRow("SELECT value FROM secrets WHERE name = ?", name).Scan(&secret)
+	if err != nil {
+		return nil, fmt.Errorf("SELECT secrets: %w", err)
+	}
+	return secret, nil
+}
+
+// createSession stores a new session, and returns its token, which is sent to the browser.
+// Only the hash of the token is stored, and it is used as the ID of the session.
+func createSession(userName, ip, userAgent string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	db, err := initDB(Sessions.AuthFile)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	now := timeNow()
+	if err := deleteExpiredSessions(db, now); err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO sessions (id, username, ip, user_agent, created_at, last_seen, csrf_token) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		sessionID(token), userName, ip, userAgent, now.Unix(), now.Unix(), csrfToken,
+	)
+	if err != nil {
+		return "", fmt.Errorf("INSERT sessions: %w", err)
+	}
+	return token, nil
+}
+
+// lookupSession returns the user of a valid session, and refreshes its last seen time.
+func lookupSession(token string) string {
+	db, err := initDB(Sessions.AuthFile)
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+
+	var userName string
+	var createdAt, lastSeen int64
+	id := sessionID(token)
+	err = db.QueryRow(
+		"SELECT username, created_at, last_seen FROM sessions WHERE id = ?", id,
+	).Scan(&userName, &createdAt, &lastSeen)
+	if err != nil {
+		return ""
+	}
+
+	now := timeNow()
+	if now.Sub(time.Unix(lastSeen, 0)) > Sessions.IdleTimeout || now.Sub(time.Unix(createdAt, 0)) > Sessions.AbsoluteTimeout {
+		_, _ = db.Exec("DELETE FROM sessions WHERE id = ?", id)
+		return ""
+	}
+
+	if now.Sub(time.Unix(lastSeen, 0)) >= lastSeenResolution {
+		_, _ = db.Exec("UPDATE sessions SET last_seen = ? WHERE id = ?", now.Unix(), id)
+	}
+	return userName
+}
+
+// ListSessions returns the valid sessions of a user, the most recently used first.
+// The session of the request is marked as current.
+func ListSessions(authFile, userName string, request *http.Request) ([]Session, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if err := deleteExpiredSessions(db, timeNow()); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(
+		"SELECT id, ip, user_agent, created_at, last_seen FROM sessions WHERE username = ? ORDER BY last_seen DESC",
+		userName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("SELECT sessions: %w", err)
+	}
+	defer rows.Close()
+
+	current := SessionID(request)
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var createdAt, lastSeen int64
+		if err := rows.Scan(&s.ID, &s.IP, &s.UserAgent, &createdAt, &lastSeen); err != nil {
+			return nil, fmt.Errorf("scan sessions row: %w", err)
+		}
+		s.CreatedAt, s.LastSeen = time.Unix(createdAt, 0), time.Unix(lastSeen, 0)
+		s.Current = s.ID == current
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession removes a session of a user.
+func RevokeSession(authFile, userName, id string) error {
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM sessions WHERE id = ? AND username = ?", id, userName)
+	if err != nil {
+		return fmt.Errorf("DELETE sessions: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return nil
+}
+
+// RevokeSessions removes the sessions of a user, except the one with the given ID,
+// and returns the number of the removed sessions.
+func RevokeSessions(authFile, userName, exceptID string) (int64, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM sessions WHERE username = ? AND id <> ?", userName, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("DELETE sessions: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// SessionID returns the ID of the session of the request.
+func SessionID(request *http.Request) string {
+	if token := sessionToken(request); token != "" {
+		return sessionID(token)
+	}
+	return ""
+}
+
+func sessionToken(request *http.Request) string {
+	if cookie, err := request.Cookie("session"); err == nil {
+		cookieValue := make(map[string]string)
+		if err = CookieHandler.Decode("session", cookie.Value, &cookieValue); err == nil {
+			return cookieValue["token"]
+		}
+	}
+	return ""
+}
+
+// randomToken returns 32 random bytes, encoded for cookies and headers.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func deleteExpiredSessions(db *sql.DB, now time.Time) error {
+	_, err := db.Exec(
+		"DELETE FROM sessions WHERE last_seen < ? OR created_at < ?",
+		now.Add(-Sessions.IdleTimeout).Unix(), now.Add(-Sessions.AbsoluteTimeout).Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("DELETE sessions: %w", err)
+	}
+	return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// deleteUserSessions logs out a user everywhere, e.g. after its password was changed.
+func deleteUserSessions(db execer, userName string) error {
+	if _, err := db.Exec("DELETE FROM sessions WHERE username = ?", userName); err != nil {
+		return fmt.Errorf("DELETE sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LoginPolicy describes how the failed logins are throttled.
+type LoginPolicy struct {
+	BackoffAfter    int           // The number of failures, which are not delayed.
+	BackoffBase     time.Duration // The delay after the first delayed failure, it is doubled by each further failure.
+	BackoffMax      time.Duration // The maximum of the delay.
+	LockoutAfter    int           // The number of failures, which lock the account of a user. 0 disables the lockout.
+	LockoutDuration time.Duration // How long a locked account can not log in.
+	ResetAfter      time.Duration // The failures older than this are forgotten.
+}
+
+// LoginBlock tells why a login attempt is rejected without checking the password.
+type LoginBlock struct {
+	Wait   time.Duration // The remaining time of the block.
+	Locked bool          // The account of the user is locked, not only delayed.
+}
+
+func ipKey(ip string) string         { return "ip:" + ip }
+func userKey(userName string) string { return "user:" + userName }
+
+// LoginBlocked checks whether the login attempts from the IP, or to the user, must wait.
+func LoginBlocked(authFile, ip, userName string) (LoginBlock, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return LoginBlock{}, err
+	}
+	defer db.Close()
+
+	now := timeNow()
+	rows, err := db.Query(
+		"SELECT blocked_until, locked FROM login_failures WHERE key IN (?, ?) AND blocked_until > ?",
+		ipKey(ip), userKey(userName), now.Unix(),
+	)
+	if err != nil {
+		return LoginBlock{}, fmt.Errorf("SELECT login_failures: %w", err)
+	}
+	defer rows.Close()
+
+	var block LoginBlock
+	for rows.Next() {
+		var blockedUntil int64
+		var locked bool
+		if err := rows.Scan(&blockedUntil, &locked); err != nil {
+			return LoginBlock{}, fmt.Errorf("scan login_failures row: %w", err)
+		}
+		if wait := time.Unix(blockedUntil, 0).Sub(now); wait > block.Wait {
+			block.Wait = wait
+		}
+		block.Locked = block.Locked || locked
+	}
+
+	if err := rows.Err(); err != nil {
+		return LoginBlock{}, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return block, nil
+}
+
+// RecordLoginFailure counts a failed login attempt of the IP and of the user,
+// and returns the resulting block. The user does not need to exist, an empty user name is not counted.
+func RecordLoginFailure(authFile string, policy LoginPolicy, ip, userName string) (LoginBlock, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return LoginBlock{}, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return LoginBlock{}, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := timeNow()
+	_, err = tx.Exec(
+		"DELETE FROM login_failures WHERE last_failure < ? AND blocked_until < ?",
+		now.Add(-policy.ResetAfter).Unix(), now.Unix(),
+	)
+	if err != nil {
+		return LoginBlock{}, fmt.Errorf("DELETE login_failures: %w", err)
+	}
+
+	keys := []string{ipKey(ip)}
+	if userName != "" {
+		keys = append(keys, userKey(userName))
+	}
+
+	var block LoginBlock
+	for _, key := range keys {
+		b, err := recordFailure(tx, policy, key, key == userKey(userName), now)
+		if err != nil {
+			return LoginBlock{}, err
+		}
+		if b.Wait > block.Wait {
+			block.Wait = b.Wait
+		}
+		block.Locked = block.Locked || b.Locked
+	}
+
+	if err := tx.Commit(); err != nil {
+		return LoginBlock{}, fmt.Errorf("commit transaction: %w", err)
+	}
+	return block, nil
+}
+
+// recordFailure increments the failures of a key, and calculates its block:
+// exponential backoff for every key, and lockout for the users.
+func recordFailure(tx *sql.Tx, policy LoginPolicy, key string, lockable bool, now time.Time) (LoginBlock, error) {
+	var failures int
+	var lastFailure, blockedUntil int64
+	var locked bool
+	err := tx.QueryRow(
+		"SELECT failures, last_failure, blocked_until, locked FROM login_failures WHERE key = ?", key,
+	).Scan(&failures, &lastFailure, &blockedUntil, &locked)
+	if err != nil && err != sql.ErrNoRows {
+		return LoginBlock{}, fmt.Errorf("SELECT login_failures: %w", err)
+	}
+
+	// An expired lockout, or old failures give a fresh start.
+	if (locked && blockedUntil <= now.Unix()) || now.Sub(time.Unix(lastFailure, 0)) > policy.ResetAfter {
+		failures, locked = 0, false
+	}
+	failures++
+
+	var block LoginBlock
+	if failures > policy.BackoffAfter {
+		block.Wait = policy.BackoffMax
+		if shift := failures - policy.BackoffAfter - 1; shift < 32 && policy.BackoffBase<<shift < policy.BackoffMax {
+			block.Wait = policy.BackoffBase << shift
+		}
+	}
+	if lockable && policy.LockoutAfter > 0 && failures >= policy.LockoutAfter {
+		block = LoginBlock{Wait: policy.LockoutDuration, Locked: true}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO login_failures (key, failures, last_failure, blocked_until, locked) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = excluded.failures,
+			last_failure = excluded.last_failure,
+			blocked_until = excluded.blocked_until,
+			locked = excluded.locked`,
+		key, failures, now.Unix(), now.Add(block.Wait).Unix(), block.Locked,
+	)
+	if err != nil {
+		return LoginBlock{}, fmt.Errorf("INSERT login_failures: %w", err)
+	}
+	return block, nil
+}
+
+// ResetLoginFailures forgets the failures of the IP and of the user after a successful login.
+func ResetLoginFailures(authFile, ip, userName string) error {
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM login_failures WHERE key IN (?, ?)", ipKey(ip), userKey(userName))
+	if err != nil {
+		return fmt.Errorf("DELETE login_failures: %w", err)
+	}
+	return nil
+}
+
+// UnlockUser removes the lockout and the failures of a user.
+func UnlockUser(authFile, userName string) error {
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var exists int
+	err = db.QueryRow("SELECT 1 FROM users WHERE username = ?", userName).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, userName)
+	}
+	if err != nil {
+		return fmt.Errorf("SELECT users: %w", err)
+	}
+
+	_, err = db.Exec("DELETE FROM login_failures WHERE key = ?", userKey(userName))
+	if err != nil {
+		return fmt.Errorf("DELETE login_failures: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	WebThrottleSuite struct {
+		suite.Suite
+	}
+)
+
+var testLoginPolicy = LoginPolicy{
+	BackoffAfter:    2,
+	BackoffBase:     time.Second,
+	BackoffMax:      10 * time.Second,
+	LockoutAfter:    6,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+func (s WebThrottleSuite) TestBackoff() {
+	authdb := "test_throttle.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	now := time.Unix(1700000000, 0)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	for i, expected := range []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second} {
+		block, err := RecordLoginFailure(authdb, testLoginPolicy, "10.0.0.1", "user"+string(rune('a'+i)))
+		s.Equal(nil, err)
+		s.Equal(expected, block.Wait, "failure: %d", i+1)
+		s.False(block.Locked)
+	}
+
+	// The IP is delayed, the other users are not.
+	block, err := LoginBlocked(authdb, "10.0.0.1", "other")
+	s.Equal(nil, err)
+	s.Equal(4*time.Second, block.Wait)
+
+	block, err = LoginBlocked(authdb, "10.0.0.2", "usera")
+	s.Equal(nil, err)
+	s.Equal(time.Duration(0), block.Wait)
+
+	// The delay is limited.
+	for i := 0; i < 40; i++ {
+		block, err = RecordLoginFailure(authdb, testLoginPolicy, "10.0.0.1", "")
+		s.Equal(nil, err)
+	}
+	s.Equal(testLoginPolicy.BackoffMax, block.Wait)
+
+	// The old failures are forgotten.
+	now = now.Add(testLoginPolicy.ResetAfter + time.Second)
+	block, err = RecordLoginFailure(authdb, testLoginPolicy, "10.0.0.1", "")
+	s.Equal(nil, err)
+	s.Equal(time.Duration(0), block.Wait)
+
+	s.Equal(nil, ResetLoginFailures(authdb, "10.0.0.1", ""))
+}
+
+func (s WebThrottleSuite) TestLockout() {
+	authdb := "test_throttle.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	now := time.Unix(1700000000, 0)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	s.Equal(nil, CreateUser(authdb, "bob", "password", RoleViewer))
+
+	var block LoginBlock
+	var err error
+	for i := 0; i < testLoginPolicy.LockoutAfter; i++ {
+		// Different IPs, like in a distributed attack.
+		block, err = RecordLoginFailure(authdb, testLoginPolicy, "10.0.0."+string(rune('1'+i)), "bob")
+		s.Equal(nil, err)
+	}
+	s.True(block.Locked)
+	s.Equal(testLoginPolicy.LockoutDuration, block.Wait)
+
+	block, err = LoginBlocked(authdb, "10.0.1.1", "bob")
+	s.Equal(nil, err)
+	s.True(block.Locked)
+
+	users, err := ListUsers(authdb)
+	s.Equal(nil, err)
+	s.True(users[0].Locked)
+
+	s.Equal(nil, UnlockUser(authdb, "bob"))
+	block, err = LoginBlocked(authdb, "10.0.1.1", "bob")
+	s.Equal(nil, err)
+	s.Equal(LoginBlock{}, block)
+	s.Contains(UnlockUser(authdb, "not_exists").Error(), "user does not exist")
+
+	// The lockout expires by itself, and the counting starts again.
+	for i := 0; i < testLoginPolicy.LockoutAfter; i++ {
+		block, err = RecordLoginFailure(authdb, testLoginPolicy, "10.0.2.1", "bob")
+		s.Equal(nil, err)
+	}
+	s.True(block.Locked)
+
+	now = now.Add(testLoginPolicy.LockoutDuration + time.Second)
+	block, err = LoginBlocked(authdb, "10.0.1.1", "bob")
+	s.Equal(nil, err)
+	s.False(block.Locked)
+
+	block, err = RecordLoginFailure(authdb, testLoginPolicy, "10.0.1.1", "bob")
+	s.Equal(nil, err)
+	s.Equal(LoginBlock{}, block)
+
+	users, err = ListUsers(authdb)
+	s.Equal(nil, err)
+	s.False(users[0].Locked)
+}
+
+func TestWebThrottleSuite(t *testing.T) {
+	suite.Run(t, new(WebThrottleSuite))
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scopes of the API tokens.
+const (
+	TokenScopeRead    = "read"    // Only the view permission, and only safe (GET, HEAD) requests.
+	TokenScopeActions = "actions" // Every permission of the role of the user.
+)
+
+// tokenPrefix makes the API tokens recognizable, e.g. by secret scanners.
+const tokenPrefix = "mon_"
+
+// ErrTokenNotFound is returned, when the token does not exist, or it belongs to another user.
+var ErrTokenNotFound = errors.New("token does not exist")
+
+// APIToken is a personal API token of a user, without its secret.
+type APIToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"` // Zero, if the token does not expire.
+	LastUsed  time.Time `json:"last_used"`  // Zero, if the token was not used yet.
+}
+
+// ValidTokenScope checks whether the scope exists.
+func ValidTokenScope(scope string) bool {
+	return scope == TokenScopeRead || scope == TokenScopeActions
+}
+
+// CreateAPIToken creates an API token for a user, and returns its secret, which is not stored.
+// A zero expiresAt creates a token, which does not expire.
+func CreateAPIToken(authFile, userName, name, scope string, expiresAt time.Time) (string, APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return "", APIToken{}, fmt.Errorf("%w token name: %q", ErrInvalid, name)
+	}
+	if !ValidTokenScope(scope) {
+		return "", APIToken{}, fmt.Errorf("%w token scope: %s", ErrInvalid, scope)
+	}
+
+	now := timeNow()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return "", APIToken{}, fmt.Errorf("%w token expiry: %s", ErrInvalid, expiresAt.Format(time.RFC3339))
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	token := tokenPrefix + secret
+	id := sessionID(token)[:16]
+
+	if _, err := GetUserRole(authFile, userName); err != nil {
+		return "", APIToken{}, err
+	}
+
+	db, err := initDB(authFile)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"INSERT INTO api_tokens (id, token_hash, username, name, scope, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		id, sessionID(token), userName, name, scope, now.Unix(), unixOrZero(expiresAt),
+	)
+	if err != nil {
+		return "", APIToken{}, fmt.Errorf("INSERT api_tokens: %w", err)
+	}
+
+	return token, APIToken{
+		ID:        id,
+		Name:      name,
+		Scope:     scope,
+		CreatedAt: time.Unix(now.Unix(), 0),
+		ExpiresAt: timeOrZero(unixOrZero(expiresAt)),
+	}, nil
+}
+
+// ListAPITokens returns the API tokens of a user, the newest first.
+func ListAPITokens(authFile, userName string) ([]APIToken, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(
+		"SELECT id, name, scope, created_at, expires_at, last_used FROM api_tokens WHERE username = ? ORDER BY created_at DESC, id",
+		userName,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("SELECT api_tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		var createdAt, expiresAt, lastUsed int64
+		if err := rows.Scan(&t.ID, &t.Name, &t.Scope, &createdAt, &expiresAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("scan api_tokens row: %w", err)
+		}
+		t.CreatedAt, t.ExpiresAt, t.LastUsed = time.Unix(createdAt, 0), timeOrZero(expiresAt), timeOrZero(lastUsed)
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return tokens, nil
+}
+
+// DeleteAPIToken revokes an API token of a user.
+func DeleteAPIToken(authFile, userName, id string) error {
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND username = ?", id, userName)
+	if err != nil {
+		return fmt.Errorf("DELETE api_tokens: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+	}
+	return nil
+}
+
+// BearerToken returns the API token from the 'Authorization: Bearer' header of the request.
+func BearerToken(request *http.Request) string {
+	scheme, token, ok := strings.Cut(request.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// TokenScope returns the scope of the API token of the request,
+// or an empty string, if the request is not authenticated by an API token.
+func TokenScope(request *http.Request) string {
+	if sessionToken(request) != "" {
+		return ""
+	}
+	if token := BearerToken(request); token != "" {
+		_, scope := lookupAPIToken(token)
+		return scope
+	}
+	return ""
+}
+
+// lookupAPIToken returns the user and the scope of a valid API token, and refreshes its last used time.
+// The tokens of the disabled users are not valid.
+func lookupAPIToken(token string) (string, string) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return "", ""
+	}
+
+	db, err := initDB(Sessions.AuthFile)
+	if err != nil {
+		return "", ""
+	}
+	defer db.Close()
+
+	var id, userName, scope string
+	var expiresAt, lastUsed int64
+	err = db.QueryRow(`
+		SELECT t.id, t.username, t.scope, t.expires_at, t.last_used
+		FROM api_tokens t JOIN users u ON u.username = t.username
+		WHERE t.token_hash = ? AND u.disabled = 0`, sessionID(token),
+	).Scan(&id, &userName, &scope, &expiresAt, &lastUsed)
+	if err != nil {
+		return "", ""
+	}
+
+	now := timeNow()
+	if expiresAt != 0 && now.Unix() >= expiresAt {
+		return "", ""
+	}
+
+	if now.Sub(time.Unix(lastUsed, 0)) >= lastSeenResolution {
+		_, _ = db.Exec("UPDATE api_tokens SET last_used = ? WHERE id = ?", now.Unix(), id)
+	}
+	return userName, scope
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	WebTokensSuite struct {
+		suite.Suite
+	}
+)
+
+func (s WebTokensSuite) TestAPITokens() {
+	authdb := "test_tokens.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	now := time.Unix(1700000000, 0)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	secret, token, err := CreateAPIToken(authdb, "username", "backup", TokenScopeRead, now.Add(time.Hour))
+	s.Equal(nil, err)
+	s.True(len(secret) > len(tokenPrefix))
+	s.Equal("backup", token.Name)
+
+	request := httptest.NewRequest("GET", "/monitor/api/cpu", nil)
+	request.Header.Set("Authorization", "Bearer "+secret)
+	s.Equal("username", GetUserName(request))
+	s.Equal(TokenScopeRead, TokenScope(request))
+
+	tokens, err := ListAPITokens(authdb, "username")
+	s.Equal(nil, err)
+	s.Equal(1, len(tokens))
+	s.Equal(token.ID, tokens[0].ID)
+	s.Equal(now.Unix(), tokens[0].LastUsed.Unix())
+
+	// Only the hash of the token is stored.
+	db, err := initDB(authdb)
+	s.Equal(nil, err)
+	var n int
+	s.Equal(nil, db.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE token_hash = ?", secret).Scan(&n))
+	s.Equal(0, n)
+	db.Close()
+
+	// Wrong and expired tokens.
+	request.Header.Set("Authorization", "Bearer "+secret+"x")
+	s.Equal("", GetUserName(request))
+
+	request.Header.Set("Authorization", "Bearer "+secret)
+	now = now.Add(time.Hour)
+	s.Equal("", GetUserName(request))
+	s.Equal("", TokenScope(request))
+
+	// The tokens of the disabled users are not valid.
+	s.Equal(nil, CreateUser(authdb, "bob", "password", RoleOperator))
+	secret, token, err = CreateAPIToken(authdb, "bob", "deploy", TokenScopeActions, time.Time{})
+	s.Equal(nil, err)
+	s.True(token.ExpiresAt.IsZero())
+
+	request.Header.Set("Authorization", "Bearer "+secret)
+	s.Equal("bob", GetUserName(request))
+	s.Equal(TokenScopeActions, TokenScope(request))
+
+	s.Equal(nil, SetUserDisabled(authdb, "bob", true))
+	s.Equal("", GetUserName(request))
+	s.Equal(nil, SetUserDisabled(authdb, "bob", false))
+
+	// Revoked tokens, and the tokens of the deleted users are removed.
+	s.True(errors.Is(DeleteAPIToken(authdb, "username", token.ID), ErrTokenNotFound))
+	s.Equal(nil, DeleteUser(authdb, "bob"))
+	s.Equal("", GetUserName(request))
+
+	tokens, err = ListAPITokens(authdb, "username")
+	s.Equal(nil, err)
+	s.Equal(nil, DeleteAPIToken(authdb, "username", tokens[0].ID))
+	tokens, err = ListAPITokens(authdb, "username")
+	s.Equal(nil, err)
+	s.Equal(0, len(tokens))
+}
+
+func (s WebTokensSuite) TestCreateAPITokenInvalid() {
+	authdb := "test_tokens.db"
+	defer useTestSessions(s.T(), authdb)()
+
+	_, _, err := CreateAPIToken(authdb, "username", " ", TokenScopeRead, time.Time{})
+	s.Contains(err.Error(), "invalid token name")
+
+	_, _, err = CreateAPIToken(authdb, "username", "name", "admin", time.Time{})
+	s.Contains(err.Error(), "invalid token scope")
+
+	_, _, err = CreateAPIToken(authdb, "username", "name", TokenScopeRead, time.Now().Add(-time.Hour))
+	s.Contains(err.Error(), "invalid token expiry")
+
+	_, _, err = CreateAPIToken(authdb, "not_exists", "name", TokenScopeRead, time.Time{})
+	s.Contains(err.Error(), "user does not exist")
+}
+
+func (s WebTokensSuite) TestBearerToken() {
+	request := httptest.NewRequest("GET", "/", nil)
+	s.Equal("", BearerToken(request))
+
+	request.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	s.Equal("", BearerToken(request))
+
+	request.Header.Set("Authorization", "bearer mon_secret")
+	s.Equal("mon_secret", BearerToken(request))
+}
+
+func TestWebTokensSuite(t *testing.T) {
+	suite.Run(t, new(WebTokensSuite))
+}
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// TOTPIssuer is shown by the authenticator apps next to the account name.
+	TOTPIssuer = "Monitor"
+
+	// totpPeriod is the lifetime of a code in seconds, as defined by RFC 6238.
+	totpPeriod = 30
+
+	// totpSkew is the number of periods accepted before and after the current one,
+	// so small clock differences between the server and the phone do not matter.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var timeNow = time.Now
+
+// The errors of the TOTP enrollment.
+var (
+	ErrTOTPEnabled    = errors.New("two-factor authentication is enabled already")
+	ErrTOTPNotStarted = errors.New("two-factor authentication setup is not started")
+)
+
+// TOTPSetup holds the data, which is needed to add the account to an authenticator app.
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QR     string `json:"qr"` // PNG image of the URI as a data URL.
+}
+
+// TOTPEnabled checks whether the user has confirmed a TOTP enrollment.
+func TOTPEnabled(authFile, username string) (bool, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	var enabled bool
+	err = db.QueryRow("SELECT totp_enabled FROM users WHERE username = ?", username).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return false, fmt.Errorf("SELECT users: %w", err)
+	}
+	return enabled, nil
+}
+
+// BeginTOTP generates a new secret for the user, which becomes active after ConfirmTOTP.
+// The enrollment can not be started again, while TOTP is enabled.
+func BeginTOTP(authFile, username string) (*TOTPSetup, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      TOTPIssuer,
+		AccountName: username,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("totp.Generate: %w", err)
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return nil, fmt.Errorf("QR code: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("png.Encode: %w", err)
+	}
+
+	db, err := initDB(authFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(
+		"UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE username = ? AND totp_enabled = 0",
+		key.Secret(), username,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("UPDATE users: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := TOTPEnabled(authFile, username); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrTOTPEnabled, username)
+	}
+
+	return &TOTPSetup{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QR:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP for the user, if the code matches the secret generated by BeginTOTP,
+// and returns new recovery codes. The recovery codes are stored hashed, so they can not be shown again.
+func ConfirmTOTP(authFile, username, code string) ([]string, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var secret string
+	var enabled bool
+	err = db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE username = ?", username).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("SELECT users: %w", err)
+	}
+	if enabled {
+		return nil, fmt.Errorf("%w: %s", ErrTOTPEnabled, username)
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("%w: %s", ErrTOTPNotStarted, username)
+	}
+
+	step, ok := matchTOTP(secret, code, 0)
+	if !ok {
+		return nil, fmt.Errorf("%w code", ErrInvalid)
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("BEGIN: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE username = ?", step, username); err != nil {
+		return nil, fmt.Errorf("UPDATE users: %w", err)
+	}
+	if err := storeRecoveryCodes(tx, username, codes); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("COMMIT: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes the TOTP secret and the recovery codes of the user.
+func DisableTOTP(authFile, username string) error {
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("BEGIN: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec("UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0 WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("UPDATE users: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE username = ?", username); err != nil {
+		return fmt.Errorf("DELETE recovery_codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("COMMIT: %w", err)
+	}
+	return nil
+}
+
+// VerifyTOTP checks the second factor of the user: a TOTP code or an unused recovery code.
+// Every TOTP code can be used only once, and the recovery codes are removed, when they are used.
+func VerifyTOTP(authFile, username, code string) bool {
+	db, err := initDB(authFile)
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+
+	var secret string
+	var enabled bool
+	var lastStep int64
+	err = db.QueryRow(
+		"SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE username = ?", username,
+	).Scan(&secret, &enabled, &lastStep)
+	if err != nil || !enabled {
+		return false
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := matchTOTP(secret, code, lastStep); ok {
+		// The condition protects against using the same code twice in parallel requests.
+		res, err := db.Exec(
+			"UPDATE users SET totp_last_step = ? WHERE username = ? AND totp_last_step < ?",
+			step, username, step,
+		)
+		if err != nil {
+			return false
+		}
+		n, _ := res.RowsAffected()
+		return n == 1
+	}
+
+	res, err := db.Exec(
+		"DELETE FROM recovery_codes WHERE username = ? AND code_hash = ?",
+		username, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// matchTOTP returns the time step of the code, if it is valid within the skew, and newer than the last used one.
+func matchTOTP(secret, code string, lastStep int64) (int64, bool) {
+	if len(code) != 6 {
+		return 0, false
+	}
+
+	current := timeNow().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := hotp.GenerateCode(secret, uint64(step))
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("rand.Read: %w", err)
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+func storeRecoveryCodes(tx *sql.Tx, username string, codes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE username = ?", username); err != nil {
+		return fmt.Errorf("DELETE recovery_codes: %w", err)
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(
+			"INSERT INTO recovery_codes (username, code_hash) VALUES (?, ?)",
+			username, hashRecoveryCode(code),
+		)
+		if err != nil {
+			return fmt.Errorf("INSERT recovery_codes: %w", err)
+		}
+	}
+	return nil
+}
+
+// hashRecoveryCode hashes the normalized form of a recovery code.
+// The codes are random, so a salt and a slow hash are not needed.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
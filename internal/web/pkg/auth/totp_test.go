@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	WebTOTPSuite struct {
+		suite.Suite
+	}
+)
+
+func (s WebTOTPSuite) TestTOTP() {
+	authdb := "test_totp.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	now := time.Unix(1700000000, 0)
+	oldTimeNow := timeNow
+	defer func() { timeNow = oldTimeNow }()
+	timeNow = func() time.Time { return now }
+
+	code := func(secret string, offset int64) string {
+		c, err := hotp.GenerateCode(secret, uint64(now.Unix()/totpPeriod+offset))
+		s.Require().NoError(err)
+		return c
+	}
+
+	s.Equal(nil, CreateUser(authdb, "bob", "password", RoleViewer))
+
+	enabled, err := TOTPEnabled(authdb, "bob")
+	s.Equal(nil, err)
+	s.False(enabled)
+
+	_, err = ConfirmTOTP(authdb, "bob", "123456")
+	s.Contains(fmt.Sprint(err), "setup is not started")
+
+	setup, err := BeginTOTP(authdb, "bob")
+	s.Equal(nil, err)
+	s.True(strings.HasPrefix(setup.URI, "otpauth://totp/Monitor:bob?"))
+	s.Contains(setup.URI, "secret="+setup.Secret)
+	s.True(strings.HasPrefix(setup.QR, "data:image/png;base64,"))
+
+	_, err = ConfirmTOTP(authdb, "bob", code(setup.Secret, 5))
+	s.Contains(fmt.Sprint(err), "invalid code")
+
+	codes, err := ConfirmTOTP(authdb, "bob", code(setup.Secret, -1))
+	s.Equal(nil, err)
+	s.Equal(recoveryCodeCount, len(codes))
+
+	enabled, err = TOTPEnabled(authdb, "bob")
+	s.Equal(nil, err)
+	s.True(enabled)
+
+	_, err = BeginTOTP(authdb, "bob")
+	s.Contains(fmt.Sprint(err), "enabled already")
+
+	// The code used for the confirmation and the older ones are rejected.
+	s.False(VerifyTOTP(authdb, "bob", code(setup.Secret, -1)))
+	s.True(VerifyTOTP(authdb, "bob", code(setup.Secret, 0)))
+	s.False(VerifyTOTP(authdb, "bob", code(setup.Secret, 0)))
+	s.True(VerifyTOTP(authdb, "bob", " "+code(setup.Secret, 1)+" "))
+	s.False(VerifyTOTP(authdb, "bob", code(setup.Secret, 2)))
+
+	// The recovery codes can be used once, with or without the dash.
+	s.True(VerifyTOTP(authdb, "bob", codes[0]))
+	s.False(VerifyTOTP(authdb, "bob", codes[0]))
+	s.True(VerifyTOTP(authdb, "bob", strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))))
+
+	users, err := ListUsers(authdb)
+	s.Equal(nil, err)
+	s.True(users[0].TOTP)
+
+	s.Equal(nil, DisableTOTP(authdb, "bob"))
+	s.False(VerifyTOTP(authdb, "bob", codes[2]))
+	enabled, err = TOTPEnabled(authdb, "bob")
+	s.Equal(nil, err)
+	s.False(enabled)
+
+	s.Contains(DisableTOTP(authdb, "not_exists").Error(), "user does not exist")
+	_, err = BeginTOTP(authdb, "not_exists")
+	s.Contains(fmt.Sprint(err), "user does not exist")
+}
+
+func TestWebTOTPSuite(t *testing.T) {
+	suite.Run(t, new(WebTOTPSuite))
+}
@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the minimum length of the passwords set through the user management.
+const MinPasswordLength = 8
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// The errors of the user management, they are wrapped with the details, e.g. the name of the user.
+var (
+	ErrInvalid          = errors.New("invalid") // E.g. "invalid role: guest".
+	ErrUserNotFound     = errors.New("user does not exist")
+	ErrUserExists       = errors.New("user exists already")
+	ErrUserDisabled     = errors.New("user is disabled")
+	ErrLastAdmin        = errors.New("the last admin can not be removed")
+	ErrOtherBackend     = errors.New("user belongs to another backend")
+	ErrExternalUser     = errors.New("the password of an external user can not be set")
+	ErrPasswordTooShort = fmt.Errorf("the password must be at least %d characters long", MinPasswordLength)
+)
+
+// UserUpdate describes the changes of a user, the nil fields are not changed.
+type UserUpdate struct {
+	Password  *string
+	Role      *string
+	Disabled  *bool
+	ResetTOTP bool
+}
+
+// User describes an account of the users table.
+type User struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	TOTP     bool   `json:"totp"`
+	Locked   bool   `json:"locked"`
+}
+
+// ListUsers returns the users ordered by their names.
+func ListUsers(authFile string) ([]User, error) {
+	db, err := initDB(authFile)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT u.username, u.role, u.disabled, u.totp_enabled,
+			EXISTS (SELECT 1 FROM login_failures f WHERE f.key = 'user:' || u.username AND f.locked AND f.blocked_until > ?)
+		FROM users u ORDER BY u.username`,
+		timeNow().Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("SELECT users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.Role, &u.Disabled, &u.TOTP, &u.Locked); err != nil {
+			return nil, fmt.Errorf("scan users row: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return users, nil
+}
+
+// CreateUser adds a new user with the given role.
+func CreateUser(authFile, username, password, role string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("%w username: '%s'", ErrInvalid, username)
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("%w role: %s", ErrInvalid, role)
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(
+		"INSERT OR IGNORE INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+		username, hash, role,
+	)
+	if err != nil {
+		return fmt.Errorf("INSERT users: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrUserExists, username)
+	}
+	return nil
+}
+
+// provisionUser adds a user of an external authenticator (PAM, OIDC, LDAP), if it does not exist yet.
+// Its password hash is "!" and the name of the backend, which owns the user. It is not valid bcrypt,
+// so the password of the users table never matches. An existing user of another backend,
+// or a local user with a password can not be taken over, the login is refused.
+func provisionUser(db *sql.DB, username, backend, role string) error {
+	if !validUsername.MatchString(username) {
+		return fmt.Errorf("%w username: '%s'", ErrInvalid, username)
+	}
+
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+		username, "!"+backend, role,
+	)
+	if err != nil {
+		return fmt.Errorf("INSERT users: %w", err)
+	}
+
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM users WHERE username = ?", username).Scan(&hash); err != nil {
+		return fmt.Errorf("SELECT users: %w", err)
+	}
+	if hash != "!"+backend {
+		return fmt.Errorf("%w: %s", ErrOtherBackend, username)
+	}
+	return nil
+}
+
+// syncExternalUser adds a user of an external identity provider (OIDC, LDAP) at its first login,
+// and updates its role, if it comes from a group mapping. Disabled users can not log in.
+func syncExternalUser(authFile, username, backend, role string, mapped bool) error {
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var disabled bool
+	err = db.QueryRow("SELECT disabled FROM users WHERE username = ?", username).Scan(&disabled)
+	if err == nil && disabled {
+		return fmt.Errorf("%w: %s", ErrUserDisabled, username)
+	}
+
+	if err := provisionUser(db, username, backend, role); err != nil {
+		return err
+	}
+	if mapped {
+		if _, err := db.Exec("UPDATE users SET role = ? WHERE username = ?", role, username); err != nil {
+			return fmt.Errorf("UPDATE users: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeleteUser removes a user, its settings, sessions and API tokens.
+func DeleteUser(authFile, username string) error {
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := keepAdmin(db, username); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("BEGIN: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM user_settings WHERE username = ?", username); err != nil {
+		return fmt.Errorf("DELETE user_settings: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE username = ?", username); err != nil {
+		return fmt.Errorf("DELETE recovery_codes: %w", err)
+	}
+
+	if err := deleteUserSessions(tx, username); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM api_tokens WHERE username = ?", username); err != nil {
+		return fmt.Errorf("DELETE api_tokens: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM login_failures WHERE key = ?", userKey(username)); err != nil {
+		return fmt.Errorf("DELETE login_failures: %w", err)
+	}
+
+	res, err := tx.Exec("DELETE FROM users WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("DELETE users: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("COMMIT: %w", err)
+	}
+	return nil
+}
+
+// UpdateUser applies the changes of a user in one transaction: all of them are validated
+// before the first write, and either all of them are saved, or none of them.
+// The user is logged out everywhere, when its password is changed, or it is disabled.
+func UpdateUser(authFile, username string, update UserUpdate) error {
+	var hash string
+	if update.Password != nil {
+		var err error
+		if hash, err = hashPassword(*update.Password); err != nil {
+			return err
+		}
+	}
+	if update.Role != nil && !ValidRole(*update.Role) {
+		return fmt.Errorf("%w role: %s", ErrInvalid, *update.Role)
+	}
+
+	db, err := initDB(authFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("BEGIN: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		wasAdmin    bool
+		currentHash string
+	)
+	err = tx.QueryRow("SELECT role = ? AND disabled = 0, password_hash FROM users WHERE username = ?", RoleAdmin, username).Scan(&wasAdmin, &currentHash)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return fmt.Errorf("SELECT users: %w", err)
+	}
+	// The users of PAM, LDAP and OIDC would become local users, and their backend would refuse them.
+	if update.Password != nil && strings.HasPrefix(currentHash, "!") {
+		return fmt.Errorf("%w: %s", ErrExternalUser, username)
+	}
+
+	logout := false
+	if update.Password != nil {
+		if _, err := tx.Exec("UPDATE users SET password_hash = ? WHERE username = ?", hash, username); err != nil {
+			return fmt.Errorf("UPDATE users: %w", err)
+		}
+		logout = true
+	}
+	if update.Role != nil {
+		if _, err := tx.Exec("UPDATE users SET role = ? WHERE username = ?", *update.Role, username); err != nil {
+			return fmt.Errorf("UPDATE users: %w", err)
+		}
+	}
+	if update.Disabled != nil {
+		if _, err := tx.Exec("UPDATE users SET disabled = ? WHERE username = ?", *update.Disabled, username); err != nil {
+			return fmt.Errorf("UPDATE users: %w", err)
+		}
+		logout = logout || *update.Disabled
+	}
+	if update.ResetTOTP {
+		if _, err := tx.Exec("UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0 WHERE username = ?", username); err != nil {
+			return fmt.Errorf("UPDATE users: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM recovery_codes WHERE username = ?", username); err != nil {
+			return fmt.Errorf("DELETE recovery_codes: %w", err)
+		}
+	}
+
+	if wasAdmin {
+		var admins int
+		err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND disabled = 0", RoleAdmin).Scan(&admins)
+		if err != nil {
+			return fmt.Errorf("SELECT users: %w", err)
+		}
+		if admins == 0 {
+			return fmt.Errorf("%w: %s", ErrLastAdmin, username)
+		}
+	}
+
+	if logout {
+		if err := deleteUserSessions(tx, username); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("COMMIT: %w", err)
+	}
+	return nil
+}
+
+// SetPassword replaces the password of a user, and logs it out everywhere.
+func SetPassword(authFile, username, password string) error {
+	return UpdateUser(authFile, username, UserUpdate{Password: &password})
+}
+
+// SetUserDisabled disables or enables a user. Disabled users can not log in, and their sessions are removed.
+func SetUserDisabled(authFile, username string, disabled bool) error {
+	return UpdateUser(authFile, username, UserUpdate{Disabled: &disabled})
+}
+
+// keepAdmin returns an error, if the user is the last enabled admin,
+// so nobody would be able to manage the users after removing it.
+func keepAdmin(db *sql.DB, username string) error {
+	var isAdmin bool
+	err := db.QueryRow("SELECT role = ? AND disabled = 0 FROM users WHERE username = ?", RoleAdmin, username).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("SELECT users: %w", err)
+	}
+
+	if !isAdmin {
+		return nil
+	}
+
+	var others int
+	err = db.QueryRow(
+		"SELECT COUNT(*) FROM users WHERE role = ? AND disabled = 0 AND username <> ?",
+		RoleAdmin, username,
+	).Scan(&others)
+	if err != nil {
+		return fmt.Errorf("SELECT users: %w", err)
+	}
+
+	if others == 0 {
+		return fmt.Errorf("%w: %s", ErrLastAdmin, username)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt.GenerateFromPassword: %w", err)
+	}
+	return string(hash), nil
+}
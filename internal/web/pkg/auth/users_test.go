@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	_ "modernc.org/sqlite"
+)
+
+type (
+	WebUsersSuite struct {
+		suite.Suite
+	}
+)
+
+func (s WebUsersSuite) TestMigrate() {
+	authdb := "test_migrate.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	// A database created before the migrations were introduced.
+	db, err := sql.Open("sqlite", authdb)
+	s.Require().NoError(err)
+	_, err = db.Exec("CREATE TABLE users (username TEXT PRIMARY KEY, password_hash TEXT NOT NULL, role TEXT NOT NULL DEFAULT 'admin')")
+	s.Require().NoError(err)
+	db.Close()
+
+	db, err = initDB(authdb)
+	s.Require().NoError(err)
+
+	var version int
+	s.Equal(nil, db.QueryRow("PRAGMA user_version").Scan(&version))
+	s.Equal(len(migrations), version)
+
+	var n int
+	s.Equal(nil, db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name IN ('role', 'disabled')").Scan(&n))
+	s.Equal(2, n)
+
+	// Running the migrations again changes nothing.
+	s.Equal(nil, migrate(db))
+
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations)+1))
+	s.Require().NoError(err)
+	db.Close()
+
+	_, err = initDB(authdb)
+	s.Contains(fmt.Sprint(err), "is newer than the supported")
+}
+
+func (s WebUsersSuite) TestUsers() {
+	authdb := "test_users.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	s.Equal(nil, CreateUser(authdb, "admin", "password", RoleAdmin))
+	s.Equal(nil, CreateUser(authdb, "bob", "password", RoleViewer))
+	s.Equal(nil, SaveUserSetting(authdb, "bob", "skin", "dark"))
+
+	s.Contains(CreateUser(authdb, "bob", "password", RoleViewer).Error(), "user exists already: bob")
+	s.Contains(CreateUser(authdb, "bad name", "password", RoleViewer).Error(), "invalid username")
+	s.Contains(CreateUser(authdb, "eve", "password", "root").Error(), "invalid role")
+	s.Contains(CreateUser(authdb, "eve", "short", RoleViewer).Error(), "at least 8 characters")
+
+	users, err := ListUsers(authdb)
+	s.Equal(nil, err)
+	s.Equal([]User{
+		{Username: "admin", Role: RoleAdmin},
+		{Username: "bob", Role: RoleViewer},
+	}, users)
+
+	s.True(Authenticate(authdb, "bob", "password"))
+	s.Equal(nil, SetPassword(authdb, "bob", "new_password"))
+	s.False(Authenticate(authdb, "bob", "password"))
+	s.True(Authenticate(authdb, "bob", "new_password"))
+	s.Contains(SetPassword(authdb, "not_exists", "password").Error(), "user does not exist")
+
+	s.Equal(nil, SetUserDisabled(authdb, "bob", true))
+	s.False(Authenticate(authdb, "bob", "new_password"))
+	_, err = GetUserRole(authdb, "bob")
+	s.Contains(fmt.Sprint(err), "user is disabled: bob")
+	s.Equal(nil, SetUserDisabled(authdb, "bob", false))
+	s.True(Authenticate(authdb, "bob", "new_password"))
+
+	// The last enabled admin must be kept.
+	s.Contains(SetUserDisabled(authdb, "admin", true).Error(), "the last admin can not be removed")
+	s.Contains(DeleteUser(authdb, "admin").Error(), "the last admin can not be removed")
+	s.Contains(SetUserRole(authdb, "admin", RoleOperator).Error(), "the last admin can not be removed")
+
+	s.Equal(nil, DeleteUser(authdb, "bob"))
+	s.Contains(DeleteUser(authdb, "bob").Error(), "user does not exist")
+	settings, err := GetUserSettings(authdb, "bob")
+	s.Equal(nil, err)
+	s.Equal(0, len(settings))
+}
+
+func (s WebUsersSuite) TestUpdateUser() {
+	authdb := "test_update_user.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	s.Require().NoError(CreateUser(authdb, "admin", "password", RoleAdmin))
+	s.Require().NoError(CreateUser(authdb, "bob", "password", RoleViewer))
+
+	// Nothing is saved, if any of the changes is invalid.
+	password, role, disabled := "new_password", "root", true
+	err := UpdateUser(authdb, "bob", UserUpdate{Password: &password, Role: &role, Disabled: &disabled})
+	s.True(errors.Is(err, ErrInvalid))
+	s.True(Authenticate(authdb, "bob", "password"))
+
+	role = RoleOperator
+	err = UpdateUser(authdb, "admin", UserUpdate{Password: &password, Role: &role})
+	s.True(errors.Is(err, ErrLastAdmin))
+	s.True(Authenticate(authdb, "admin", "password"))
+
+	short := "short"
+	s.True(errors.Is(UpdateUser(authdb, "bob", UserUpdate{Password: &short}), ErrPasswordTooShort))
+	s.True(errors.Is(UpdateUser(authdb, "not_exists", UserUpdate{Role: &role}), ErrUserNotFound))
+
+	s.Equal(nil, UpdateUser(authdb, "bob", UserUpdate{Password: &password, Role: &role, Disabled: &disabled}))
+	users, err := ListUsers(authdb)
+	s.Equal(nil, err)
+	s.Equal([]User{
+		{Username: "admin", Role: RoleAdmin},
+		{Username: "bob", Role: RoleOperator, Disabled: true},
+	}, users)
+	s.False(Authenticate(authdb, "bob", "new_password"))
+}
+
+func (s WebUsersSuite) TestSyncExternalUser() {
+	authdb := "test_external_user.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	s.Require().NoError(CreateUser(authdb, "alice", "password", RoleViewer))
+	s.Equal(nil, syncExternalUser(authdb, "bob", BackendLDAP, RoleViewer, false))
+	s.Equal(nil, syncExternalUser(authdb, "bob", BackendLDAP, RoleOperator, true))
+	role, err := GetUserRole(authdb, "bob")
+	s.Equal(nil, err)
+	s.Equal(RoleOperator, role)
+
+	// The local users, and the users of another backend can not be taken over.
+	err = syncExternalUser(authdb, "alice", BackendOIDC, RoleAdmin, true)
+	s.True(errors.Is(err, ErrOtherBackend))
+	err = syncExternalUser(authdb, "bob", BackendOIDC, RoleAdmin, true)
+	s.True(errors.Is(err, ErrOtherBackend))
+
+	role, _ = GetUserRole(authdb, "alice")
+	s.Equal(RoleViewer, role)
+	role, _ = GetUserRole(authdb, "bob")
+	s.Equal(RoleOperator, role)
+	s.True(Authenticate(authdb, "alice", "password"))
+}
+
+func (s WebUsersSuite) TestUpdateExternalUser() {
+	authdb := "test_external_user.db"
+	defer os.Remove(authdb)
+	_ = os.Remove(authdb)
+
+	s.Require().NoError(syncExternalUser(authdb, "bob", BackendLDAP, RoleViewer, false))
+
+	// The password of an external user can not be set, it would become a local user.
+	password, role := "new_password", RoleOperator
+	err := UpdateUser(authdb, "bob", UserUpdate{Password: &password, Role: &role})
+	s.True(errors.Is(err, ErrExternalUser))
+	s.False(Authenticate(authdb, "bob", "new_password"))
+
+	// The other changes are allowed, and the user can still log in with its backend.
+	s.Equal(nil, UpdateUser(authdb, "bob", UserUpdate{Role: &role}))
+	s.Equal(nil, syncExternalUser(authdb, "bob", BackendLDAP, RoleViewer, false))
+	role, _ = GetUserRole(authdb, "bob")
+	s.Equal(RoleOperator, role)
+}
+
+func TestWebUsersSuite(t *testing.T) {
+	suite.Run(t, new(WebUsersSuite))
+}
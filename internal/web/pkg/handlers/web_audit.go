@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+const (
+	// auditResultLimit limits the stored output of the commands.
+	auditResultLimit = 1000
+	// auditMaxLimit limits the number of the returned records.
+	auditMaxLimit = 100000
+)
+
+// auditDefaultLimit limits the number of the listed records, when the limit is not given.
+var auditDefaultLimit = 500
+
+// audit records an action of a user with the IP of the request into the audit log.
+func (h *Handler) audit(r *http.Request, userName, action, params, result string) {
+	if len(result) > auditResultLimit {
+		result = result[:auditResultLimit] + "..."
+	}
+
+	h.L.Info("audit:", action, "user:", userName, "ip:", remoteIP(r), "params:", params)
+	h.L.Error(auth.AddAudit(h.ProgramDir+h.AuthFile, auth.AuditEntry{
+		User:   userName,
+		IP:     remoteIP(r),
+		Action: action,
+		Params: params,
+		Result: result,
+	}))
+}
+
+// AuditGET returns the records of the audit log, the newest first.
+// Filters: ?user=bob&ip=10.0.0.1&action=power&since=2024-01-31&until=2024-02-01T12:00:00Z&limit=100
+//   - format=json: downloads the records as a JSON array
+//   - format=csv:  downloads the records as CSV
+//   - otherwise:   {"entries":[...]}
+//
+// The downloads are not limited, unless the limit is given.
+func (h *Handler) AuditGET(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, err := auth.ListAudit(h.ProgramDir+h.AuthFile, filter)
+	if err != nil {
+		h.L.Error(fmt.Errorf("ListAudit: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+		cw := csv.NewWriter(w)
+		h.L.Error(cw.Write([]string{"id", "time", "user", "ip", "action", "params", "result"}))
+		for _, e := range entries {
+			h.L.Error(cw.Write([]string{
+				strconv.FormatInt(e.ID, 10), e.Time.Format(time.RFC3339), e.User, e.IP, e.Action, e.Params, e.Result,
+			}))
+		}
+		cw.Flush()
+		h.L.Error(cw.Error())
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.json"`)
+		h.L.Error(json.NewEncoder(w).Encode(entries))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		h.L.Error(json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": entries,
+		}))
+	}
+}
+
+// auditFilter reads the filter of the audit log from the query parameters.
+func auditFilter(r *http.Request) (auth.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := auth.AuditFilter{
+		User:   q.Get("user"),
+		IP:     q.Get("ip"),
+		Action: q.Get("action"),
+		Limit:  auditDefaultLimit,
+	}
+	if format := q.Get("format"); format == "csv" || format == "json" {
+		filter.Limit = 0
+	}
+
+	var err error
+	if filter.Since, err = parseAuditTime(q.Get("since")); err != nil {
+		return filter, fmt.Errorf("invalid since: %s", q.Get("since"))
+	}
+	if filter.Until, err = parseAuditTime(q.Get("until")); err != nil {
+		return filter, fmt.Errorf("invalid until: %s", q.Get("until"))
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > auditMaxLimit {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		filter.Limit = n
+	}
+	return filter, nil
+}
+
+// parseAuditTime accepts a date (2006-01-02) in local time, or an RFC 3339 time.
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+// CSRFHeader is the header, which carries the CSRF token of the session.
+// monitor.js adds it to every request of the internal page.
+const CSRFHeader = "X-CSRF-Token"
+
+var checkCSRF = func(r *http.Request) bool {
+	return auth.ValidCSRFToken(r, r.Header.Get(CSRFHeader))
+}
+
+// csrfValid rejects the state-changing requests of a session, which do not carry its CSRF token.
+// Requests without a session are passed to the handler, which redirects them to the login page.
+func (h *Handler) csrfValid(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	// The API tokens are sent explicitly in the Authorization header, not by the browser.
+	userName := getUsername(r)
+	if userName == "" || auth.SessionID(r) == "" || checkCSRF(r) {
+		return true
+	}
+
+	h.L.Warning("invalid CSRF token:", r.Method, r.URL.Path, "user:", userName, "ip:", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	h.L.Error(json.NewEncoder(w).Encode(map[string]string{
+		"error": "invalid csrf token",
+	}))
+	return false
+}
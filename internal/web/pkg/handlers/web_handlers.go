@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
@@ -13,6 +17,7 @@ import (
 
 	"github.com/coder/websocket"
 	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/common/pkg/assets"
 	"github.com/takattila/monitor/internal/common/pkg/config"
 	"github.com/takattila/monitor/internal/web/pkg/auth"
 	"github.com/takattila/monitor/internal/web/pkg/terminal"
@@ -25,15 +30,19 @@ type (
 	Handler struct {
 		ProgramDir    string
 		FilesDir      string
+		Assets        fs.FS
 		AuthFile      string
 		AllowedIP     string
 		ApiService    ApiService
+		ApiClient     *http.Client
+		ApiRouter     http.Handler
 		LoginPage     string
 		InternalPage  string
 		LoginRoute    string
 		InternalRoute string
 		Cfg           *settings.Settings
 		L             logger.Logger
+		OIDC          *auth.OIDCProvider // The single sign-on provider, nil: the single sign-on is disabled.
 	}
 
 	ApiService struct {
@@ -42,87 +51,180 @@ type (
 	}
 )
 
+var (
+	// systemCtlActions are the actions of the systemctl route.
+	systemCtlActions = []string{"start", "stop", "restart", "enable", "disable"}
+
+	// validServiceName matches the unit names, e.g. getty@tty1.service, nothing else reaches the systemctl command.
+	validServiceName = regexp.MustCompile(`^[A-Za-z0-9@._:-]+$`)
+)
+
 var getUsername = func(r *http.Request) string {
 	return auth.GetUserName(r)
 }
 
-// Internal serves statistics page.
-func (h *Handler) Internal(w http.ResponseWriter, r *http.Request) {
-	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
-		return
+// assets returns the sources of the web interface: the embedded ones, overridden by the files of the web sources directory.
+func (h *Handler) assets() fs.FS {
+	if h.Assets == nil {
+		return assets.New(filepath.Join(h.ProgramDir, h.FilesDir))
 	}
+	return h.Assets
+}
 
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		t := time.Now()
-
-		tmpl := template.Must(
-			template.ParseFiles(
-				filepath.Join(
-					h.ProgramDir,
-					h.FilesDir,
-					h.InternalPage)))
-
-		data := struct {
-			Version         string
-			RouteSystemCtl  string
-			RoutePower      string
-			RouteKill       string
-			RouteToggle     string
-			RouteSettings   string
-			RouteLogout     string
-			RouteApi        string
-			RouteRun        string
-			RouteTerminal   string
-			RouteIndex      string
-			RouteWebPath    string
-			IntervalSeconds int
-		}{
-			Version:         fmt.Sprint(t.Year()) + fmt.Sprint(int(t.Month())) + fmt.Sprint(t.YearDay()) + fmt.Sprint(t.Minute()) + fmt.Sprint(t.Second()) + fmt.Sprint(t.Nanosecond()),
-			RouteSystemCtl:  config.GetString(h.Cfg, "on_start.routes.systemctl"),
-			RoutePower:      config.GetString(h.Cfg, "on_start.routes.power"),
-			RouteKill:       config.GetString(h.Cfg, "on_start.routes.kill"),
-			RouteToggle:     config.GetString(h.Cfg, "on_start.routes.toggle"),
-			RouteSettings:   config.GetString(h.Cfg, "on_start.routes.settings"),
-			RouteLogout:     config.GetString(h.Cfg, "on_start.routes.logout"),
-			RouteApi:        config.GetString(h.Cfg, "on_start.routes.api"),
-			RouteRun:        config.GetString(h.Cfg, "on_start.routes.run"),
-			RouteTerminal:   config.GetString(h.Cfg, "on_start.routes.terminal"),
-			RouteIndex:      config.GetString(h.Cfg, "on_start.routes.index"),
-			RouteWebPath:    config.GetString(h.Cfg, "on_start.routes.web"),
-			IntervalSeconds: config.GetInt(h.Cfg, "on_runtime.interval_seconds"),
-		}
-
-		tmpl.Execute(w, data)
+// apiClient returns the client of the MONITOR-API service, which signs the requests.
+// In the all-in-one mode, the requests are served by the API router in the same process.
+func (h *Handler) apiClient() *http.Client {
+	if h.ApiRouter != nil {
+		return &http.Client{Transport: inProcessTransport{handler: h.ApiRouter}}
 	}
+	if h.ApiClient == nil {
+		return http.DefaultClient
+	}
+	return h.ApiClient
+}
+
+// Internal serves statistics page.
+func (h *Handler) Internal(w http.ResponseWriter, r *http.Request) {
+	userName := getUsername(r)
+	t := time.Now()
+
+	tmpl := template.Must(template.ParseFS(h.assets(), strings.TrimPrefix(h.InternalPage, "/")))
+
+	can, permissions := h.permissionsOf(userName)
+
+	data := struct {
+		Version         string
+		UserName        string
+		Can             map[string]bool
+		Permissions     string
+		CSRFToken       string
+		RouteSystemCtl  string
+		RoutePower      string
+		RouteKill       string
+		RouteToggle     string
+		RouteSettings   string
+		RouteLogout     string
+		RouteApi        string
+		RouteRun        string
+		RouteRunJob     string
+		RouteRunStream  string
+		RouteTerminal   string
+		RouteUsers      string
+		RouteUser       string
+		RouteUserUnlock string
+		RouteTOTP       string
+		RouteSessions   string
+		RouteSession    string
+		RouteAudit      string
+		RouteTokens     string
+		RouteToken      string
+		RouteIndex      string
+		RouteWebPath    string
+		IntervalSeconds int
+	}{
+		Version:         fmt.Sprint(t.Year()) + fmt.Sprint(int(t.Month())) + fmt.Sprint(t.YearDay()) + fmt.Sprint(t.Minute()) + fmt.Sprint(t.Second()) + fmt.Sprint(t.Nanosecond()),
+		UserName:        userName,
+		Can:             can,
+		Permissions:     permissions,
+		CSRFToken:       auth.CSRFToken(r),
+		RouteSystemCtl:  config.GetString(h.Cfg, "on_start.routes.systemctl"),
+		RoutePower:      config.GetString(h.Cfg, "on_start.routes.power"),
+		RouteKill:       config.GetString(h.Cfg, "on_start.routes.kill"),
+		RouteToggle:     config.GetString(h.Cfg, "on_start.routes.toggle"),
+		RouteSettings:   config.GetString(h.Cfg, "on_start.routes.settings"),
+		RouteLogout:     config.GetString(h.Cfg, "on_start.routes.logout"),
+		RouteApi:        config.GetString(h.Cfg, "on_start.routes.api"),
+		RouteRun:        config.GetString(h.Cfg, "on_start.routes.run"),
+		RouteRunJob:     config.GetString(h.Cfg, "on_start.routes.run_job"),
+		RouteRunStream:  config.GetString(h.Cfg, "on_start.routes.run_stream"),
+		RouteTerminal:   config.GetString(h.Cfg, "on_start.routes.terminal"),
+		RouteUsers:      config.GetString(h.Cfg, "on_start.routes.users"),
+		RouteUser:       config.GetString(h.Cfg, "on_start.routes.user"),
+		RouteUserUnlock: config.GetString(h.Cfg, "on_start.routes.user_unlock"),
+		RouteTOTP:       config.GetString(h.Cfg, "on_start.routes.totp"),
+		RouteSessions:   config.GetString(h.Cfg, "on_start.routes.sessions"),
+		RouteSession:    config.GetString(h.Cfg, "on_start.routes.session"),
+		RouteAudit:      config.GetString(h.Cfg, "on_start.routes.audit"),
+		RouteTokens:     config.GetString(h.Cfg, "on_start.routes.tokens"),
+		RouteToken:      config.GetString(h.Cfg, "on_start.routes.token"),
+		RouteIndex:      config.GetString(h.Cfg, "on_start.routes.index"),
+		RouteWebPath:    config.GetString(h.Cfg, "on_start.routes.web"),
+		IntervalSeconds: config.GetInt(h.Cfg, "on_runtime.interval_seconds"),
+	}
+
+	tmpl.Execute(w, data)
 }
 
 // Login serves a login page.
+// After the password was accepted, the page asks for the second factor, or for the TOTP enrollment, if it is required.
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		t := time.Now()
-
-		tmpl := template.Must(
-			template.ParseFiles(
-				filepath.Join(
-					h.ProgramDir,
-					h.FilesDir,
-					h.LoginPage)))
-
-		data := struct {
-			Version      string
-			RouteIndex   string
-			RouteWebPath string
-		}{
-			Version:      fmt.Sprint(t.Year()) + fmt.Sprint(int(t.Month())) + fmt.Sprint(t.YearDay()) + fmt.Sprint(t.Minute()) + fmt.Sprint(t.Second()) + fmt.Sprint(t.Nanosecond()),
-			RouteIndex:   config.GetString(h.Cfg, "on_start.routes.index"),
-			RouteWebPath: config.GetString(h.Cfg, "on_start.routes.web"),
-		}
+	page := loginPage{Step: loginStepPassword}
+
+	if pending := auth.GetPendingLogin(r); pending != "" {
+		page.UserName = pending
+		page.Step = loginStepTOTP
+
+		enabled, err := auth.TOTPEnabled(h.ProgramDir+h.AuthFile, pending)
+		h.L.Error(err)
 
-		tmpl.Execute(w, data)
+		if !enabled {
+			page.Step = loginStepEnroll
+			page.Setup, err = auth.BeginTOTP(h.ProgramDir+h.AuthFile, pending)
+			if err != nil {
+				h.L.Error(err)
+				page.Step = loginStepPassword
+			}
+		}
 	}
+
+	page.Error = loginErrors[r.URL.Query().Get("error")]
+
+	h.renderLogin(w, page)
+}
+
+const (
+	loginStepPassword = "password"
+	loginStepTOTP     = "totp"
+	loginStepEnroll   = "enroll"
+	loginStepCodes    = "codes"
+)
+
+type loginPage struct {
+	Step          string
+	UserName      string
+	Error         string
+	Setup         *auth.TOTPSetup
+	RecoveryCodes []string
+}
+
+func (h *Handler) renderLogin(w http.ResponseWriter, page loginPage) {
+	t := time.Now()
+
+	tmpl := template.Must(template.ParseFS(h.assets(), strings.TrimPrefix(h.LoginPage, "/")))
+
+	data := struct {
+		loginPage
+		Version       string
+		RouteIndex    string
+		RouteLogout   string
+		RouteInternal string
+		RouteWebPath  string
+		RouteOIDC     string
+		OIDCButton    string
+	}{
+		loginPage:     page,
+		Version:       fmt.Sprint(t.Year()) + fmt.Sprint(int(t.Month())) + fmt.Sprint(t.YearDay()) + fmt.Sprint(t.Minute()) + fmt.Sprint(t.Second()) + fmt.Sprint(t.Nanosecond()),
+		RouteIndex:    config.GetString(h.Cfg, "on_start.routes.index"),
+		RouteLogout:   config.GetString(h.Cfg, "on_start.routes.logout"),
+		RouteInternal: h.InternalRoute,
+		RouteWebPath:  config.GetString(h.Cfg, "on_start.routes.web"),
+	}
+	if h.oidcEnabled() {
+		data.RouteOIDC = config.GetString(h.Cfg, "on_start.routes.oidc_login")
+		data.OIDCButton = config.GetString(h.Cfg, "on_start.oidc.button_text")
+	}
+
+	tmpl.Execute(w, data)
 }
 
 // =====================================================================================================================================
@@ -132,67 +234,59 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 // SystemCtl queries or sends control commands to the systemd manager.
 func (h *Handler) SystemCtl(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
+	action := chi.URLParam(r, "action")
+	service := chi.URLParam(r, "service")
+
+	// The command is run without a shell, the arguments are checked anyway.
+	if !common.SliceContains(systemCtlActions, action) || !validServiceName.MatchString(service) {
+		h.L.Error(fmt.Errorf("action: %s, service: %s is not allowed", action, service))
+		h.audit(r, userName, auth.AuditService, action+" "+service, "not allowed")
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		action := chi.URLParam(r, "action")
-		service := chi.URLParam(r, "service")
-
-		if common.SliceContains([]string{"start", "stop", "restart", "enable", "disable"}, action) {
-			h.L.Info("action:", action, "service:", service)
-			cmd := config.GetStringSlice(h.Cfg, "on_runtime.commands.systemctl")
-			cmd = common.ReplaceStringInSlice(cmd, "{action}", action)
-			cmd = common.ReplaceStringInSlice(cmd, "{service}", service)
-			fmt.Fprintf(w, "%s", common.Cli(cmd))
-		} else {
-			h.L.Error(fmt.Errorf("action: %s is not allowed", action))
-		}
-	}
+	h.L.Info("action:", action, "service:", service)
+	cmd := config.GetStringSlice(h.Cfg, "on_runtime.commands.systemctl")
+	cmd = common.ReplaceStringInSlice(cmd, "{action}", action)
+	cmd = common.ReplaceStringInSlice(cmd, "{service}", service)
+	output := common.Cli(cmd)
+	h.audit(r, userName, auth.AuditService, action+" "+service, output)
+	fmt.Fprintf(w, "%s", output)
 }
 
 // Power runs power actions: shutdown or reboot.
 func (h *Handler) Power(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
-		return
-	}
-
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		action := chi.URLParam(r, "action")
-
-		initNumber := "0"
-		if action == "reboot" {
-			initNumber = "6"
-		}
+	action := chi.URLParam(r, "action")
 
-		h.L.Warning("action:", action)
-		cmd := config.GetStringSlice(h.Cfg, "on_runtime.commands.init")
-		cmd = common.ReplaceStringInSlice(cmd, "{number}", initNumber)
-		fmt.Fprintf(w, "%s", common.Cli(cmd))
+	initNumber := "0"
+	if action == "reboot" {
+		initNumber = "6"
 	}
+
+	h.L.Warning("action:", action)
+	// The record is written first, the machine may go down before the command returns.
+	h.audit(r, userName, auth.AuditPower, action, "requested")
+	cmd := config.GetStringSlice(h.Cfg, "on_runtime.commands.init")
+	cmd = common.ReplaceStringInSlice(cmd, "{number}", initNumber)
+	fmt.Fprintf(w, "%s", common.Cli(cmd))
 }
 
 // Kill stops a specific process based on its PID.
 func (h *Handler) Kill(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
+	pid, err := strconv.Atoi(chi.URLParam(r, "pid"))
+	if err != nil || pid <= 0 {
+		h.L.Error(fmt.Errorf("invalid pid: %s", chi.URLParam(r, "pid")))
+		h.audit(r, userName, auth.AuditKill, chi.URLParam(r, "pid"), "invalid pid")
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		pid := chi.URLParam(r, "pid")
-		h.L.Warning("pid:", pid)
-		cmd := []string{"bash", "-c", fmt.Sprintf("kill %s", pid)}
-		fmt.Fprintf(w, "%s", common.Cli(cmd))
-	}
+	h.L.Warning("pid:", pid)
+	output := common.Cli([]string{"kill", strconv.Itoa(pid)})
+	h.audit(r, userName, auth.AuditKill, strconv.Itoa(pid), output)
+	fmt.Fprintf(w, "%s", output)
 }
 
 // =====================================================================================================================================
@@ -200,81 +294,140 @@ func (h *Handler) Kill(w http.ResponseWriter, r *http.Request) {
 // =====================================================================================================================================
 
 // Api handler sends requests to the MONITOR-API service.
-// Basicaly it is a proxy.
+// It is a reverse proxy, the responses are streamed back with their status codes.
 func (h *Handler) Api(w http.ResponseWriter, r *http.Request) {
+	_, _ = h.proxyAPI(w, r, chi.URLParam(r, "statistics"))
+}
+
+// Run makes an API request to the run endpoints: /run/{action}/{name} or /run/{id}.
+// The exec and cancel actions must be posted.
+func (h *Handler) Run(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
+	permission, ok := runActionPermissions[chi.URLParam(r, "action")]
+	if ok && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ok && !h.allowed(w, r, userName, permission) {
 		return
 	}
 
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		statistics := chi.URLParam(r, "statistics")
+	path := "run/" + chi.URLParam(r, "id")
+	if action := chi.URLParam(r, "action"); action != "" {
+		path = "run/" + action + "/" + chi.URLParam(r, "name")
+	}
 
-		requestURL := fmt.Sprintf("%s:%d/%s", config.GetString(h.Cfg, "on_runtime.api.url"), config.GetInt(h.Cfg, "on_runtime.api.port"), statistics)
-		res, err := http.Get(requestURL)
-		if err != nil {
-			h.L.Error(fmt.Errorf("making http request: %v", err))
-			return
-		}
+	// The executions and the cancellations are audited, the queries are not.
+	// The parameters of the run commands are passed as query parameters.
+	params := strings.TrimSpace(strings.TrimPrefix(path, "run/") + " " + r.URL.RawQuery)
 
-		h.L.Debug(requestURL, "client: status code:", res.StatusCode)
+	body, err := h.proxyAPI(w, r, path)
+	if !ok {
+		return
+	}
+	if err != nil {
+		h.audit(r, userName, auth.AuditRun, params, err.Error())
+		return
+	}
+	h.audit(r, userName, auth.AuditRun, params, body)
+}
 
-		resBody, err := ioutil.ReadAll(res.Body)
+// RunStream upgrades the connection to a WebSocket and forwards the output of a job
+// from the API as binary messages. When the job is finished, a text message is sent:
+// {"type":"done","status":"finished","exit_code":0}
+func (h *Handler) RunStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
 		h.L.Error(err)
+		return
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	// The client does not send anything, CloseRead cancels the context, when the client goes away.
+	ctx := conn.CloseRead(r.Context())
 
-		fmt.Fprintf(w, "%s", resBody)
+	requestURL, err := h.apiURL("run/stream/" + chi.URLParam(r, "id"))
+	if err != nil {
+		h.L.Error(err)
+		h.L.Error(writeStreamMessage(ctx, conn, runStreamMessage{Type: "error", Error: "invalid API URL"}))
+		return
 	}
-}
 
-// Run makes an API request to the run endpoint: /run/{action}/{name}.
-func (h *Handler) Run(w http.ResponseWriter, r *http.Request) {
-	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		h.L.Error(err)
 		return
 	}
 
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		action := chi.URLParam(r, "action")
-		name := chi.URLParam(r, "name")
+	res, err := h.apiClient().Do(req)
+	if err != nil {
+		h.L.Error(fmt.Errorf("making http request: %v", err))
+		h.L.Error(writeStreamMessage(ctx, conn, runStreamMessage{Type: "error", Error: "the API service is not available"}))
+		return
+	}
+	defer res.Body.Close()
 
-		requestURL := fmt.Sprintf("%s:%d/run/%s/%s",
-			config.GetString(h.Cfg, "on_runtime.api.url"),
-			config.GetInt(h.Cfg, "on_runtime.api.port"),
-			action,
-			name)
+	h.L.Debug(requestURL, "client: status code:", res.StatusCode)
 
-		res, err := http.Get(requestURL)
+	if res.StatusCode != http.StatusOK {
+		apiError := struct {
+			Error string `json:"error"`
+		}{}
+		h.L.Error(json.NewDecoder(res.Body).Decode(&apiError))
+		h.L.Error(writeStreamMessage(ctx, conn, runStreamMessage{Type: "error", Error: apiError.Error}))
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if werr := conn.Write(ctx, websocket.MessageBinary, buf[:n]); werr != nil {
+				h.L.Debug("RunStream", "client:", werr)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			h.L.Error(fmt.Errorf("making http request: %v", err))
+			h.L.Debug("RunStream", "api:", err)
 			return
 		}
+	}
 
-		h.L.Debug(requestURL, "client: status code:", res.StatusCode)
+	exitCode, err := strconv.Atoi(res.Trailer.Get("X-Run-Exit-Code"))
+	if err != nil {
+		exitCode = -1
+	}
 
-		resBody, err := ioutil.ReadAll(res.Body)
-		h.L.Error(err)
+	h.L.Error(writeStreamMessage(ctx, conn, runStreamMessage{
+		Type:     "done",
+		Status:   res.Trailer.Get("X-Run-Status"),
+		ExitCode: &exitCode,
+	}))
+}
+
+// runStreamMessage is a server -> client text message of the run output stream.
+type runStreamMessage struct {
+	Type     string `json:"type"`
+	Status   string `json:"status,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
 
-		fmt.Fprintf(w, "%s", resBody)
+// writeStreamMessage sends a JSON text message to the client.
+func writeStreamMessage(ctx context.Context, conn *websocket.Conn, msg runStreamMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
 	}
+	return conn.Write(ctx, websocket.MessageText, b)
 }
 
 // Terminal upgrades the connection to a WebSocket and serves a shell session.
 func (h *Handler) Terminal(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
-		return
-	}
-
-	if !IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		return
-	}
-
 	cols := 80
 	rows := 24
 	if v := r.URL.Query().Get("cols"); v != "" {
@@ -295,70 +448,85 @@ func (h *Handler) Terminal(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close(websocket.StatusNormalClosure, "")
 
-	if err := terminal.Serve(conn, terminal.DetectShell(), terminal.HomeDir(), cols, rows, config.GetString(h.Cfg, "on_start.terminal_user")); err != nil {
+	opened := time.Now()
+	shell := terminal.DetectShell()
+	h.audit(r, userName, auth.AuditTerminalOpen, shell, "ok")
+
+	result := "ok"
+	if err := terminal.Serve(conn, shell, terminal.HomeDir(), cols, rows, config.GetString(h.Cfg, "on_start.terminal_user")); err != nil {
 		h.L.Error(err)
+		result = err.Error()
 	}
+	h.audit(r, userName, auth.AuditTerminalClose, shell+" duration: "+time.Since(opened).Round(time.Second).String(), result)
 }
 
 // Index checks user credentials.
+// Users with TOTP enabled, and the admins when 'on_runtime.totp_required_for_admins' is set,
+// get a short-lived pending login after the password, and the session only after the second factor.
 func (h *Handler) Index(response http.ResponseWriter, request *http.Request) {
 	h.L.Debug("AllowedIP:", config.GetString(h.Cfg, "on_runtime.allowed_ip"), "Request IP:", request.RemoteAddr)
+	path := filepath.Join(config.GetString(h.Cfg, "on_start.routes.index")) + "/"
+
+	if pending, code := auth.GetPendingLogin(request), request.FormValue("otp"); pending != "" && code != "" {
+		h.secondFactor(response, request, pending, code)
+		return
+	}
+
 	name := request.FormValue("uname")
 	pass := request.FormValue("psw")
 	redirectTarget := h.LoginRoute
-	authenticated := auth.Authenticate(h.ProgramDir+h.AuthFile, name, pass)
-	h.L.Debug("Authenticate", authenticated)
 
-	if name != "" && pass != "" && authenticated {
-		path := filepath.Join(config.GetString(h.Cfg, "on_start.routes.index")) + "/"
-		auth.SetSession(path, name, response)
-		redirectTarget = h.InternalRoute
+	if name != "" && pass != "" {
+		if h.loginBlocked(response, request, name) {
+			return
+		}
+
+		authenticated := auth.Authenticate(h.ProgramDir+h.AuthFile, name, pass)
+		h.L.Debug("Authenticate", authenticated)
+		if !authenticated {
+			h.loginFailed(response, request, name, loginErrorPassword)
+			return
+		}
+
+		if h.needsSecondFactor(name) {
+			auth.SetPendingLogin(h.pendingPath(), name, response)
+		} else if err := auth.SetSession(path, name, response, request); err != nil {
+			h.L.Error(err)
+		} else {
+			h.loginSucceeded(request, name)
+			redirectTarget = h.InternalRoute
+		}
 	}
 	http.Redirect(response, request, redirectTarget, 302)
 }
 
 // Logout clears user session.
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
-	h.L.Info(getUsername(r))
+	userName := getUsername(r)
+	h.L.Info(userName)
+	if userName != "" {
+		h.audit(r, userName, auth.AuditLogout, "", "ok")
+	}
 	path := filepath.Join(config.GetString(h.Cfg, "on_start.routes.index")) + "/"
-	auth.ClearSession(path, w)
+	auth.ClearSession(path, w, r)
+	auth.ClearPendingLogin(h.pendingPath(), w)
 	http.Redirect(w, r, h.LoginRoute, 302)
 }
 
 // Toggle ...
 func (h *Handler) Toggle(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
-		return
-	}
-
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		section := chi.URLParam(r, "section")
-		status := chi.URLParam(r, "status")
-
-		h.L.Info("section:", section, "status:", status)
-
-		requestURL := fmt.Sprintf("%s:%d/toggle/%s/%s",
-			config.GetString(h.Cfg, "on_runtime.api.url"),
-			config.GetInt(h.Cfg, "on_runtime.api.port"),
-			section,
-			status)
-
-		res, err := http.Get(requestURL)
-		if err != nil {
-			h.L.Error(fmt.Errorf("making http request: %v", err))
-			return
-		}
-
-		h.L.Debug(requestURL, "client: status code:", res.StatusCode)
+	section := chi.URLParam(r, "section")
+	status := chi.URLParam(r, "status")
 
-		resBody, err := ioutil.ReadAll(res.Body)
-		h.L.Error(err)
+	h.L.Info("section:", section, "status:", status)
 
-		fmt.Fprintf(w, "%s", resBody)
+	body, err := h.proxyAPI(w, r, "toggle/"+section+"/"+status)
+	if err != nil {
+		h.audit(r, userName, auth.AuditToggle, section+" "+status, err.Error())
+		return
 	}
+	h.audit(r, userName, auth.AuditToggle, section+" "+status, body)
 }
 
 type settingsRequest struct {
@@ -370,101 +538,71 @@ type settingsRequest struct {
 // preset) as JSON from the database.
 func (h *Handler) SettingsGET(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
-		return
+	settings, err := auth.GetUserSettings(h.ProgramDir+h.AuthFile, userName)
+	if err != nil {
+		h.L.Error(fmt.Errorf("GetUserSettings: %v", err))
+		settings = map[string]string{}
 	}
 
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		settings, err := auth.GetUserSettings(h.ProgramDir+h.AuthFile, userName)
-		if err != nil {
-			h.L.Error(fmt.Errorf("GetUserSettings: %v", err))
-			settings = map[string]string{}
-		}
-
-		response := map[string]string{
-			"skin":   "",
-			"css":    "",
-			"logo":   "",
-			"preset": "",
-		}
-		for k, v := range settings {
-			response[k] = v
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	response := map[string]string{
+		"skin":   "",
+		"css":    "",
+		"logo":   "",
+		"preset": "",
 	}
+	for k, v := range settings {
+		response[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // SettingsPOST saves a single UI preference for the authenticated user.
 // The request body must be JSON: {"key": "css", "value": "rpi"}.
 func (h *Handler) SettingsPOST(w http.ResponseWriter, r *http.Request) {
 	userName := getUsername(r)
-	h.L.Debug("userName:", userName)
-	if userName == "" {
-		http.Redirect(w, r, h.LoginRoute, 302)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		h.L.Error(fmt.Errorf("reading body: %v", err))
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	if IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			h.L.Error(fmt.Errorf("reading body: %v", err))
-			http.Error(w, "bad request", http.StatusBadRequest)
-			return
-		}
-
-		var req settingsRequest
-		if err := json.Unmarshal(body, &req); err != nil {
-			h.L.Error(fmt.Errorf("unmarshal body: %v", err))
-			http.Error(w, "bad request", http.StatusBadRequest)
-			return
-		}
-
-		if req.Key == "" {
-			http.Error(w, "missing key", http.StatusBadRequest)
-			return
-		}
+	var req settingsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		h.L.Error(fmt.Errorf("unmarshal body: %v", err))
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
 
-		err = auth.SaveUserSetting(h.ProgramDir+h.AuthFile, userName, req.Key, req.Value)
-		if err != nil {
-			h.L.Error(fmt.Errorf("SaveUserSetting: %v", err))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
-		}
+	if req.Key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"status":"ok","key":"%s","value":"%s"}`, req.Key, req.Value)
+	err = auth.SaveUserSetting(h.ProgramDir+h.AuthFile, userName, req.Key, req.Value)
+	if err != nil {
+		h.L.Error(fmt.Errorf("SaveUserSetting: %v", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"ok","key":"%s","value":"%s"}`, req.Key, req.Value)
 }
 
 // IPisAllowed checks whether the request IP is allowed or not.
+// The allowed IPs are a comma separated list of IPv4 or IPv6 addresses and CIDR ranges,
+// 0.0.0.0 or an empty list allows any IP.
 func IPisAllowed(requestIP, allowedIP string, h *Handler) bool {
 	h.L.Debug("Request IP:", requestIP, "Allowed IP:", allowedIP)
-	// If the IP contains port number as well, the port should be rmoved from the requestIP.
-	if strings.Contains(requestIP, ":") {
-		requestIP = strings.Split(requestIP, ":")[0]
-	}
-	if allowedIP == "0.0.0.0" {
+	allowedIP = strings.TrimSpace(allowedIP)
+	if allowedIP == "" || allowedIP == "0.0.0.0" {
 		h.L.Debug("Allowed IP was not set")
 		return true
 	}
-	if strings.Contains(allowedIP, ",") {
-		h.L.Debug("Multiple IP were set for allowedIP:", allowedIP)
-		ips := strings.Split(allowedIP, ",")
-		ret := false
-		for _, ip := range ips {
-			h.L.Debug("allowedIP", ip, "requestIP", requestIP)
-			if requestIP == ip {
-				ret = true
-			}
-		}
-		h.L.Debug("IP is allowed ("+requestIP+"):", ret)
-		return ret
-	}
-	if allowedIP != requestIP {
+	if !ipInList(hostOf(requestIP), allowedIP, h) {
 		h.L.Error(fmt.Errorf("IP not allowed: %s", requestIP))
 		return false
 	}
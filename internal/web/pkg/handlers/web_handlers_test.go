@@ -7,11 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -19,6 +23,7 @@ import (
 	"github.com/coder/websocket"
 	"github.com/go-chi/chi"
 	"github.com/phayes/freeport"
+	"github.com/pquerna/otp/hotp"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/bcrypt"
 	_ "modernc.org/sqlite"
@@ -29,12 +34,15 @@ import (
 	"github.com/takattila/monitor/internal/api/pkg/network"
 	"github.com/takattila/monitor/internal/api/pkg/playground"
 	"github.com/takattila/monitor/internal/api/pkg/processes"
+	apiroutes "github.com/takattila/monitor/internal/api/pkg/routes"
 	"github.com/takattila/monitor/internal/api/pkg/run"
 	apiservers "github.com/takattila/monitor/internal/api/pkg/servers"
 	"github.com/takattila/monitor/internal/api/pkg/services"
 	"github.com/takattila/monitor/internal/api/pkg/storage"
+	"github.com/takattila/monitor/internal/common/pkg/apiauth"
 	"github.com/takattila/monitor/internal/common/pkg/config"
 	"github.com/takattila/monitor/internal/web/pkg/auth"
+	"github.com/takattila/monitor/internal/web/pkg/oidctest"
 	"github.com/takattila/monitor/internal/web/pkg/servers"
 	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
@@ -53,9 +61,10 @@ func (errorReadCloser) Read([]byte) (int, error) { return 0, errors.New("read er
 func (errorReadCloser) Close() error             { return nil }
 
 var (
-	gitRootPath = strings.ReplaceAll(common.Cli([]string{"bash", "-c", "git rev-parse --show-toplevel"}), "\n", "")
-	s           = getConfig("web", "linux")
-	h           = &Handler{
+	gitRootPath   = strings.ReplaceAll(common.Cli([]string{"bash", "-c", "git rev-parse --show-toplevel"}), "\n", "")
+	s             = getConfig("web", "linux")
+	testAPISecret = []byte("secret of the test API service")
+	h             = &Handler{
 		ProgramDir:    gitRootPath,
 		FilesDir:      config.GetString(s, "on_start.web_sources_directory"),
 		AuthFile:      config.GetString(s, "on_start.auth_file"),
@@ -63,13 +72,27 @@ var (
 		InternalPage:  config.GetString(s, "on_start.pages.internal"),
 		LoginRoute:    config.GetString(s, "on_start.routes.login"),
 		InternalRoute: config.GetString(s, "on_start.routes.internal"),
+		ApiClient:     apiauth.NewClient(testAPISecret, nil, ""),
 		Cfg:           s,
 		L:             logger.New(logger.NoneLevel, logger.ColorOff),
 	}
 
 	r = chi.NewRouter()
+
+	defaultGetUserRole = getUserRole
+	defaultCheckCSRF   = checkCSRF
 )
 
+func (a WebHandlersSuite) SetupTest() {
+	h.AuthFile = "/configs/testauth.db"
+	bypassGetUserRole(auth.RoleAdmin)
+	bypassCheckCSRF(true)
+}
+
+func (a WebHandlersSuite) TearDownTest() {
+	_ = os.Remove(h.ProgramDir + h.AuthFile)
+}
+
 func (a WebHandlersSuite) TestInternalNotAuthenticated() {
 	go startWebServer(a.T())
 	time.Sleep(100 * time.Millisecond)
@@ -108,6 +131,318 @@ func (a WebHandlersSuite) TestLoginOk() {
 	a.Equal(200, resp.StatusCode)
 }
 
+func (a WebHandlersSuite) TestLoginLockout() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	h.Cfg.Data.Set("on_runtime.login_protection.lockout_after_failures", 3)
+	defer h.Cfg.Data.Set("on_runtime.login_protection.lockout_after_failures", 10)
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	client := newCookieClient()
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+	indexURL := baseURL + config.GetString(s, "on_start.routes.index")
+
+	_, body := postForm(client, indexURL, url.Values{"uname": {user}, "psw": {"wrong"}})
+	a.Contains(body, "Invalid username or password.")
+	_, body = postForm(client, indexURL, url.Values{"uname": {user}, "psw": {"wrong"}})
+	a.Contains(body, "Invalid username or password.")
+	_, body = postForm(client, indexURL, url.Values{"uname": {user}, "psw": {"wrong"}})
+	a.Contains(body, "The account is locked")
+
+	// The right password is not checked, while the account is locked.
+	resp, body := postForm(client, indexURL, url.Values{"uname": {user}, "psw": {pass}})
+	a.Equal(h.LoginRoute, resp.Request.URL.Path)
+	a.Contains(body, "The account is locked")
+	a.Equal("", sessionUser(client, indexURL))
+
+	users, err := auth.ListUsers(authdb)
+	a.Equal(nil, err)
+	a.True(users[0].Locked)
+
+	oldGetUsernameFunc := bypassGetUsername(user)
+	unlockURL := baseURL + strings.Replace(config.GetString(s, "on_start.routes.user_unlock"), "{username}", user, 1)
+	body2, status, err := reqWithBody("POST", unlockURL, nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+	a.JSONEq(`{"status":"ok","username":"username"}`, string(body2))
+	_, status, err = reqWithBody("POST", strings.Replace(unlockURL, user, "not_exists", 1), nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusNotFound, status)
+	getUsername = oldGetUsernameFunc
+
+	resp, _ = postForm(client, indexURL, url.Values{"uname": {user}, "psw": {pass}})
+	a.Equal(h.InternalRoute, resp.Request.URL.Path)
+	a.Equal(user, sessionUser(client, indexURL))
+}
+
+func (a WebHandlersSuite) TestCSRF() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	checkCSRF = defaultCheckCSRF
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	client := newCookieClient()
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+	settingsURL := baseURL + config.GetString(s, "on_start.routes.settings")
+
+	resp, body := postForm(client, baseURL+config.GetString(s, "on_start.routes.index"), url.Values{"uname": {user}, "psw": {pass}})
+	a.Equal(h.InternalRoute, resp.Request.URL.Path)
+
+	const prefix = `let CSRF_TOKEN = "`
+	a.Require().Contains(body, prefix)
+	token := body[strings.Index(body, prefix)+len(prefix):]
+	token = token[:strings.Index(token, `"`)]
+	a.NotEqual("", token)
+
+	post := func(url, token string) int {
+		request, _ := http.NewRequest("POST", url, strings.NewReader(`{"key":"skin","value":"dark"}`))
+		request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			request.Header.Set(CSRFHeader, token)
+		}
+		resp, err := client.Do(request)
+		a.Require().NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	a.Equal(http.StatusForbidden, post(settingsURL, ""))
+	a.Equal(http.StatusForbidden, post(settingsURL, "wrong"))
+	a.Equal(http.StatusOK, post(settingsURL, token))
+
+	// Another session has another token.
+	other := newCookieClient()
+	postForm(other, baseURL+config.GetString(s, "on_start.routes.index"), url.Values{"uname": {user}, "psw": {pass}})
+	client = other
+	a.Equal(http.StatusForbidden, post(settingsURL, token))
+
+	// The actions are not accepted with GET anymore.
+	resp, err := client.Get(baseURL + "/monitor/toggle/Memory/true")
+	a.Require().NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = client.Get(baseURL + "/monitor/run/exec/get_storages")
+	a.Require().NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func (a WebHandlersSuite) TestLoginTOTP() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	setup, err := auth.BeginTOTP(authdb, user)
+	a.Require().NoError(err)
+	step := uint64(time.Now().Unix() / 30)
+	confirmCode, _ := hotp.GenerateCode(setup.Secret, step-1)
+	codes, err := auth.ConfirmTOTP(authdb, user, confirmCode)
+	a.Require().NoError(err)
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	client := newCookieClient()
+	indexURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), config.GetString(s, "on_start.routes.index"))
+
+	// The password leads to the second step, without a session.
+	resp, body := postForm(client, indexURL, url.Values{"uname": {user}, "psw": {pass}})
+	a.Equal(h.LoginRoute, resp.Request.URL.Path)
+	a.Contains(body, `name="otp"`)
+	a.Equal("", sessionUser(client, indexURL))
+
+	resp, body = postForm(client, indexURL, url.Values{"otp": {"000000"}})
+	a.Equal(h.LoginRoute, resp.Request.URL.Path)
+	a.Contains(body, "Invalid code")
+
+	resp, _ = postForm(client, indexURL, url.Values{"otp": {codes[0]}})
+	a.Equal(h.InternalRoute, resp.Request.URL.Path)
+	a.Equal(user, sessionUser(client, indexURL))
+}
+
+func (a WebHandlersSuite) TestLoginTOTPRequiredForAdmins() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	h.Cfg.Data.Set("on_runtime.totp_required_for_admins", true)
+	defer h.Cfg.Data.Set("on_runtime.totp_required_for_admins", false)
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	client := newCookieClient()
+	indexURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), config.GetString(s, "on_start.routes.index"))
+
+	_, body := postForm(client, indexURL, url.Values{"uname": {user}, "psw": {pass}})
+	a.Contains(body, "Set up two-factor authentication")
+	a.Contains(body, "data:image/png;base64,")
+	a.Equal("", sessionUser(client, indexURL))
+
+	secret := body[strings.Index(body, "<code>")+len("<code>") : strings.Index(body, "</code>")]
+	code, err := hotp.GenerateCode(secret, uint64(time.Now().Unix()/30))
+	a.Require().NoError(err)
+
+	_, body = postForm(client, indexURL, url.Values{"otp": {code}})
+	a.Contains(body, "Recovery codes")
+	a.Equal(user, sessionUser(client, indexURL))
+
+	enabled, err := auth.TOTPEnabled(authdb, user)
+	a.Equal(nil, err)
+	a.True(enabled)
+}
+
+func (a WebHandlersSuite) TestTOTPOk() {
+	user := "username"
+	authdb := newTestAuthDB(a.T(), user, "password")
+	defer func() { _ = os.Remove(authdb) }()
+
+	oldGetUsernameFunc := bypassGetUsername(user)
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	totpURL := func(action string) string {
+		return fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"),
+			strings.Replace(config.GetString(s, "on_start.routes.totp"), "{action}", action, 1))
+	}
+
+	body, status, err := reqWithBody("GET", totpURL("status"), nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+	a.JSONEq(`{"enabled":false,"required":false}`, string(body))
+
+	body, status, err = reqWithBody("POST", totpURL("setup"), nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+	var setup auth.TOTPSetup
+	a.Equal(nil, json.Unmarshal(body, &setup))
+
+	step := uint64(time.Now().Unix() / 30)
+	code, _ := hotp.GenerateCode(setup.Secret, step-1)
+	body, status, err = reqWithBody("POST", totpURL("confirm"), strings.NewReader(`{"code":"`+code+`"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+	var confirmed map[string][]string
+	a.Equal(nil, json.Unmarshal(body, &confirmed))
+	a.Equal(10, len(confirmed["recovery_codes"]))
+
+	_, status, err = reqWithBody("POST", totpURL("setup"), nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusConflict, status)
+
+	_, status, err = reqWithBody("POST", totpURL("disable"), strings.NewReader(`{"code":"000000"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusBadRequest, status)
+
+	_, status, err = reqWithBody("POST", totpURL("disable"), strings.NewReader(`{"code":"`+confirmed["recovery_codes"][0]+`"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+
+	_, status, err = reqWithBody("POST", totpURL("unknown"), strings.NewReader(`{}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusNotFound, status)
+}
+
+func (a WebHandlersSuite) TestSessionsOk() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+	indexURL := baseURL + config.GetString(s, "on_start.routes.index")
+	sessionsURL := baseURL + config.GetString(s, "on_start.routes.sessions")
+
+	clients := []*http.Client{newCookieClient(), newCookieClient(), newCookieClient()}
+	for _, client := range clients {
+		resp, _ := postForm(client, indexURL, url.Values{"uname": {user}, "psw": {pass}})
+		a.Equal(h.InternalRoute, resp.Request.URL.Path)
+	}
+
+	sessions := func(client *http.Client) []auth.Session {
+		resp, err := client.Get(sessionsURL)
+		a.Require().NoError(err)
+		defer resp.Body.Close()
+		a.Equal(http.StatusOK, resp.StatusCode)
+
+		var list map[string][]auth.Session
+		a.Equal(nil, json.NewDecoder(resp.Body).Decode(&list))
+		return list["sessions"]
+	}
+
+	revoke := func(client *http.Client, url string) (int, string) {
+		request, _ := http.NewRequest("DELETE", url, nil)
+		resp, err := client.Do(request)
+		a.Require().NoError(err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	list := sessions(clients[0])
+	a.Equal(3, len(list))
+
+	var current, other auth.Session
+	for _, session := range list {
+		if session.Current {
+			current = session
+		} else {
+			other = session
+		}
+	}
+	a.NotEqual("", current.ID)
+	a.Equal("Go-http-client/1.1", current.UserAgent)
+
+	sessionURL := func(id string) string {
+		return baseURL + strings.Replace(config.GetString(s, "on_start.routes.session"), "{id}", id, 1)
+	}
+
+	status, _ := revoke(clients[0], sessionURL(other.ID))
+	a.Equal(http.StatusOK, status)
+	status, _ = revoke(clients[0], sessionURL(other.ID))
+	a.Equal(http.StatusNotFound, status)
+	a.Equal(2, len(sessions(clients[0])))
+
+	status, body := revoke(clients[0], sessionsURL)
+	a.Equal(http.StatusOK, status)
+	a.JSONEq(`{"status":"ok","revoked":1}`, body)
+
+	a.Equal(user, sessionUser(clients[0], indexURL))
+	a.Equal("", sessionUser(clients[1], indexURL))
+	a.Equal("", sessionUser(clients[2], indexURL))
+
+	// The logout removes the session on the server side too.
+	resp, err := clients[0].Get(baseURL + config.GetString(s, "on_start.routes.logout"))
+	a.Require().NoError(err)
+	resp.Body.Close()
+	list, err = auth.ListSessions(authdb, user, &http.Request{})
+	a.Equal(nil, err)
+	a.Equal(0, len(list))
+}
+
 func (a WebHandlersSuite) TestSystemCtlOk() {
 	user := "username"
 	pass := "password"
@@ -152,6 +487,29 @@ func (a WebHandlersSuite) TestTerminalNotAuthenticated() {
 	a.Equal(302, resp.StatusCode)
 }
 
+func (a WebHandlersSuite) TestRequireNotAuthenticated() {
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	port := config.GetInt(s, "on_start.port")
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+
+	for _, tc := range []struct{ method, path string }{
+		{"GET", "/monitor/internal"},
+		{"POST", "/monitor/toggle/cpu/false"},
+		{"POST", "/monitor/power/reboot"},
+		{"GET", "/monitor/users"},
+		{"DELETE", "/monitor/sessions"},
+	} {
+		request, _ := http.NewRequest(tc.method, fmt.Sprintf("http://127.0.0.1:%d%s", port, tc.path), nil)
+		resp, err := client.Do(request)
+		a.Require().NoError(err)
+		resp.Body.Close()
+		a.Equal(http.StatusFound, resp.StatusCode, tc.path)
+		a.Equal(h.LoginRoute, resp.Header.Get("Location"), tc.path)
+	}
+}
+
 func (a WebHandlersSuite) TestTerminalOk() {
 	oldGetUsernameFunc := bypassGetUsername("username")
 	defer func() { getUsername = oldGetUsernameFunc }()
@@ -210,6 +568,164 @@ func (a WebHandlersSuite) TestTerminalAcceptError() {
 	a.Equal(426, resp.StatusCode)
 }
 
+func (a WebHandlersSuite) TestInternalHidesControlsForViewer() {
+	oldGetUsernameFunc := bypassGetUsername("username")
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	internalURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), config.GetString(s, "on_start.routes.internal"))
+
+	body, status, err := reqWithBody("GET", internalURL, nil)
+	a.Equal(nil, err)
+	a.Equal(200, status)
+	a.Contains(string(body), `id="power"`)
+	a.Contains(string(body), `id="terminal"`)
+	a.Contains(string(body), `id="users"`)
+	a.Contains(string(body), `let PERMISSIONS = ["view","operate","run","services","kill","power","terminal","users","audit"];`)
+
+	bypassGetUserRole(auth.RoleViewer)
+
+	body, status, err = reqWithBody("GET", internalURL, nil)
+	a.Equal(nil, err)
+	a.Equal(200, status)
+	a.NotContains(string(body), `id="power"`)
+	a.NotContains(string(body), `id="terminal"`)
+	a.NotContains(string(body), `id="users"`)
+	a.NotContains(string(body), `js/users.js`)
+	a.Contains(string(body), `let PERMISSIONS = ["view"];`)
+}
+
+func (a WebHandlersSuite) TestForbiddenForRole() {
+	oldGetUsernameFunc := bypassGetUsername("username")
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	port := config.GetInt(s, "on_start.port")
+
+	for _, tc := range []struct {
+		role, method, path, permission string
+	}{
+		{auth.RoleViewer, "POST", "/monitor/power/reboot", auth.PermPower},
+		{auth.RoleViewer, "POST", "/monitor/kill/1", auth.PermKill},
+		{auth.RoleViewer, "POST", "/monitor/systemctl/stop/sshd", auth.PermServices},
+		{auth.RoleViewer, "POST", "/monitor/run/exec/get_storages", auth.PermRun},
+		{auth.RoleViewer, "POST", "/monitor/run/cancel/some_id", auth.PermRun},
+		{auth.RoleViewer, "POST", "/monitor/toggle/cpu/false", auth.PermOperate},
+		{auth.RoleOperator, "POST", "/monitor/power/shutdown", auth.PermPower},
+		{auth.RoleOperator, "GET", "/monitor/terminal", auth.PermTerminal},
+		{auth.RoleOperator, "GET", "/monitor/users", auth.PermUsers},
+		{auth.RoleOperator, "DELETE", "/monitor/users/username", auth.PermUsers},
+		{"", "GET", "/monitor/internal", auth.PermView},
+	} {
+		bypassGetUserRole(tc.role)
+
+		body, status, err := reqWithBody(tc.method, fmt.Sprintf("http://127.0.0.1:%d%s", port, tc.path), nil)
+		a.Equal(nil, err)
+		a.Equal(http.StatusForbidden, status, tc.path)
+		a.JSONEq(`{"error":"forbidden","permission":"`+tc.permission+`"}`, string(body), tc.path)
+	}
+}
+
+func (a WebHandlersSuite) TestGetUserRoleFromAuthDB() {
+	user := "username"
+	authdb := newTestAuthDB(a.T(), user, "password")
+	defer func() { _ = os.Remove(authdb) }()
+
+	getUserRole = defaultGetUserRole
+	a.Equal(auth.RoleAdmin, getUserRole(h, user))
+
+	a.Equal(nil, auth.CreateUser(authdb, "admin", "password", auth.RoleAdmin))
+	a.Equal(nil, auth.SetUserRole(authdb, user, auth.RoleOperator))
+	a.Equal(auth.RoleOperator, getUserRole(h, user))
+	a.Equal("", getUserRole(h, "not_exists"))
+
+	a.Equal(nil, auth.SetUserDisabled(authdb, user, true))
+	a.Equal("", getUserRole(h, user))
+}
+
+func (a WebHandlersSuite) TestUsersOk() {
+	user := "username"
+	authdb := newTestAuthDB(a.T(), user, "password")
+	defer func() { _ = os.Remove(authdb) }()
+
+	oldGetUsernameFunc := bypassGetUsername(user)
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	usersURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), config.GetString(s, "on_start.routes.users"))
+	userURL := func(name string) string {
+		return fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"),
+			strings.Replace(config.GetString(s, "on_start.routes.user"), "{username}", name, 1))
+	}
+
+	body, status, err := reqWithBody("POST", usersURL, strings.NewReader(`{"username":"bob","password":"secret123","role":"operator"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+	a.JSONEq(`{"status":"ok","username":"bob"}`, string(body))
+
+	body, status, err = reqWithBody("POST", usersURL, strings.NewReader(`{"username":"bob","password":"secret123"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusConflict, status)
+	a.JSONEq(`{"error":"user exists already: bob"}`, string(body))
+
+	body, status, err = reqWithBody("POST", usersURL, strings.NewReader(`{"username":"eve","password":"short"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusBadRequest, status)
+	a.Contains(string(body), "at least 8 characters")
+
+	body, status, err = reqWithBody("PATCH", userURL("bob"), strings.NewReader(`{"role":"viewer","disabled":true,"password":"changed123"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status, string(body))
+	a.False(auth.Authenticate(authdb, "bob", "changed123"))
+
+	body, status, err = reqWithBody("GET", usersURL, nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+	a.JSONEq(`{
+		"users": [
+			{"username":"bob","role":"viewer","disabled":true,"totp":false,"locked":false},
+			{"username":"username","role":"admin","disabled":false,"totp":false,"locked":false}
+		],
+		"roles": ["viewer","operator","admin"]
+	}`, string(body))
+
+	_, status, err = reqWithBody("PATCH", userURL(user), strings.NewReader(`{"role":"viewer"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusConflict, status)
+
+	_, status, err = reqWithBody("PATCH", userURL("bob"), strings.NewReader(`{"role":"root"}`))
+	a.Equal(nil, err)
+	a.Equal(http.StatusBadRequest, status)
+
+	_, status, err = reqWithBody("DELETE", userURL("bob"), nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+
+	_, status, err = reqWithBody("DELETE", userURL("bob"), nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusNotFound, status)
+
+	_, status, err = reqWithBody("DELETE", userURL(user), nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusConflict, status)
+}
+
+func (a WebHandlersSuite) TestUsersNotAuthenticated() {
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	usersURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), config.GetString(s, "on_start.routes.users"))
+	resp, err := req("GET", usersURL, nil)
+	a.Equal(nil, err)
+	a.Equal(200, resp.StatusCode)
+}
+
 func (a WebHandlersSuite) TestSystemCtlNotAuthenticated() {
 	systemctlURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/systemctl/start/myservice")
 	resp, err := req("POST", systemctlURL, nil)
@@ -242,7 +758,38 @@ func (a WebHandlersSuite) TestSystemCtlActionNotAllowed() {
 	systemctlURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/systemctl/bad_action/myservice")
 	resp, err = req("POST", systemctlURL, strings.NewReader(form.Encode()))
 	a.Equal(nil, err)
-	a.Equal(200, resp.StatusCode)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (a WebHandlersSuite) TestSystemCtlInvalidService() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	oldGetUsernameFunc := bypassGetUsername(user)
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	oldSystemCtlCmd := h.Cfg.Data.Get("on_runtime.commands.systemctl")
+	h.Cfg.Data.Set("on_runtime.commands.systemctl", []string{"echo", "{action}", "{service}"})
+	defer func() { h.Cfg.Data.Set("on_runtime.commands.systemctl", oldSystemCtlCmd) }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+	body, status, err := reqWithBody("POST", baseURL+"/monitor/systemctl/restart/getty@tty1.service", nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, status)
+	a.Equal("restart getty@tty1.service\n", string(body))
+
+	// The service names with the characters of the shell are rejected, chi decodes %3B to ';'.
+	for _, service := range []string{"sshd%3Bid", "sshd%20-H%20host", "%24(id)"} {
+		resp, err := req("POST", baseURL+"/monitor/systemctl/restart/"+service, nil)
+		a.Equal(nil, err)
+		a.Equal(http.StatusBadRequest, resp.StatusCode, service)
+	}
 }
 
 func (a WebHandlersSuite) TestPowerOk() {
@@ -317,6 +864,261 @@ func (a WebHandlersSuite) TestKillOk() {
 	a.Equal(200, resp.StatusCode)
 }
 
+func (a WebHandlersSuite) TestKillInvalidPid() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	oldGetUsernameFunc := bypassGetUsername(user)
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	// Only a positive number can be a PID, chi decodes %3B to ';'.
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+	for _, pid := range []string{"1%3Bid", "-1", "0", "1%20-9", "abc"} {
+		resp, err := req("POST", baseURL+"/monitor/kill/"+pid, nil)
+		a.Equal(nil, err)
+		a.Equal(http.StatusBadRequest, resp.StatusCode, pid)
+	}
+
+	entries, err := auth.ListAudit(authdb, auth.AuditFilter{Action: auth.AuditKill})
+	a.Equal(nil, err)
+	a.Equal(5, len(entries))
+}
+
+func (a WebHandlersSuite) TestAuditOk() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	client := newCookieClient()
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+
+	resp, _ := postForm(client, baseURL+config.GetString(s, "on_start.routes.index"), url.Values{"uname": {user}, "psw": {"wrong"}})
+	a.Equal(h.LoginRoute, resp.Request.URL.Path)
+	resp, _ = postForm(client, baseURL+config.GetString(s, "on_start.routes.index"), url.Values{"uname": {user}, "psw": {pass}})
+	a.Equal(h.InternalRoute, resp.Request.URL.Path)
+
+	resp, err := client.Post(baseURL+"/monitor/power/reboot", "", nil)
+	a.Equal(nil, err)
+	a.Equal(200, resp.StatusCode)
+
+	auditURL := baseURL + config.GetString(s, "on_start.routes.audit")
+	resp, err = client.Get(auditURL)
+	a.Equal(nil, err)
+	a.Equal(200, resp.StatusCode)
+
+	var list struct {
+		Entries []auth.AuditEntry `json:"entries"`
+	}
+	a.Equal(nil, json.NewDecoder(resp.Body).Decode(&list))
+	a.Equal(3, len(list.Entries))
+	a.Equal(auth.AuditPower, list.Entries[0].Action)
+	a.Equal("reboot", list.Entries[0].Params)
+	a.Equal(user, list.Entries[0].User)
+	a.Equal("127.0.0.1", list.Entries[0].IP)
+	a.Equal(auth.AuditLogin, list.Entries[1].Action)
+	a.Equal(auth.AuditLoginFailed, list.Entries[2].Action)
+
+	resp, err = client.Get(auditURL + "?action=login_failed&format=csv")
+	a.Equal(nil, err)
+	a.Equal(200, resp.StatusCode)
+	a.Equal("text/csv", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	a.Equal(2, len(lines))
+	a.Equal("id,time,user,ip,action,params,result", lines[0])
+	a.Contains(lines[1], ",username,127.0.0.1,login_failed,password,rejected")
+
+	// The downloads are not limited by the default limit, only by the given one.
+	for i := 0; i < 3; i++ {
+		a.Equal(nil, auth.AddAudit(authdb, auth.AuditEntry{User: user, Action: auth.AuditPower, Params: "reboot"}))
+	}
+	a.Equal(nil, auth.AddAudit(authdb, auth.AuditEntry{User: user, Action: auth.AuditLogout}))
+	oldLimit := auditDefaultLimit
+	auditDefaultLimit = 2
+	defer func() { auditDefaultLimit = oldLimit }()
+	count := func(query string) int {
+		resp, err := client.Get(auditURL + query)
+		a.Require().NoError(err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if strings.Contains(query, "format=csv") {
+			return len(strings.Split(strings.TrimSpace(string(body)), "\n")) - 1 // Without the header.
+		}
+		var entries []auth.AuditEntry
+		a.Equal(nil, json.Unmarshal(body, &entries))
+		return len(entries)
+	}
+	resp, err = client.Get(auditURL)
+	a.Equal(nil, err)
+	a.Equal(nil, json.NewDecoder(resp.Body).Decode(&list))
+	a.Equal(2, len(list.Entries))
+	a.Equal(7, count("?format=json"))
+	a.Equal(7, count("?format=csv"))
+	a.Equal(3, count("?format=csv&limit=3"))
+
+	resp, err = client.Get(auditURL + "?since=yesterday")
+	a.Equal(nil, err)
+	a.Equal(400, resp.StatusCode)
+
+	bypassGetUserRole(auth.RoleOperator)
+	resp, err = client.Get(auditURL)
+	a.Equal(nil, err)
+	a.Equal(403, resp.StatusCode)
+}
+
+func (a WebHandlersSuite) TestAPITokens() {
+	user := "username"
+	pass := "password"
+
+	authdb := newTestAuthDB(a.T(), user, pass)
+	defer func() { _ = os.Remove(authdb) }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	client := newCookieClient()
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+	tokensURL := baseURL + config.GetString(s, "on_start.routes.tokens")
+	settingsURL := baseURL + config.GetString(s, "on_start.routes.settings")
+
+	resp, _ := postForm(client, baseURL+config.GetString(s, "on_start.routes.index"), url.Values{"uname": {user}, "psw": {pass}})
+	a.Equal(h.InternalRoute, resp.Request.URL.Path)
+
+	createToken := func(scope string) string {
+		resp, err := client.Post(tokensURL, "application/json", strings.NewReader(`{"name":"script","scope":"`+scope+`","expires_days":1}`))
+		a.Require().NoError(err)
+		defer resp.Body.Close()
+		a.Equal(200, resp.StatusCode)
+
+		var created struct {
+			Token string `json:"token"`
+		}
+		a.Equal(nil, json.NewDecoder(resp.Body).Decode(&created))
+		return created.Token
+	}
+
+	bearer := func(method, url, token string) int {
+		request, _ := http.NewRequest(method, url, strings.NewReader(`{"key":"skin","value":"dark"}`))
+		request.Header.Set("Authorization", "Bearer "+token)
+		resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}).Do(request)
+		a.Require().NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	read := createToken(auth.TokenScopeRead)
+	actions := createToken(auth.TokenScopeActions)
+
+	// The read-only tokens can only view. The tokens need no CSRF token.
+	checkCSRF = defaultCheckCSRF
+	a.Equal(http.StatusOK, bearer("GET", settingsURL, read))
+	a.Equal(http.StatusForbidden, bearer("POST", settingsURL, read))
+	a.Equal(http.StatusForbidden, bearer("POST", baseURL+"/monitor/power/reboot", read))
+
+	a.Equal(http.StatusOK, bearer("POST", settingsURL, actions))
+	a.Equal(http.StatusOK, bearer("POST", baseURL+"/monitor/power/reboot", actions))
+
+	// The tokens can not manage the tokens, and the invalid tokens are redirected to the login page.
+	a.Equal(http.StatusForbidden, bearer("GET", tokensURL, actions))
+	a.Equal(http.StatusFound, bearer("GET", settingsURL, "mon_invalid"))
+
+	resp, err := client.Get(tokensURL)
+	a.Equal(nil, err)
+	var list struct {
+		Tokens []auth.APIToken `json:"tokens"`
+	}
+	a.Equal(nil, json.NewDecoder(resp.Body).Decode(&list))
+	a.Equal(2, len(list.Tokens))
+
+	entries, err := auth.ListAudit(authdb, auth.AuditFilter{Action: auth.AuditToken})
+	a.Equal(nil, err)
+	a.Equal(2, len(entries))
+}
+
+func (a WebHandlersSuite) TestOIDCLogin() {
+	authdb := newTestAuthDB(a.T(), "username", "password")
+	defer func() { _ = os.Remove(authdb) }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	mock := oidctest.NewProvider("monitor", "secret")
+	defer mock.Close()
+	mock.Claims = map[string]interface{}{"email": "alice@example.net", "groups": []string{"ops"}}
+
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", config.GetInt(s, "on_start.port"))
+	loginURL := baseURL + config.GetString(s, "on_start.routes.oidc_login")
+
+	// The single sign-on is disabled by default.
+	resp, err := newCookieClient().Get(loginURL)
+	a.Equal(nil, err)
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	h.OIDC = auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:        mock.Issuer(),
+		ClientID:      "monitor",
+		ClientSecret:  "secret",
+		RedirectURL:   baseURL + config.GetString(s, "on_start.routes.oidc_callback"),
+		Scopes:        []string{"openid", "email", "groups"},
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+		RoleGroups:    map[string][]string{auth.RoleOperator: {"ops"}},
+	})
+	defer func() { h.OIDC = nil }()
+
+	resp, err = http.Get(baseURL + config.GetString(s, "on_start.routes.login"))
+	a.Equal(nil, err)
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	a.Contains(string(page), config.GetString(s, "on_start.oidc.button_text"))
+
+	// The provider redirects back to the callback, which logs in the mapped user.
+	client := newCookieClient()
+	resp, err = client.Get(loginURL)
+	a.Equal(nil, err)
+	a.Equal(h.InternalRoute, resp.Request.URL.Path)
+	a.Equal("alice@example.net", sessionUser(client, baseURL+config.GetString(s, "on_start.routes.index")))
+
+	role, err := auth.GetUserRole(authdb, "alice@example.net")
+	a.Equal(nil, err)
+	a.Equal(auth.RoleOperator, role)
+
+	entries, err := auth.ListAudit(authdb, auth.AuditFilter{Action: auth.AuditLogin})
+	a.Equal(nil, err)
+	a.Equal(1, len(entries))
+
+	// A forged callback without the state of the login is rejected.
+	client = newCookieClient()
+	resp, err = client.Get(baseURL + config.GetString(s, "on_start.routes.oidc_callback") + "?code=code&state=state")
+	a.Equal(nil, err)
+	a.Equal(h.LoginRoute, resp.Request.URL.Path)
+	a.Equal(loginErrorSSO, resp.Request.URL.Query().Get("error"))
+	a.Equal("", sessionUser(client, baseURL+config.GetString(s, "on_start.routes.index")))
+
+	// The disabled users can not log in.
+	a.Equal(nil, auth.SetUserDisabled(authdb, "alice@example.net", true))
+	client = newCookieClient()
+	resp, err = client.Get(loginURL)
+	a.Equal(nil, err)
+	a.Equal(h.LoginRoute, resp.Request.URL.Path)
+	a.Equal(loginErrorSSO, resp.Request.URL.Query().Get("error"))
+
+	entries, err = auth.ListAudit(authdb, auth.AuditFilter{Action: auth.AuditLoginFailed})
+	a.Equal(nil, err)
+	a.Equal(2, len(entries))
+}
+
 func (a WebHandlersSuite) TestKillNotAuthenticated() {
 	killURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/kill/999999999999")
 	resp, err := req("POST", killURL, nil)
@@ -355,6 +1157,81 @@ func (a WebHandlersSuite) TestApiOk() {
 	a.Equal(200, resp.StatusCode)
 }
 
+func (a WebHandlersSuite) TestApiUnsigned() {
+	go startApiServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	// Only the signed requests of the web service reach the API service.
+	apiURL := fmt.Sprintf("%s:%d/cpu", config.GetString(s, "on_runtime.api.url"), config.GetInt(s, "on_runtime.api.port"))
+	resp, err := http.Get(apiURL)
+	a.Equal(nil, err)
+	a.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = h.apiClient().Get(apiURL)
+	a.Equal(nil, err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func (a WebHandlersSuite) TestApiProxy() {
+	oldGetUsernameFunc := bypassGetUsername("username")
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	api := chi.NewRouter()
+	api.Get("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		// Only the signature headers of the web service are passed, not the cookies of the browser.
+		a.Empty(r.Header.Get("Cookie"))
+		a.NotEmpty(r.Header.Get(apiauth.HeaderSignature))
+		a.Equal("x=1", r.URL.RawQuery)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, "short and stout")
+	})
+	api.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(3 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	apiServer := httptest.NewServer(api)
+	defer apiServer.Close()
+
+	apiURL, err := url.Parse(apiServer.URL)
+	a.Require().NoError(err)
+	apiPort, err := strconv.Atoi(apiURL.Port())
+	a.Require().NoError(err)
+
+	oldURL, oldPort := config.GetString(h.Cfg, "on_runtime.api.url"), config.GetInt(h.Cfg, "on_runtime.api.port")
+	h.Cfg.Data.Set("on_runtime.api.url", "http://127.0.0.1")
+	h.Cfg.Data.Set("on_runtime.api.port", apiPort)
+	h.Cfg.Data.Set("on_runtime.api.timeout_seconds", 1)
+	defer func() {
+		h.Cfg.Data.Set("on_runtime.api.url", oldURL)
+		h.Cfg.Data.Set("on_runtime.api.port", oldPort)
+		h.Cfg.Data.Set("on_runtime.api.timeout_seconds", 10)
+	}()
+
+	router := chi.NewRouter()
+	router.Get("/api/{statistics}", h.Api)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	request, err := http.NewRequest("GET", server.URL+"/api/teapot?x=1", nil)
+	a.Require().NoError(err)
+	request.AddCookie(&http.Cookie{Name: "session", Value: "secret"})
+	resp, err := http.DefaultClient.Do(request)
+	a.Require().NoError(err)
+	body, _ := io.ReadAll(resp.Body)
+	a.Equal(http.StatusTeapot, resp.StatusCode)
+	a.Equal("text/plain", resp.Header.Get("Content-Type"))
+	a.Equal("short and stout", string(body))
+
+	// The API must respond within the timeout.
+	body, status, err := reqWithBody("GET", server.URL+"/api/slow", nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusGatewayTimeout, status)
+	a.Contains(string(body), `"error"`)
+}
+
 func (a WebHandlersSuite) TestApiApiNotFound() {
 	user := "username"
 	pass := "password"
@@ -380,7 +1257,9 @@ func (a WebHandlersSuite) TestApiApiNotFound() {
 	apiURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/api/cpu")
 	resp, err = req("GET", apiURL, strings.NewReader(form.Encode()))
 	a.Equal(nil, err)
-	a.Equal(200, resp.StatusCode)
+	// The API service is not running.
+	a.Equal(http.StatusBadGateway, resp.StatusCode)
+	a.Equal("application/json", resp.Header.Get("Content-Type"))
 }
 
 func (a WebHandlersSuite) TestApiNotAuthenticated() {
@@ -400,6 +1279,10 @@ func (a WebHandlersSuite) TestRunOk() {
 	oldGetUsernameFunc := bypassGetUsername(user)
 	defer func() { getUsername = oldGetUsernameFunc }()
 
+	oldCmdFolder := run.CmdFolder
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
+
 	go startWebServer(a.T())
 	time.Sleep(100 * time.Millisecond)
 
@@ -416,11 +1299,207 @@ func (a WebHandlersSuite) TestRunOk() {
 	a.Equal(200, resp.StatusCode)
 
 	runURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/run/exec/get_storages")
-	resp, err = req("GET", runURL, strings.NewReader(form.Encode()))
+	resp, err = req("POST", runURL, strings.NewReader(form.Encode()))
 	a.Equal(nil, err)
 	a.Equal(200, resp.StatusCode)
 }
 
+func (a WebHandlersSuite) TestAllInOne() {
+	oldGetUsernameFunc := bypassGetUsername("username")
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	oldCmdFolder := run.CmdFolder
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
+
+	go startApiServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	// The API routes are served in the same process, without the API service and its signatures.
+	apiRouter := chi.NewRouter()
+	apiroutes.Register(apiRouter, getConfig("api", "linux"))
+	inProcess := *h
+	inProcess.ApiClient, inProcess.ApiRouter = nil, apiRouter
+
+	router := chi.NewRouter()
+	router.Get(config.GetString(s, "on_start.routes.api"), inProcess.Api)
+	router.Post(config.GetString(s, "on_start.routes.toggle"), inProcess.Toggle)
+	router.Get(config.GetString(s, "on_start.routes.run_job"), inProcess.Run)
+	router.Get(config.GetString(s, "on_start.routes.run_stream"), inProcess.RunStream)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	body, status, err := reqWithBody("GET", server.URL+"/monitor/api/model", nil)
+	a.Equal(nil, err)
+	a.Equal(200, status)
+	a.Contains(string(body), "model")
+
+	body, status, err = reqWithBody("POST", server.URL+"/monitor/toggle/Storage/true", nil)
+	a.Equal(nil, err)
+	a.Equal(200, status)
+
+	body, status, err = reqWithBody("GET", server.URL+"/monitor/run/not_exists", nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusNotFound, status)
+	a.Contains(string(body), "does not exist")
+
+	// The output is streamed, and the trailers of the final state are passed.
+	job, err := run.Run(&run.Entry{Name: "in_process", Command: `echo started; sleep 0.2; echo done; exit 3`})
+	a.Equal(nil, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http")+"/monitor/run/stream/"+job.ID, nil)
+	a.Require().NoError(err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	output, final := "", ""
+	for final == "" {
+		typ, data, err := conn.Read(ctx)
+		if err != nil {
+			break
+		}
+		if typ == websocket.MessageBinary {
+			output += string(data)
+		} else {
+			final = string(data)
+		}
+	}
+	a.Equal("started\ndone\n", output)
+	a.Equal(`{"type":"done","status":"failed","exit_code":3}`, final)
+}
+
+func (a WebHandlersSuite) TestRunJobNotFound() {
+	user := "username"
+
+	oldGetUsernameFunc := bypassGetUsername(user)
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	go startApiServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	runURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/run/not_exists")
+	body, status, err := reqWithBody("GET", runURL, nil)
+	a.Equal(nil, err)
+	a.Equal(http.StatusNotFound, status)
+	a.Contains(string(body), "does not exist")
+}
+
+func (a WebHandlersSuite) TestRunStreamOk() {
+	oldGetUsernameFunc := bypassGetUsername("username")
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	oldCmdFolder := run.CmdFolder
+	run.CmdFolder = a.T().TempDir() + "/"
+	defer func() { run.CmdFolder = oldCmdFolder }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	go startApiServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	job, err := run.Run(&run.Entry{Name: "streamed", Command: `printf '\033[32mgreen\033[0m\n'; sleep 0.3; echo done`})
+	a.Equal(nil, err)
+
+	streamURL := strings.Replace(config.GetString(s, "on_start.routes.run_stream"), "{id}", job.ID, 1)
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), streamURL)
+	conn, _, err := websocket.Dial(context.Background(), wsURL, nil)
+	a.Equal(nil, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	output := ""
+	final := ""
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for final == "" {
+		typ, data, err := conn.Read(ctx)
+		if err != nil {
+			break
+		}
+		if typ == websocket.MessageBinary {
+			output += string(data)
+		} else {
+			final = string(data)
+		}
+	}
+
+	a.Equal("\033[32mgreen\033[0m\ndone\n", output)
+	a.Equal(`{"type":"done","status":"finished","exit_code":0}`, final)
+}
+
+func (a WebHandlersSuite) TestRunStreamSocket() {
+	api := chi.NewRouter()
+	api.Get("/run/stream/{id}", func(w http.ResponseWriter, r *http.Request) {
+		a.NotEmpty(r.Header.Get(apiauth.HeaderSignature))
+		w.Header().Set("Trailer", "X-Run-Status, X-Run-Exit-Code")
+		fmt.Fprint(w, "streamed over the socket\n")
+		w.Header().Set("X-Run-Status", "finished")
+		w.Header().Set("X-Run-Exit-Code", "0")
+	})
+	socket := filepath.Join(a.T().TempDir(), "api.sock")
+	listener, err := net.Listen("unix", socket)
+	a.Require().NoError(err)
+	apiServer := &http.Server{Handler: api}
+	go func() { _ = apiServer.Serve(listener) }()
+	defer apiServer.Close()
+
+	// The API is reached through the socket, the host of the URL is not dialed.
+	oldURL, oldApiClient := config.GetString(h.Cfg, "on_runtime.api.url"), h.ApiClient
+	h.Cfg.Data.Set("on_runtime.api.url", "http://api.invalid")
+	h.ApiClient = apiauth.NewClient(testAPISecret, nil, socket)
+	defer func() {
+		h.Cfg.Data.Set("on_runtime.api.url", oldURL)
+		h.ApiClient = oldApiClient
+	}()
+
+	router := chi.NewRouter()
+	router.Get("/run/stream/{id}", h.RunStream)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http")+"/run/stream/some_id", nil)
+	a.Require().NoError(err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	typ, data, err := conn.Read(ctx)
+	a.Equal(nil, err)
+	a.Equal(websocket.MessageBinary, typ)
+	a.Equal("streamed over the socket\n", string(data))
+
+	_, data, err = conn.Read(ctx)
+	a.Equal(nil, err)
+	a.Equal(`{"type":"done","status":"finished","exit_code":0}`, string(data))
+}
+
+func (a WebHandlersSuite) TestRunStreamJobNotFound() {
+	oldGetUsernameFunc := bypassGetUsername("username")
+	defer func() { getUsername = oldGetUsernameFunc }()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	go startApiServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	streamURL := strings.Replace(config.GetString(s, "on_start.routes.run_stream"), "{id}", "not_exists", 1)
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), streamURL)
+	conn, _, err := websocket.Dial(context.Background(), wsURL, nil)
+	a.Equal(nil, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	typ, data, err := conn.Read(context.Background())
+	a.Equal(nil, err)
+	a.Equal(websocket.MessageText, typ)
+	a.Contains(string(data), `"type":"error"`)
+	a.Contains(string(data), "does not exist")
+}
+
 func (a WebHandlersSuite) TestRunApiNotFound() {
 	user := "username"
 	pass := "password"
@@ -444,14 +1523,16 @@ func (a WebHandlersSuite) TestRunApiNotFound() {
 	a.Equal(200, resp.StatusCode)
 
 	runURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/run/exec/get_storages")
-	resp, err = req("GET", runURL, strings.NewReader(form.Encode()))
+	resp, err = req("POST", runURL, strings.NewReader(form.Encode()))
 	a.Equal(nil, err)
-	a.Equal(200, resp.StatusCode)
+	// The API service is not running.
+	a.Equal(http.StatusBadGateway, resp.StatusCode)
+	a.Equal("application/json", resp.Header.Get("Content-Type"))
 }
 
 func (a WebHandlersSuite) TestRunNotAuthenticated() {
 	runURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/run/exec/get_storages")
-	resp, err := req("GET", runURL, nil)
+	resp, err := req("POST", runURL, nil)
 	a.Equal(nil, err)
 	a.Equal(200, resp.StatusCode)
 }
@@ -492,14 +1573,20 @@ func (a WebHandlersSuite) TestToggleOk() {
 	a.Equal(200, resp.StatusCode)
 
 	toggleURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/toggle/Memory/true")
-	resp, err = req("GET", toggleURL, strings.NewReader(form.Encode()))
+	resp, err = req("POST", toggleURL, strings.NewReader(form.Encode()))
 	a.Equal(nil, err)
 	a.Equal(200, resp.StatusCode)
+
+	entries, err := auth.ListAudit(authdb, auth.AuditFilter{Action: auth.AuditToggle})
+	a.Equal(nil, err)
+	a.Equal(1, len(entries))
+	a.Equal("Memory true", entries[0].Params)
+	a.Equal(user, entries[0].User)
 }
 
 func (a WebHandlersSuite) TestToggleNotAuthenticated() {
 	toggleURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/toggle/Memory/true")
-	resp, err := req("GET", toggleURL, nil)
+	resp, err := req("POST", toggleURL, nil)
 	a.Equal(nil, err)
 	a.Equal(200, resp.StatusCode)
 }
@@ -527,9 +1614,11 @@ func (a WebHandlersSuite) TestToggleApiNotFound() {
 	a.Equal(200, resp.StatusCode)
 
 	toggleURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), "/monitor/toggle/Memory/true")
-	resp, err = req("GET", toggleURL, strings.NewReader(form.Encode()))
+	resp, err = req("POST", toggleURL, strings.NewReader(form.Encode()))
 	a.Equal(nil, err)
-	a.Equal(200, resp.StatusCode)
+	// The API service is not running.
+	a.Equal(http.StatusBadGateway, resp.StatusCode)
+	a.Equal("application/json", resp.Header.Get("Content-Type"))
 }
 
 func (a WebHandlersSuite) TestSettingsGETNotAuthenticated() {
@@ -763,6 +1852,104 @@ func (a WebHandlersSuite) TestIPisAllowedNotAllowedIP() {
 	a.Equal(false, allowed)
 }
 
+func (a WebHandlersSuite) TestIPisAllowedCIDRAndIPv6() {
+	for _, tc := range []struct {
+		requestIP, allowedIP string
+		allowed              bool
+	}{
+		{"192.168.1.20:1234", "192.168.1.0/24", true},
+		{"192.168.2.20:1234", "192.168.1.0/24", false},
+		{"[::1]:1234", "::1", true},
+		{"[fd00::1:2]:1234", "10.0.0.0/8, fd00::/64", true},
+		{"[fd00:0:0:1::2]:1234", "10.0.0.0/8, fd00::/64", false},
+		{"::ffff:10.1.2.3", "10.0.0.0/8", true},
+		{"10.1.2.3", "not-an-ip,10.1.2.3", true},
+		{"10.1.2.3", "", true},
+	} {
+		a.Equal(tc.allowed, IPisAllowed(tc.requestIP, tc.allowedIP, h), tc)
+	}
+}
+
+func (a WebHandlersSuite) TestIPisDenied() {
+	a.Equal(false, IPisDenied("10.1.2.3:1234", "", h))
+	a.Equal(true, IPisDenied("10.1.2.3:1234", "192.168.0.1,10.1.0.0/16", h))
+	a.Equal(false, IPisDenied("[2001:db8::1]:1234", "10.1.0.0/16", h))
+	a.Equal(true, IPisDenied("[2001:db8::1]:1234", "2001:db8::/32", h))
+}
+
+func (a WebHandlersSuite) TestClientIP() {
+	oldTrustedProxies := h.Cfg.Data.Get("on_runtime.trusted_proxies")
+	defer func() { h.Cfg.Data.Set("on_runtime.trusted_proxies", oldTrustedProxies) }()
+
+	request := func(remoteAddr string, headers map[string]string) *http.Request {
+		r := httptest.NewRequest("GET", "/monitor", nil)
+		r.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	// Without trusted proxies the headers are ignored.
+	h.Cfg.Data.Set("on_runtime.trusted_proxies", "")
+	a.Equal("127.0.0.1", h.clientIP(request("127.0.0.1:1234", map[string]string{"X-Real-IP": "10.0.0.1"})))
+
+	h.Cfg.Data.Set("on_runtime.trusted_proxies", "127.0.0.1,::1,172.16.0.0/12")
+	a.Equal("10.0.0.1", h.clientIP(request("127.0.0.1:1234", map[string]string{"X-Real-IP": "10.0.0.1"})))
+	a.Equal("2001:db8::1", h.clientIP(request("[::1]:1234", map[string]string{"X-Forwarded-For": "2001:db8::1"})))
+
+	// The forged addresses on the left of the real client are skipped, the trusted proxies on its right too.
+	a.Equal("10.0.0.1", h.clientIP(request("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 10.0.0.1, 172.16.0.5"})))
+	a.Equal("172.16.0.5", h.clientIP(request("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "172.16.0.5"})))
+	a.Equal("127.0.0.1", h.clientIP(request("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.1, garbage"})))
+
+	// The headers of an untrusted peer are ignored.
+	a.Equal("10.9.9.9", h.clientIP(request("10.9.9.9:1234", map[string]string{"X-Forwarded-For": "10.0.0.1"})))
+}
+
+func (a WebHandlersSuite) TestIPFilter() {
+	oldAllowedIP := h.Cfg.Data.Get("on_runtime.allowed_ip")
+	oldDeniedIP := h.Cfg.Data.Get("on_runtime.denied_ip")
+	oldTrustedProxies := h.Cfg.Data.Get("on_runtime.trusted_proxies")
+	defer func() {
+		h.Cfg.Data.Set("on_runtime.allowed_ip", oldAllowedIP)
+		h.Cfg.Data.Set("on_runtime.denied_ip", oldDeniedIP)
+		h.Cfg.Data.Set("on_runtime.trusted_proxies", oldTrustedProxies)
+	}()
+
+	go startWebServer(a.T())
+	time.Sleep(100 * time.Millisecond)
+
+	loginURL := fmt.Sprintf("http://127.0.0.1:%d%s", config.GetInt(s, "on_start.port"), config.GetString(s, "on_start.routes.login"))
+	status := func(forwardedFor string) int {
+		request, _ := http.NewRequest("GET", loginURL, nil)
+		if forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		a.Require().NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	h.Cfg.Data.Set("on_runtime.allowed_ip", "127.0.0.0/8")
+	h.Cfg.Data.Set("on_runtime.denied_ip", "")
+	h.Cfg.Data.Set("on_runtime.trusted_proxies", "")
+	a.Equal(http.StatusOK, status(""))
+
+	h.Cfg.Data.Set("on_runtime.denied_ip", "127.0.0.1")
+	a.Equal(http.StatusForbidden, status(""))
+
+	// Behind a trusted proxy the client is filtered, not the proxy.
+	h.Cfg.Data.Set("on_runtime.denied_ip", "10.0.0.66")
+	h.Cfg.Data.Set("on_runtime.allowed_ip", "10.0.0.0/8")
+	h.Cfg.Data.Set("on_runtime.trusted_proxies", "127.0.0.1")
+	a.Equal(http.StatusOK, status("10.0.0.1"))
+	a.Equal(http.StatusForbidden, status("10.0.0.66"))
+	a.Equal(http.StatusForbidden, status("192.168.0.1"))
+	a.Equal(http.StatusForbidden, status(""))
+}
+
 func startWebServer(t *testing.T) {
 	apiport, err := freeport.GetFreePort()
 	if err != nil {
@@ -777,19 +1964,41 @@ func startWebServer(t *testing.T) {
 	h.Cfg.Data.Set("on_start.port", webport)
 	h.Cfg.Data.Set("on_runtime.commands.init", []string{"bash", "-c", "echo reboot"})
 
+	r = chi.NewRouter()
+	r.Use(h.IPFilter)
+
 	r.HandleFunc(config.GetString(s, "on_start.routes.index"), h.Index)
 	r.Get(config.GetString(s, "on_start.routes.login"), h.Login)
 	r.Get(config.GetString(s, "on_start.routes.logout"), h.Logout)
-	r.Get(config.GetString(s, "on_start.routes.internal"), h.Internal)
-	r.Get(config.GetString(s, "on_start.routes.api"), h.Api)
-	r.Get(config.GetString(s, "on_start.routes.toggle"), h.Toggle)
-	r.Get(config.GetString(s, "on_start.routes.settings"), h.SettingsGET)
-	r.Post(config.GetString(s, "on_start.routes.settings"), h.SettingsPOST)
-	r.Post(config.GetString(s, "on_start.routes.systemctl"), h.SystemCtl)
-	r.Post(config.GetString(s, "on_start.routes.power"), h.Power)
-	r.Post(config.GetString(s, "on_start.routes.kill"), h.Kill)
-	r.Get(config.GetString(s, "on_start.routes.run"), h.Run)
-	r.Get(config.GetString(s, "on_start.routes.terminal"), h.Terminal)
+	r.Get(config.GetString(s, "on_start.routes.oidc_login"), h.OIDCLogin)
+	r.Get(config.GetString(s, "on_start.routes.oidc_callback"), h.OIDCCallback)
+	r.Get(config.GetString(s, "on_start.routes.internal"), h.Require(auth.PermView, h.Internal))
+	r.Get(config.GetString(s, "on_start.routes.api"), h.Require(auth.PermView, h.Api))
+	r.Post(config.GetString(s, "on_start.routes.toggle"), h.Require(auth.PermOperate, h.Toggle))
+	r.Get(config.GetString(s, "on_start.routes.settings"), h.Require(auth.PermView, h.SettingsGET))
+	r.Post(config.GetString(s, "on_start.routes.settings"), h.Require(auth.PermView, h.SettingsPOST))
+	r.Post(config.GetString(s, "on_start.routes.systemctl"), h.Require(auth.PermServices, h.SystemCtl))
+	r.Post(config.GetString(s, "on_start.routes.power"), h.Require(auth.PermPower, h.Power))
+	r.Post(config.GetString(s, "on_start.routes.kill"), h.Require(auth.PermKill, h.Kill))
+	r.Get(config.GetString(s, "on_start.routes.run"), h.Require(auth.PermView, h.Run))
+	r.Post(config.GetString(s, "on_start.routes.run"), h.Require(auth.PermView, h.Run))
+	r.Get(config.GetString(s, "on_start.routes.run_job"), h.Require(auth.PermView, h.Run))
+	r.Get(config.GetString(s, "on_start.routes.run_stream"), h.Require(auth.PermView, h.RunStream))
+	r.Get(config.GetString(s, "on_start.routes.terminal"), h.Require(auth.PermTerminal, h.Terminal))
+	r.Get(config.GetString(s, "on_start.routes.users"), h.Require(auth.PermUsers, h.UsersGET))
+	r.Post(config.GetString(s, "on_start.routes.users"), h.Require(auth.PermUsers, h.UsersPOST))
+	r.Patch(config.GetString(s, "on_start.routes.user"), h.Require(auth.PermUsers, h.UserPATCH))
+	r.Delete(config.GetString(s, "on_start.routes.user"), h.Require(auth.PermUsers, h.UserDELETE))
+	r.Post(config.GetString(s, "on_start.routes.user_unlock"), h.Require(auth.PermUsers, h.UserUnlock))
+	r.Get(config.GetString(s, "on_start.routes.totp"), h.Require(auth.PermView, h.TOTP))
+	r.Post(config.GetString(s, "on_start.routes.totp"), h.Require(auth.PermView, h.TOTP))
+	r.Get(config.GetString(s, "on_start.routes.sessions"), h.Require(auth.PermView, h.SessionsGET))
+	r.Delete(config.GetString(s, "on_start.routes.sessions"), h.Require(auth.PermView, h.SessionsDELETE))
+	r.Delete(config.GetString(s, "on_start.routes.session"), h.Require(auth.PermView, h.SessionDELETE))
+	r.Get(config.GetString(s, "on_start.routes.audit"), h.Require(auth.PermAudit, h.AuditGET))
+	r.Get(config.GetString(s, "on_start.routes.tokens"), h.Require(auth.PermView, h.TokensGET))
+	r.Post(config.GetString(s, "on_start.routes.tokens"), h.Require(auth.PermView, h.TokensPOST))
+	r.Delete(config.GetString(s, "on_start.routes.token"), h.Require(auth.PermView, h.TokenDELETE))
 
 	s := servers.Server{
 		Port:       config.GetInt(s, "on_start.port"),
@@ -825,6 +2034,7 @@ func startApiServer(t *testing.T) {
 	go network.Stats()
 
 	router := chi.NewRouter()
+	router.Use(apiauth.NewVerifier(testAPISecret).Middleware)
 
 	router.Get(config.GetString(s, "on_start.routes.all"), handlers.All)
 	router.Get(config.GetString(s, "on_start.routes.playground"), handlers.Playground)
@@ -839,8 +2049,12 @@ func startApiServer(t *testing.T) {
 	router.Get(config.GetString(s, "on_start.routes.run.list"), handlers.RunList)
 	router.Get(config.GetString(s, "on_start.routes.run.exec"), handlers.RunExec)
 	router.Get(config.GetString(s, "on_start.routes.run.stdout"), handlers.RunStdOut)
+	router.Get(config.GetString(s, "on_start.routes.run.history"), handlers.RunHistory)
+	router.Get(config.GetString(s, "on_start.routes.run.job"), handlers.RunJob)
+	router.Get(config.GetString(s, "on_start.routes.run.cancel"), handlers.RunCancel)
+	router.Get(config.GetString(s, "on_start.routes.run.stream"), handlers.RunStream)
 
-	apiservers.ServeHTTP(config.GetInt(s, "on_start.port"), router)
+	apiservers.ServeHTTP("127.0.0.1", config.GetInt(s, "on_start.port"), nil, router)
 }
 
 func req(method, url string, data io.Reader) (*http.Response, error) {
@@ -888,6 +2102,48 @@ func reqWithBody(method, url string, data io.Reader) ([]byte, int, error) {
 	return body, res.StatusCode, nil
 }
 
+func newCookieClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar}
+}
+
+func postForm(client *http.Client, url string, form url.Values) (*http.Response, string) {
+	resp, err := client.PostForm(url, form)
+	if err != nil {
+		return nil, err.Error()
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+// sessionUser returns the user name of the session cookie, which the client sends to the url.
+func sessionUser(client *http.Client, rawURL string) string {
+	u, _ := url.Parse(rawURL + "/")
+	r := &http.Request{Header: http.Header{}}
+	for _, c := range client.Jar.Cookies(u) {
+		r.AddCookie(c)
+	}
+	return auth.GetUserName(r)
+}
+
+func bypassGetUserRole(role string) func(h *Handler, userName string) string {
+	oldGetUserRoleFunc := getUserRole
+	getUserRole = func(h *Handler, userName string) string {
+		return role
+	}
+	return oldGetUserRoleFunc
+}
+
+func bypassCheckCSRF(valid bool) func(r *http.Request) bool {
+	oldCheckCSRFFunc := checkCSRF
+	checkCSRF = func(r *http.Request) bool {
+		return valid
+	}
+	return oldCheckCSRFFunc
+}
+
 func bypassGetUsername(username string) func(r *http.Request) string {
 	oldGetUsernameFunc := getUsername
 	getUsername = func(r *http.Request) string {
@@ -928,6 +2184,10 @@ func newTestAuthDB(t *testing.T, user, pass string) string {
 		t.Fatalf("INSERT: %v", err)
 	}
 
+	if err := auth.ConfigureSessions(authdbFullPath, 2*time.Hour, 30*24*time.Hour); err != nil {
+		t.Fatalf("auth.ConfigureSessions: %v", err)
+	}
+
 	return authdbFullPath
 }
 
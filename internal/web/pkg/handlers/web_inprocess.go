@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// apiRequest prepares a request for the in-process API router of the all-in-one mode, like the HTTP server would do.
+// The routing context of the web router is removed, otherwise the API router would continue its routing.
+func apiRequest(req *http.Request) *http.Request {
+	r := req.Clone(context.WithValue(req.Context(), chi.RouteCtxKey, nil))
+	r.RequestURI = r.URL.RequestURI()
+	return r
+}
+
+// inProcessTransport sends the requests to a handler in the same process, without an HTTP connection.
+// The response body is streamed, and the declared trailers are available at the end of the body.
+type inProcessTransport struct {
+	handler http.Handler
+}
+
+func (t inProcessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reader, writer := io.Pipe()
+	w := &pipeResponseWriter{
+		header:   http.Header{},
+		body:     writer,
+		response: &http.Response{Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Body: reader, Request: req},
+		ready:    make(chan struct{}),
+	}
+
+	r := apiRequest(req)
+	if r.RemoteAddr == "" {
+		r.RemoteAddr = "in-process"
+	}
+	go func() {
+		t.handler.ServeHTTP(w, r)
+		w.finish()
+	}()
+
+	select {
+	case <-w.ready:
+		return w.response, nil
+	case <-req.Context().Done():
+		_ = reader.CloseWithError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+}
+
+// pipeResponseWriter passes the response of a handler to inProcessTransport.
+type pipeResponseWriter struct {
+	header   http.Header
+	body     *io.PipeWriter
+	response *http.Response
+	ready    chan struct{}
+	written  bool
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	if w.written {
+		return
+	}
+	w.written = true
+
+	w.response.StatusCode = status
+	w.response.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	w.response.Header = w.header.Clone()
+	w.response.Header.Del("Trailer")
+	w.response.Trailer = http.Header{}
+	close(w.ready)
+}
+
+func (w *pipeResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// Flush sends the header, the written data is already passed to the reader.
+func (w *pipeResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// finish sets the declared trailers, and closes the body, after the handler returned.
+func (w *pipeResponseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	for _, declared := range w.header.Values("Trailer") {
+		for _, key := range strings.Split(declared, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			w.response.Trailer[key] = w.header.Values(key)
+		}
+	}
+	_ = w.body.Close()
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/takattila/monitor/internal/common/pkg/config"
+)
+
+// IPFilter is a middleware, which resolves the IP of the client, and rejects the requests
+// from the IPs, which are not allowed by 'on_runtime.allowed_ip' or denied by 'on_runtime.denied_ip'.
+// The resolved IP replaces the RemoteAddr of the request, so the handlers see the real client behind a proxy.
+func (h *Handler) IPFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = h.clientIP(r)
+
+		if IPisDenied(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.denied_ip"), h) ||
+			!IPisAllowed(r.RemoteAddr, config.GetString(h.Cfg, "on_runtime.allowed_ip"), h) {
+			h.L.Warning("IP rejected:", r.RemoteAddr, r.Method, r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IPisDenied checks whether the request IP is in the comma separated list of denied IPs and CIDR ranges.
+func IPisDenied(requestIP, deniedIP string, h *Handler) bool {
+	if strings.TrimSpace(deniedIP) == "" {
+		return false
+	}
+	if ipInList(hostOf(requestIP), deniedIP, h) {
+		h.L.Error(fmt.Errorf("IP denied: %s", requestIP))
+		return true
+	}
+	return false
+}
+
+// clientIP returns the IP of the client. The X-Forwarded-For and X-Real-IP headers are honoured only,
+// when the connection comes from one of the 'on_runtime.trusted_proxies', otherwise they could be forged.
+func (h *Handler) clientIP(r *http.Request) string {
+	peer := hostOf(r.RemoteAddr)
+	trusted := config.GetString(h.Cfg, "on_runtime.trusted_proxies")
+	if strings.TrimSpace(trusted) == "" || !ipInList(peer, trusted, h) {
+		return peer
+	}
+
+	// The proxies append the address of their client, so the first untrusted address
+	// from the right is the client. The addresses on its left can be forged by the client.
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		ips := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if net.ParseIP(ip) == nil {
+				h.L.Warning("invalid X-Forwarded-For:", forwarded, "proxy:", peer)
+				return peer
+			}
+			if i == 0 || !ipInList(ip, trusted, h) {
+				return ip
+			}
+		}
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return peer
+}
+
+// hostOf removes the port from an address, e.g. 127.0.0.1:1234 or [::1]:1234.
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.Trim(address, "[]")
+}
+
+// ipInList checks whether the IP matches any of the IPs or CIDR ranges of a comma separated list.
+func ipInList(ip, list string, h *Handler) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				h.L.Error(fmt.Errorf("invalid CIDR range: %s", entry))
+				continue
+			}
+			if network.Contains(parsed) {
+				return true
+			}
+			continue
+		}
+
+		if other := net.ParseIP(entry); other == nil {
+			h.L.Error(fmt.Errorf("invalid IP: %s", entry))
+		} else if other.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+// oidcEnabled checks whether the single sign-on provider is configured.
+func (h *Handler) oidcEnabled() bool {
+	return h.OIDC != nil
+}
+
+// OIDCLogin redirects the browser to the OpenID Connect provider.
+// The state, the nonce and the PKCE code verifier are kept in a short-lived cookie until the callback.
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	login, err := auth.NewOIDCLogin()
+	if err == nil {
+		err = auth.SetOIDCLogin(h.pendingPath(), login, w)
+	}
+
+	var target string
+	if err == nil {
+		target, err = h.OIDC.AuthCodeURL(login)
+	}
+	if err != nil {
+		h.L.Error(err)
+		http.Redirect(w, r, h.LoginRoute+"?error="+loginErrorSSO, 302)
+		return
+	}
+	http.Redirect(w, r, target, 302)
+}
+
+// OIDCCallback finishes the single sign-on login: it checks the state, redeems the code at the provider,
+// and maps the identity of the ID token to a local user. The second factor is asked, like after a password.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !h.oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	login := auth.GetOIDCLogin(r)
+	auth.ClearOIDCLogin(h.pendingPath(), w)
+
+	query := r.URL.Query()
+	if !login.ValidState(query.Get("state")) {
+		h.L.Warning("OIDC login: invalid state, ip:", remoteIP(r))
+		h.audit(r, "", auth.AuditLoginFailed, auth.BackendOIDC, "invalid state")
+		http.Redirect(w, r, h.LoginRoute+"?error="+loginErrorSSO, 302)
+		return
+	}
+	if e := query.Get("error"); e != "" {
+		h.L.Warning("OIDC login: provider error:", e, query.Get("error_description"))
+		h.audit(r, "", auth.AuditLoginFailed, auth.BackendOIDC, e)
+		http.Redirect(w, r, h.LoginRoute+"?error="+loginErrorSSO, 302)
+		return
+	}
+
+	identity, err := h.OIDC.Exchange(query.Get("code"), login)
+	if err != nil {
+		h.L.Warning("OIDC login:", err)
+		h.audit(r, "", auth.AuditLoginFailed, auth.BackendOIDC, err.Error())
+		http.Redirect(w, r, h.LoginRoute+"?error="+loginErrorSSO, 302)
+		return
+	}
+
+	userName, err := h.OIDC.MapUser(h.ProgramDir+h.AuthFile, identity)
+	if err != nil {
+		h.L.Warning("OIDC login:", err)
+		h.audit(r, identity.UserName, auth.AuditLoginFailed, auth.BackendOIDC, err.Error())
+		http.Redirect(w, r, h.LoginRoute+"?error="+loginErrorSSO, 302)
+		return
+	}
+
+	if h.needsSecondFactor(userName) {
+		auth.SetPendingLogin(h.pendingPath(), userName, w)
+		http.Redirect(w, r, h.LoginRoute, 302)
+		return
+	}
+
+	path := filepath.Join(config.GetString(h.Cfg, "on_start.routes.index")) + "/"
+	if err := auth.SetSession(path, userName, w, r); err != nil {
+		h.L.Error(err)
+		http.Redirect(w, r, h.LoginRoute, 302)
+		return
+	}
+	h.loginSucceeded(r, userName)
+	http.Redirect(w, r, h.InternalRoute, 302)
+}
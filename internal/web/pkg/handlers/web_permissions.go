@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+var getUserRole = func(h *Handler, userName string) string {
+	role, err := auth.GetUserRole(h.ProgramDir+h.AuthFile, userName)
+	h.L.Error(err)
+	return role
+}
+
+// runActionPermissions lists the run actions, which need more than the view permission.
+// They change state, so they are accepted only with POST.
+var runActionPermissions = map[string]string{
+	"exec":   auth.PermRun,
+	"cancel": auth.PermRun,
+}
+
+// Require allows the request only for users, whose role (and API token scope) grants the permission,
+// and the state-changing requests of the sessions only with their CSRF token.
+// The requests without a session (or with an invalid API token) are redirected to the login page,
+// so the handlers are called only for the logged in users.
+func (h *Handler) Require(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userName := getUsername(r)
+		h.L.Debug("userName:", userName)
+		if userName == "" {
+			http.Redirect(w, r, h.LoginRoute, http.StatusFound)
+			return
+		}
+
+		if h.allowed(w, r, userName, permission) && h.csrfValid(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// allowed checks the permission of the logged in user,
+// and writes a 403 JSON response, if it is not granted.
+func (h *Handler) allowed(w http.ResponseWriter, r *http.Request, userName, permission string) bool {
+	role := getUserRole(h, userName)
+	if auth.HasPermission(role, permission) && readOnlyAllowed(r, permission) {
+		return true
+	}
+
+	h.L.Warning("forbidden:", r.Method, r.URL.Path, "user:", userName, "role:", role, "permission:", permission)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	h.L.Error(json.NewEncoder(w).Encode(map[string]string{
+		"error":      "forbidden",
+		"permission": permission,
+	}))
+	return false
+}
+
+// readOnlyAllowed limits the requests of the read-only API tokens
+// to the view permission, and to the methods, which do not change state.
+func readOnlyAllowed(r *http.Request, permission string) bool {
+	if auth.TokenScope(r) != auth.TokenScopeRead {
+		return true
+	}
+	return permission == auth.PermView && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+}
+
+// permissionsOf returns the permissions of the user as a template friendly map,
+// and as a JSON list for the scripts.
+func (h *Handler) permissionsOf(userName string) (map[string]bool, string) {
+	permissions := auth.Permissions(getUserRole(h, userName))
+
+	can := map[string]bool{}
+	for _, p := range permissions {
+		can[p] = true
+	}
+
+	b, err := json.Marshal(permissions)
+	h.L.Error(err)
+
+	return can, string(b)
+}
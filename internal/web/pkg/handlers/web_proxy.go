@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/takattila/monitor/internal/common/pkg/config"
+)
+
+// maxCapturedBody is the length of the beginning of the API responses, which is kept for the audit log.
+const maxCapturedBody = 4096
+
+// defaultApiTimeout is used, when on_runtime.api.timeout_seconds is not set.
+const defaultApiTimeout = 10 * time.Second
+
+// proxyAPI forwards the request to the path of the MONITOR-API service, and streams back its response
+// with its status code and its headers. The API must start to respond within the timeout, the body may take longer.
+// The request is always sent with GET and without a body. If the API is not available,
+// a JSON error is returned: 502, or 504 after the timeout.
+// It returns the beginning of the response body, or the error, for the audit log.
+func (h *Handler) proxyAPI(w http.ResponseWriter, r *http.Request, path string) (string, error) {
+	target, err := h.apiURL(path)
+	if err != nil {
+		h.L.Error(err)
+		writeJSONError(w, http.StatusInternalServerError, "invalid API URL")
+		return "", err
+	}
+	target.RawQuery = r.URL.RawQuery
+
+	timeout := time.Duration(config.GetInt(h.Cfg, "on_runtime.api.timeout_seconds")) * time.Second
+	if timeout <= 0 {
+		timeout = defaultApiTimeout
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+
+	var captured bytes.Buffer
+	var proxyErr error
+	proxy := &httputil.ReverseProxy{
+		// Only GET requests are proxied: every route of the API is a GET route, which has no body,
+		// the state-changing web routes (e.g. POST /toggle) are mapped to them. The method and the body
+		// of the browser request, and its cookies and other headers, are not passed.
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.Method = http.MethodGet
+			pr.Out.URL = target
+			pr.Out.Host = ""
+			pr.Out.Header = http.Header{}
+			pr.Out.Body, pr.Out.ContentLength, pr.Out.GetBody = nil, 0, nil
+		},
+		Transport:     h.apiClient().Transport,
+		FlushInterval: -1,
+		ModifyResponse: func(res *http.Response) error {
+			if !timer.Stop() {
+				return context.DeadlineExceeded
+			}
+			h.L.Debug(target, "client: status code:", res.StatusCode)
+			res.Body = capturingBody{ReadCloser: res.Body, captured: &captured}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyErr = err
+			h.L.Error(fmt.Errorf("making http request: %v", err))
+			if timedOut.Load() || errors.Is(err, context.DeadlineExceeded) {
+				writeJSONError(w, http.StatusGatewayTimeout, "the API service did not respond in time")
+				return
+			}
+			writeJSONError(w, http.StatusBadGateway, "the API service is not available")
+		},
+	}
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+
+	return captured.String(), proxyErr
+}
+
+// apiURL returns the URL of a path of the MONITOR-API service. The requests must be sent with apiClient,
+// which dials the Unix domain socket, or the TCP port with the TLS config, and signs the requests.
+func (h *Handler) apiURL(path string) (*url.URL, error) {
+	return url.Parse(fmt.Sprintf("%s:%d/%s",
+		config.GetString(h.Cfg, "on_runtime.api.url"),
+		config.GetInt(h.Cfg, "on_runtime.api.port"),
+		path))
+}
+
+// capturingBody keeps the beginning of the body, while it is read.
+type capturingBody struct {
+	io.ReadCloser
+	captured *bytes.Buffer
+}
+
+func (b capturingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if rest := maxCapturedBody - b.captured.Len(); rest > 0 {
+		b.captured.Write(p[:min(n, rest)])
+	}
+	return n, err
+}
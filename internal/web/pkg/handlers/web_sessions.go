@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+// SessionsGET lists the sessions of the logged in user as JSON.
+func (h *Handler) SessionsGET(w http.ResponseWriter, r *http.Request) {
+	sessions, err := auth.ListSessions(h.ProgramDir+h.AuthFile, getUsername(r), r)
+	if err != nil {
+		h.L.Error(fmt.Errorf("ListSessions: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+	}))
+}
+
+// SessionsDELETE revokes every session of the logged in user, except the current one.
+func (h *Handler) SessionsDELETE(w http.ResponseWriter, r *http.Request) {
+	userName := getUsername(r)
+	n, err := auth.RevokeSessions(h.ProgramDir+h.AuthFile, userName, auth.SessionID(r))
+	if err != nil {
+		h.L.Error(fmt.Errorf("RevokeSessions: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	h.L.Info("sessions revoked:", n, "user:", userName)
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "ok",
+		"revoked": n,
+	}))
+}
+
+// SessionDELETE revokes a session of the logged in user.
+func (h *Handler) SessionDELETE(w http.ResponseWriter, r *http.Request) {
+	userName := getUsername(r)
+	id := chi.URLParam(r, "id")
+	if err := auth.RevokeSession(h.ProgramDir+h.AuthFile, userName, id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	h.L.Info("session revoked, user:", userName)
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(map[string]string{"status": "ok"}))
+}
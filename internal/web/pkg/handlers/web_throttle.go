@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+// loginPolicy reads the thresholds of the brute-force protection from the 'on_runtime.login_protection' settings.
+func (h *Handler) loginPolicy() auth.LoginPolicy {
+	return auth.LoginPolicy{
+		BackoffAfter:    config.GetInt(h.Cfg, "on_runtime.login_protection.backoff_after_failures"),
+		BackoffBase:     time.Duration(config.GetInt(h.Cfg, "on_runtime.login_protection.backoff_base_seconds")) * time.Second,
+		BackoffMax:      time.Duration(config.GetInt(h.Cfg, "on_runtime.login_protection.backoff_max_seconds")) * time.Second,
+		LockoutAfter:    config.GetInt(h.Cfg, "on_runtime.login_protection.lockout_after_failures"),
+		LockoutDuration: time.Duration(config.GetInt(h.Cfg, "on_runtime.login_protection.lockout_minutes")) * time.Minute,
+		ResetAfter:      time.Duration(config.GetInt(h.Cfg, "on_runtime.login_protection.reset_after_minutes")) * time.Minute,
+	}
+}
+
+// remoteIP returns the IP of the client without the port.
+func remoteIP(r *http.Request) string {
+	return hostOf(r.RemoteAddr)
+}
+
+// loginBlocked redirects to the login page, if the attempts from the IP or to the user must wait.
+func (h *Handler) loginBlocked(w http.ResponseWriter, r *http.Request, userName string) bool {
+	block, err := auth.LoginBlocked(h.ProgramDir+h.AuthFile, remoteIP(r), userName)
+	if err != nil {
+		h.L.Error(err)
+		return false
+	}
+	if block.Wait <= 0 {
+		return false
+	}
+
+	h.L.Warning("login blocked, user:", userName, "ip:", remoteIP(r), "wait:", block.Wait.Round(time.Second), "locked:", block.Locked)
+	h.audit(r, userName, auth.AuditLoginFailed, "blocked", loginErrorBlocked(block)+" "+block.Wait.Round(time.Second).String())
+	http.Redirect(w, r, h.LoginRoute+"?error="+loginErrorBlocked(block), 302)
+	return true
+}
+
+// loginFailed counts a failed password or second factor, and redirects to the login page.
+func (h *Handler) loginFailed(w http.ResponseWriter, r *http.Request, userName, loginError string) {
+	block, err := auth.RecordLoginFailure(h.ProgramDir+h.AuthFile, h.loginPolicy(), remoteIP(r), userName)
+	h.L.Error(err)
+
+	h.L.Warning("failed login, user:", userName, "ip:", remoteIP(r), "wait:", block.Wait, "locked:", block.Locked)
+	result := "rejected"
+	if block.Locked {
+		result = "rejected, account locked"
+	}
+	h.audit(r, userName, auth.AuditLoginFailed, loginError, result)
+
+	if block.Locked {
+		loginError = loginErrorLocked
+	}
+	http.Redirect(w, r, h.LoginRoute+"?error="+loginError, 302)
+}
+
+// loginSucceeded forgets the failures of the IP and of the user, and audits the login.
+func (h *Handler) loginSucceeded(r *http.Request, userName string) {
+	h.L.Error(auth.ResetLoginFailures(h.ProgramDir+h.AuthFile, remoteIP(r), userName))
+	h.audit(r, userName, auth.AuditLogin, "", "ok")
+}
+
+const (
+	loginErrorPassword = "password"
+	loginErrorCode     = "code"
+	loginErrorWait     = "wait"
+	loginErrorLocked   = "locked"
+	loginErrorSSO      = "sso"
+)
+
+func loginErrorBlocked(block auth.LoginBlock) string {
+	if block.Locked {
+		return loginErrorLocked
+	}
+	return loginErrorWait
+}
+
+var loginErrors = map[string]string{
+	loginErrorPassword: "Invalid username or password.",
+	loginErrorCode:     "Invalid code, please try again.",
+	loginErrorWait:     "Too many failed attempts, please wait before trying again.",
+	loginErrorLocked:   "The account is locked because of too many failed attempts. Try again later, or ask an admin to unlock it.",
+	loginErrorSSO:      "Single sign-on failed, please try again, or ask an admin for access.",
+}
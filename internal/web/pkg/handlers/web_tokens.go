@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+// tokenRequest is the body of the API token creation.
+type tokenRequest struct {
+	Name        string `json:"name"`
+	Scope       string `json:"scope"`
+	ExpiresDays int    `json:"expires_days"` // 0: the token does not expire.
+}
+
+// TokensGET lists the API tokens of the logged in user as JSON.
+func (h *Handler) TokensGET(w http.ResponseWriter, r *http.Request) {
+	if !h.tokensAccess(w, r) {
+		return
+	}
+
+	tokens, err := auth.ListAPITokens(h.ProgramDir+h.AuthFile, getUsername(r))
+	if err != nil {
+		h.L.Error(fmt.Errorf("ListAPITokens: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens": tokens,
+		"scopes": []string{auth.TokenScopeRead, auth.TokenScopeActions},
+	}))
+}
+
+// TokensPOST creates an API token for the logged in user.
+// The secret of the token is returned only in this response.
+func (h *Handler) TokensPOST(w http.ResponseWriter, r *http.Request) {
+	if !h.tokensAccess(w, r) {
+		return
+	}
+
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.ExpiresDays < 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid expires_days")
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, req.ExpiresDays)
+	}
+
+	userName := getUsername(r)
+	secret, token, err := auth.CreateAPIToken(h.ProgramDir+h.AuthFile, userName, req.Name, req.Scope, expiresAt)
+	if err != nil {
+		writeJSONError(w, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.L.Info("API token created:", token.Name, "scope:", token.Scope, "user:", userName)
+	h.audit(r, userName, auth.AuditToken, "create "+token.Name+" scope="+token.Scope, "ok")
+
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     secret,
+		"api_token": token,
+	}))
+}
+
+// TokenDELETE revokes an API token of the logged in user.
+func (h *Handler) TokenDELETE(w http.ResponseWriter, r *http.Request) {
+	if !h.tokensAccess(w, r) {
+		return
+	}
+
+	userName := getUsername(r)
+	id := chi.URLParam(r, "id")
+	if err := auth.DeleteAPIToken(h.ProgramDir+h.AuthFile, userName, id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	h.L.Info("API token revoked:", id, "user:", userName)
+	h.audit(r, userName, auth.AuditToken, "revoke "+id, "ok")
+
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(map[string]string{"status": "ok"}))
+}
+
+// tokensAccess refuses the token management requests of the API tokens,
+// e.g. a read-only token could create a token for the actions.
+func (h *Handler) tokensAccess(w http.ResponseWriter, r *http.Request) bool {
+	if auth.TokenScope(r) != "" {
+		writeJSONError(w, http.StatusForbidden, "API tokens can not be managed with an API token")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+type totpRequest struct {
+	Code string `json:"code"`
+}
+
+// pendingPath is the path of the pending login cookie.
+// Unlike the session, it must be sent to the index route too, where the login form is posted.
+func (h *Handler) pendingPath() string {
+	return filepath.Join(config.GetString(h.Cfg, "on_start.routes.index"))
+}
+
+// needsSecondFactor checks whether the user must pass the TOTP step, before getting a session.
+func (h *Handler) needsSecondFactor(userName string) bool {
+	enabled, err := auth.TOTPEnabled(h.ProgramDir+h.AuthFile, userName)
+	h.L.Error(err)
+	return enabled || h.totpRequired(userName)
+}
+
+// totpRequired checks whether the user must use TOTP, because of its role.
+func (h *Handler) totpRequired(userName string) bool {
+	if !config.GetBool(h.Cfg, "on_runtime.totp_required_for_admins") {
+		return false
+	}
+	role, _ := auth.GetUserRole(h.ProgramDir+h.AuthFile, userName)
+	return role == auth.RoleAdmin
+}
+
+// secondFactor finishes a pending login with a TOTP or a recovery code.
+// If the user has no TOTP yet, the code confirms the enrollment, and the recovery codes are shown once.
+func (h *Handler) secondFactor(w http.ResponseWriter, r *http.Request, userName, code string) {
+	authFile := h.ProgramDir + h.AuthFile
+	path := filepath.Join(config.GetString(h.Cfg, "on_start.routes.index")) + "/"
+
+	if h.loginBlocked(w, r, userName) {
+		return
+	}
+
+	enabled, err := auth.TOTPEnabled(authFile, userName)
+	h.L.Error(err)
+
+	if enabled {
+		if !auth.VerifyTOTP(authFile, userName, code) {
+			h.loginFailed(w, r, userName, loginErrorCode)
+			return
+		}
+
+		auth.ClearPendingLogin(h.pendingPath(), w)
+		if err := auth.SetSession(path, userName, w, r); err != nil {
+			h.L.Error(err)
+			http.Redirect(w, r, h.LoginRoute, 302)
+			return
+		}
+		h.loginSucceeded(r, userName)
+		http.Redirect(w, r, h.InternalRoute, 302)
+		return
+	}
+
+	codes, err := auth.ConfirmTOTP(authFile, userName, code)
+	if err != nil {
+		h.L.Warning("TOTP enrollment failed, user:", userName, "error:", err)
+		h.loginFailed(w, r, userName, loginErrorCode)
+		return
+	}
+
+	h.L.Info("TOTP enabled, user:", userName)
+	auth.ClearPendingLogin(h.pendingPath(), w)
+	if err := auth.SetSession(path, userName, w, r); err != nil {
+		h.L.Error(err)
+		http.Redirect(w, r, h.LoginRoute, 302)
+		return
+	}
+	h.loginSucceeded(r, userName)
+
+	h.renderLogin(w, loginPage{Step: loginStepCodes, UserName: userName, RecoveryCodes: codes})
+}
+
+// TOTP manages the two-factor authentication of the logged in user: /totp/{action}
+//   - GET  status:  {"enabled":true,"required":false}
+//   - POST setup:   a new secret with its provisioning URI and QR code
+//   - POST confirm: {"code":"123456"} enables TOTP, and returns the recovery codes
+//   - POST disable: {"code":"123456"} disables TOTP, a valid TOTP or recovery code is needed
+func (h *Handler) TOTP(w http.ResponseWriter, r *http.Request) {
+	userName := getUsername(r)
+	authFile := h.ProgramDir + h.AuthFile
+	action := chi.URLParam(r, "action")
+
+	if r.Method == http.MethodGet {
+		if action != "status" {
+			writeJSONError(w, http.StatusNotFound, "unknown action: "+action)
+			return
+		}
+		enabled, err := auth.TOTPEnabled(authFile, userName)
+		if err != nil {
+			writeJSONError(w, userErrorStatus(err), err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		h.L.Error(json.NewEncoder(w).Encode(map[string]bool{
+			"enabled":  enabled,
+			"required": h.totpRequired(userName),
+		}))
+		return
+	}
+
+	var req totpRequest
+	if action != "setup" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+	}
+
+	var response interface{}
+	switch action {
+	case "setup":
+		setup, err := auth.BeginTOTP(authFile, userName)
+		if err != nil {
+			writeJSONError(w, userErrorStatus(err), err.Error())
+			return
+		}
+		response = setup
+	case "confirm":
+		codes, err := auth.ConfirmTOTP(authFile, userName, req.Code)
+		if err != nil {
+			writeJSONError(w, userErrorStatus(err), err.Error())
+			return
+		}
+		h.L.Info("TOTP enabled, user:", userName)
+		response = map[string][]string{"recovery_codes": codes}
+	case "disable":
+		if h.totpRequired(userName) {
+			writeJSONError(w, http.StatusConflict, "two-factor authentication is required for the role: "+auth.RoleAdmin)
+			return
+		}
+		if !auth.VerifyTOTP(authFile, userName, req.Code) {
+			writeJSONError(w, http.StatusBadRequest, "invalid code")
+			return
+		}
+		if err := auth.DisableTOTP(authFile, userName); err != nil {
+			writeJSONError(w, userErrorStatus(err), err.Error())
+			return
+		}
+		h.L.Info("TOTP disabled, user:", userName)
+		response = map[string]string{"status": "ok"}
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown action: "+action)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(response))
+}
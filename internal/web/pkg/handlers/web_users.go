@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+)
+
+type userRequest struct {
+	Username  string  `json:"username"`
+	Password  *string `json:"password"`
+	Role      *string `json:"role"`
+	Disabled  *bool   `json:"disabled"`
+	ResetTOTP bool    `json:"reset_totp"`
+}
+
+// changes describes the changed fields for the audit log, without the password.
+func (req userRequest) changes() string {
+	changes := ""
+	if req.Password != nil {
+		changes += " password"
+	}
+	if req.Role != nil {
+		changes += " role=" + *req.Role
+	}
+	if req.Disabled != nil {
+		changes += fmt.Sprintf(" disabled=%t", *req.Disabled)
+	}
+	if req.ResetTOTP {
+		changes += " reset_totp"
+	}
+	return changes
+}
+
+// UsersGET lists the users of the auth database as JSON.
+func (h *Handler) UsersGET(w http.ResponseWriter, r *http.Request) {
+	users, err := auth.ListUsers(h.ProgramDir + h.AuthFile)
+	if err != nil {
+		h.L.Error(fmt.Errorf("ListUsers: %v", err))
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	h.L.Error(json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+		"roles": auth.Roles(),
+	}))
+}
+
+// UsersPOST creates a user.
+// The request body must be JSON: {"username": "bob", "password": "secret123", "role": "viewer"}.
+func (h *Handler) UsersPOST(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "bad request")
+		return
+	}
+	if req.Password == nil {
+		writeJSONError(w, http.StatusBadRequest, "missing password")
+		return
+	}
+
+	role := auth.RoleViewer
+	if req.Role != nil {
+		role = *req.Role
+	}
+
+	if err := auth.CreateUser(h.ProgramDir+h.AuthFile, req.Username, *req.Password, role); err != nil {
+		writeJSONError(w, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.L.Info("user created:", req.Username, "role:", role, "by:", getUsername(r))
+	h.audit(r, getUsername(r), auth.AuditUser, "create "+req.Username+" role="+role, "ok")
+	writeUsersOk(w, req.Username)
+}
+
+// UserPATCH changes the role, the password or the disabled flag of a user, or resets its two-factor authentication.
+// The request body must be JSON, the missing fields are not changed: {"role": "operator", "disabled": false, "reset_totp": true}.
+// The changes are saved together: if any of them is invalid, none of them is saved.
+func (h *Handler) UserPATCH(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	authFile := h.ProgramDir + h.AuthFile
+
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "bad request")
+		return
+	}
+
+	err := auth.UpdateUser(authFile, username, auth.UserUpdate{
+		Password:  req.Password,
+		Role:      req.Role,
+		Disabled:  req.Disabled,
+		ResetTOTP: req.ResetTOTP,
+	})
+	if err != nil {
+		h.L.Warning("user update failed:", username, err)
+		writeJSONError(w, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.L.Info("user updated:", username, "by:", getUsername(r))
+	h.audit(r, getUsername(r), auth.AuditUser, "update "+username+req.changes(), "ok")
+	writeUsersOk(w, username)
+}
+
+// UserUnlock removes the lockout of a user, which was caused by failed logins.
+func (h *Handler) UserUnlock(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if err := auth.UnlockUser(h.ProgramDir+h.AuthFile, username); err != nil {
+		writeJSONError(w, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.L.Info("user unlocked:", username, "by:", getUsername(r))
+	h.audit(r, getUsername(r), auth.AuditUser, "unlock "+username, "ok")
+	writeUsersOk(w, username)
+}
+
+// UserDELETE removes a user.
+func (h *Handler) UserDELETE(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if err := auth.DeleteUser(h.ProgramDir+h.AuthFile, username); err != nil {
+		writeJSONError(w, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.L.Info("user deleted:", username, "by:", getUsername(r))
+	h.audit(r, getUsername(r), auth.AuditUser, "delete "+username, "ok")
+	writeUsersOk(w, username)
+}
+
+// userErrorStatus maps the errors of the auth package to HTTP status codes.
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrUserExists),
+		errors.Is(err, auth.ErrLastAdmin),
+		errors.Is(err, auth.ErrExternalUser),
+		errors.Is(err, auth.ErrTOTPEnabled),
+		errors.Is(err, auth.ErrTOTPNotStarted):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrInvalid),
+		errors.Is(err, auth.ErrPasswordTooShort):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeUsersOk(w http.ResponseWriter, username string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "username": username})
+}
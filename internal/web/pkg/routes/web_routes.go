@@ -0,0 +1,196 @@
+// Package routes wires the packages of the web service, and registers its routes.
+// It is shared by the web service and by the all-in-one binary.
+package routes
+
+import (
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/common/pkg/apiauth"
+	"github.com/takattila/monitor/internal/common/pkg/assets"
+	"github.com/takattila/monitor/internal/common/pkg/config"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
+	"github.com/takattila/monitor/internal/web/pkg/auth"
+	"github.com/takattila/monitor/internal/web/pkg/handlers"
+	"github.com/takattila/monitor/internal/web/pkg/servers"
+	"github.com/takattila/monitor/internal/web/pkg/terminal"
+	"github.com/takattila/monitor/pkg/logger"
+	"github.com/takattila/settings-manager"
+)
+
+// Configure prepares the auth database, the sessions and the authenticator of the logins.
+// The terminal sessions are closed at the shutdown.
+func Configure(dir string, s *settings.Settings) error {
+	if err := auth.SaveCredentials(filepath.Join(dir, config.GetString(s, "on_start.auth_file")), config.GetBool(s, "on_start.save_credentials")); err != nil {
+		return err
+	}
+
+	if err := auth.ConfigureSessions(
+		filepath.Join(dir, config.GetString(s, "on_start.auth_file")),
+		time.Duration(config.GetInt(s, "on_start.session.idle_timeout_minutes"))*time.Minute,
+		time.Duration(config.GetInt(s, "on_start.session.absolute_timeout_days"))*24*time.Hour,
+	); err != nil {
+		return err
+	}
+
+	authenticator, err := auth.NewAuthenticator(config.GetString(s, "on_start.authentication.backend"), auth.PAMAuthenticator{
+		Service:       config.GetString(s, "on_start.authentication.pam.service"),
+		AllowedGroups: config.GetStringSlice(s, "on_start.authentication.pam.allowed_groups"),
+		DefaultRole:   config.GetString(s, "on_start.authentication.pam.default_role"),
+	}, auth.LDAPConfig{
+		URL:            config.GetString(s, "on_start.authentication.ldap.url"),
+		StartTLS:       config.GetBool(s, "on_start.authentication.ldap.start_tls"),
+		CAFile:         config.GetString(s, "on_start.authentication.ldap.ca_file"),
+		BindDN:         config.GetString(s, "on_start.authentication.ldap.bind_dn"),
+		BindPassword:   config.GetString(s, "on_start.authentication.ldap.bind_password"),
+		BaseDN:         config.GetString(s, "on_start.authentication.ldap.base_dn"),
+		UserFilter:     config.GetString(s, "on_start.authentication.ldap.user_filter"),
+		GroupAttribute: config.GetString(s, "on_start.authentication.ldap.group_attribute"),
+		RoleGroups: map[string][]string{
+			auth.RoleAdmin:    config.GetStringSlice(s, "on_start.authentication.ldap.admin_groups"),
+			auth.RoleOperator: config.GetStringSlice(s, "on_start.authentication.ldap.operator_groups"),
+			auth.RoleViewer:   config.GetStringSlice(s, "on_start.authentication.ldap.viewer_groups"),
+		},
+		DefaultRole:        config.GetString(s, "on_start.authentication.ldap.default_role"),
+		PoolSize:           config.GetInt(s, "on_start.authentication.ldap.pool_size"),
+		Timeout:            time.Duration(config.GetInt(s, "on_start.authentication.ldap.timeout_seconds")) * time.Second,
+		FallbackToDatabase: config.GetBool(s, "on_start.authentication.ldap.fallback_to_database"),
+	})
+	if err != nil {
+		return err
+	}
+	auth.SetAuthenticator(authenticator)
+	lifecycle.OnShutdown("terminal sessions", terminal.CloseAll)
+	return nil
+}
+
+// NewHandler returns the handler of the web routes, the API is reached with the default client.
+func NewHandler(dir string, s *settings.Settings, l logger.Logger) *handlers.Handler {
+	return &handlers.Handler{
+		ProgramDir:    dir,
+		FilesDir:      config.GetString(s, "on_start.web_sources_directory"),
+		Assets:        assets.New(filepath.Join(dir, config.GetString(s, "on_start.web_sources_directory"))),
+		AuthFile:      config.GetString(s, "on_start.auth_file"),
+		LoginPage:     config.GetString(s, "on_start.pages.login"),
+		InternalPage:  config.GetString(s, "on_start.pages.internal"),
+		LoginRoute:    config.GetString(s, "on_start.routes.login"),
+		InternalRoute: config.GetString(s, "on_start.routes.internal"),
+		Cfg:           s,
+		L:             l,
+		OIDC:          NewOIDCProvider(s),
+	}
+}
+
+// NewOIDCProvider reads the single sign-on provider and the mapping of its groups to roles from the 'on_start.oidc' settings.
+// It returns nil, if the single sign-on is disabled. The provider is shared by the requests, it caches the discovery and the keys.
+func NewOIDCProvider(s *settings.Settings) *auth.OIDCProvider {
+	if !config.GetBool(s, "on_start.oidc.enabled") || config.GetString(s, "on_start.oidc.issuer") == "" {
+		return nil
+	}
+	return auth.NewOIDCProvider(auth.OIDCConfig{
+		Issuer:        config.GetString(s, "on_start.oidc.issuer"),
+		ClientID:      config.GetString(s, "on_start.oidc.client_id"),
+		ClientSecret:  config.GetString(s, "on_start.oidc.client_secret"),
+		RedirectURL:   config.GetString(s, "on_start.oidc.redirect_url"),
+		Scopes:        config.GetStringSlice(s, "on_start.oidc.scopes"),
+		UsernameClaim: config.GetString(s, "on_start.oidc.username_claim"),
+		GroupsClaim:   config.GetString(s, "on_start.oidc.groups_claim"),
+		RoleGroups: map[string][]string{
+			auth.RoleAdmin:    config.GetStringSlice(s, "on_start.oidc.admin_groups"),
+			auth.RoleOperator: config.GetStringSlice(s, "on_start.oidc.operator_groups"),
+			auth.RoleViewer:   config.GetStringSlice(s, "on_start.oidc.viewer_groups"),
+		},
+		DefaultRole: config.GetString(s, "on_start.oidc.default_role"),
+	})
+}
+
+// NewApiClient returns the client of the MONITOR-API service, which signs the requests with the shared secret.
+func NewApiClient(dir string, s *settings.Settings) (*http.Client, error) {
+	var secret []byte
+	if secretFile := config.GetString(s, "on_start.api_auth.secret_file"); secretFile != "" {
+		var err error
+		if secret, err = apiauth.LoadSecret(filepath.Join(dir, secretFile)); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig, err := apiauth.ClientTLSConfig(dir,
+		config.GetString(s, "on_start.api_auth.ca_file"),
+		config.GetString(s, "on_start.api_auth.cert_file"),
+		config.GetString(s, "on_start.api_auth.key_file"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var socket string
+	if apiSocket := config.GetString(s, "on_start.api_socket"); apiSocket != "" {
+		socket = filepath.Join(dir, apiSocket)
+	}
+	return apiauth.NewClient(secret, tlsConfig, socket), nil
+}
+
+// Register adds the routes of the web service to the router.
+func Register(router chi.Router, h *handlers.Handler, s *settings.Settings) {
+	router.Use(h.IPFilter)
+
+	// Every route requires a permission, except the login related ones.
+	router.HandleFunc(config.GetString(s, "on_start.routes.index"), h.Index)
+	router.Get(config.GetString(s, "on_start.routes.login"), h.Login)
+	router.Get(config.GetString(s, "on_start.routes.logout"), h.Logout)
+	router.Get(config.GetString(s, "on_start.routes.oidc_login"), h.OIDCLogin)
+	router.Get(config.GetString(s, "on_start.routes.oidc_callback"), h.OIDCCallback)
+	router.Get(config.GetString(s, "on_start.routes.internal"), h.Require(auth.PermView, h.Internal))
+	router.Get(config.GetString(s, "on_start.routes.api"), h.Require(auth.PermView, h.Api))
+	router.Post(config.GetString(s, "on_start.routes.toggle"), h.Require(auth.PermOperate, h.Toggle))
+	router.Get(config.GetString(s, "on_start.routes.settings"), h.Require(auth.PermView, h.SettingsGET))
+	router.Post(config.GetString(s, "on_start.routes.settings"), h.Require(auth.PermView, h.SettingsPOST))
+	router.Post(config.GetString(s, "on_start.routes.systemctl"), h.Require(auth.PermServices, h.SystemCtl))
+	router.Post(config.GetString(s, "on_start.routes.power"), h.Require(auth.PermPower, h.Power))
+	router.Post(config.GetString(s, "on_start.routes.kill"), h.Require(auth.PermKill, h.Kill))
+	router.Get(config.GetString(s, "on_start.routes.run"), h.Require(auth.PermView, h.Run))
+	router.Post(config.GetString(s, "on_start.routes.run"), h.Require(auth.PermView, h.Run)) // exec and cancel require: auth.PermRun
+	router.Get(config.GetString(s, "on_start.routes.run_job"), h.Require(auth.PermView, h.Run))
+	router.Get(config.GetString(s, "on_start.routes.run_stream"), h.Require(auth.PermView, h.RunStream))
+	router.Get(config.GetString(s, "on_start.routes.terminal"), h.Require(auth.PermTerminal, h.Terminal))
+	router.Get(config.GetString(s, "on_start.routes.users"), h.Require(auth.PermUsers, h.UsersGET))
+	router.Post(config.GetString(s, "on_start.routes.users"), h.Require(auth.PermUsers, h.UsersPOST))
+	router.Patch(config.GetString(s, "on_start.routes.user"), h.Require(auth.PermUsers, h.UserPATCH))
+	router.Delete(config.GetString(s, "on_start.routes.user"), h.Require(auth.PermUsers, h.UserDELETE))
+	router.Post(config.GetString(s, "on_start.routes.user_unlock"), h.Require(auth.PermUsers, h.UserUnlock))
+	router.Get(config.GetString(s, "on_start.routes.totp"), h.Require(auth.PermView, h.TOTP))
+	router.Post(config.GetString(s, "on_start.routes.totp"), h.Require(auth.PermView, h.TOTP))
+	router.Get(config.GetString(s, "on_start.routes.sessions"), h.Require(auth.PermView, h.SessionsGET))
+	router.Delete(config.GetString(s, "on_start.routes.sessions"), h.Require(auth.PermView, h.SessionsDELETE))
+	router.Delete(config.GetString(s, "on_start.routes.session"), h.Require(auth.PermView, h.SessionDELETE))
+	router.Get(config.GetString(s, "on_start.routes.audit"), h.Require(auth.PermAudit, h.AuditGET))
+	router.Get(config.GetString(s, "on_start.routes.tokens"), h.Require(auth.PermView, h.TokensGET))
+	router.Post(config.GetString(s, "on_start.routes.tokens"), h.Require(auth.PermView, h.TokensPOST))
+	router.Delete(config.GetString(s, "on_start.routes.token"), h.Require(auth.PermView, h.TokenDELETE))
+}
+
+// Serve serves the web sources and the routes.
+func Serve(dir string, router chi.Router, s *settings.Settings, l logger.Logger) {
+	server := servers.Server{
+		Port:       config.GetInt(s, "on_start.port"),
+		Domain:     config.GetString(s, "on_start.domain"),
+		Router:     router,
+		RoutePath:  config.GetString(s, "on_start.routes.web"),
+		ProgramDir: dir,
+		FilesDir:   config.GetString(s, "on_start.web_sources_directory"),
+		Cfg:        s,
+		L:          l,
+		TLS: servers.TLS{
+			Mode:         config.GetString(s, "on_start.tls.mode"),
+			CertFile:     config.GetString(s, "on_start.tls.cert_file"),
+			KeyFile:      config.GetString(s, "on_start.tls.key_file"),
+			MinVersion:   config.GetString(s, "on_start.tls.min_version"),
+			HTTPRedirect: config.GetBool(s, "on_start.tls.http_redirect"),
+			HTTPPort:     config.GetInt(s, "on_start.tls.http_port"),
+		},
+	}
+
+	server.Files()
+	server.Start()
+}
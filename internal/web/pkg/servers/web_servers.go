@@ -3,6 +3,8 @@ package servers
 import (
 	"crypto/tls"
 	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/user"
@@ -10,6 +12,8 @@ import (
 	"strings"
 
 	"github.com/go-chi/chi"
+	"github.com/takattila/monitor/internal/common/pkg/assets"
+	"github.com/takattila/monitor/internal/common/pkg/lifecycle"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
 	"golang.org/x/crypto/acme/autocert"
@@ -22,8 +26,10 @@ type Server struct {
 	RoutePath  string
 	ProgramDir string
 	FilesDir   string
+	Assets     fs.FS
 	Cfg        *settings.Settings
 	L          logger.Logger
+	TLS        TLS
 }
 
 var (
@@ -31,10 +37,20 @@ var (
 	httpPort = 80
 )
 
+// Start serves the router with the TLS mode of the server.
 func (s *Server) Start() {
-	if s.Port == tlsPort {
+	mode, err := s.TLS.mode(s.Port)
+	if err != nil {
+		s.L.Fatal(err)
+		return
+	}
+
+	switch mode {
+	case TLSFiles:
+		s.ServeTLSFiles()
+	case TLSAutocert:
 		s.ServeTLS()
-	} else {
+	default:
 		s.ServeHTTP()
 	}
 }
@@ -48,14 +64,22 @@ func (s *Server) ServeHTTP() {
 		Handler: s.Router,
 	}
 
-	s.L.Fatal(server.ListenAndServe())
+	s.serve(server, false)
 }
 
 // ServeTLS runs service with TLS config on a specific domain.
+// The certificate is requested from Let's Encrypt, the HTTP port must be reachable for the challenges.
+// The other plain HTTP requests are redirected to HTTPS only, if the redirect is enabled.
 func (s *Server) ServeTLS() {
 	s.L.Info("Port:", s.Port)
 	s.L.Info("Domain:", s.Domain)
 
+	minVersion, err := s.TLS.minVersion()
+	if err != nil {
+		s.L.Fatal(err)
+		return
+	}
+
 	certManager := autocert.Manager{
 		Prompt:     autocert.AcceptTOS,
 		HostPolicy: autocert.HostWhitelist(s.Domain),
@@ -72,20 +96,91 @@ func (s *Server) ServeTLS() {
 		Addr: fmt.Sprintf(":%d", s.Port),
 		TLSConfig: &tls.Config{
 			GetCertificate: certManager.GetCertificate,
+			MinVersion:     minVersion,
 		},
 		Handler: s.Router,
 	}
 
-	go func() {
-		s.L.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", httpPort), certManager.HTTPHandler(nil)))
-	}()
+	go s.serve(&http.Server{
+		Addr:    fmt.Sprintf(":%d", s.httpPort()),
+		Handler: s.challengeHandler(&certManager),
+	}, false)
 
-	s.L.Fatal(server.ListenAndServeTLS("", "")) // Key and cert are coming from Let's Encrypt
+	s.serve(server, true) // Key and cert are coming from Let's Encrypt
 }
 
-// Files conveniently sets up a http.FileServer handler to serve
-// static files from a http.FileSystem.
+// ServeTLSFiles runs service with the certificate and the key of the files, on any port.
+// The changed files are loaded again without a restart.
+func (s *Server) ServeTLSFiles() {
+	s.L.Info("Port:", s.Port)
+	s.L.Info("Certificate:", s.TLS.CertFile)
+
+	minVersion, err := s.TLS.minVersion()
+	if err != nil {
+		s.L.Fatal(err)
+		return
+	}
+
+	certs, err := newCertReloader(filepath.Join(s.ProgramDir, s.TLS.CertFile), filepath.Join(s.ProgramDir, s.TLS.KeyFile), s.L)
+	if err != nil {
+		s.L.Fatal(err)
+		return
+	}
+
+	server := &http.Server{
+		Addr: fmt.Sprintf(":%d", s.Port),
+		TLSConfig: &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     minVersion,
+		},
+		Handler: s.Router,
+	}
+
+	if s.TLS.HTTPRedirect {
+		go s.serve(&http.Server{
+			Addr:    fmt.Sprintf(":%d", s.httpPort()),
+			Handler: redirectHandler(s.Port),
+		}, false)
+	}
+
+	s.serve(server, true)
+}
+
+// challengeHandler answers the Let's Encrypt challenges on the plain HTTP port.
+// The other requests are redirected to HTTPS, if the redirect is enabled, otherwise they are not found.
+func (s *Server) challengeHandler(certManager *autocert.Manager) http.Handler {
+	if s.TLS.HTTPRedirect {
+		return certManager.HTTPHandler(redirectHandler(s.Port))
+	}
+	// A nil fallback would redirect too.
+	return certManager.HTTPHandler(http.NotFoundHandler())
+}
+
+// serve listens on the address of the server, and serves it until the graceful shutdown.
+func (s *Server) serve(server *http.Server, useTLS bool) {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		s.L.Fatal(err)
+		return
+	}
+	s.L.Fatal(lifecycle.Serve(server, listener, useTLS))
+}
+
+// httpPort is the port of the plain HTTP redirect, 80 by default.
+func (s *Server) httpPort() int {
+	if s.TLS.HTTPPort != 0 {
+		return s.TLS.HTTPPort
+	}
+	return httpPort
+}
+
+// Files serves the static files of the web interface: the embedded ones,
+// overridden by the files of the web sources directory.
 func (s *Server) Files() {
+	if s.Assets == nil {
+		s.Assets = assets.New(filepath.Join(s.ProgramDir, s.FilesDir))
+	}
+
 	notAllowed := "{}*"
 	if strings.ContainsAny(s.RoutePath, notAllowed) {
 		s.L.Warning("Does not permit any URL parameters:", notAllowed)
@@ -101,10 +196,10 @@ func (s *Server) Files() {
 	s.Router.Get(s.RoutePath, func(w http.ResponseWriter, r *http.Request) {
 		rctx := chi.RouteContext(r.Context())
 		pathPrefix := strings.TrimSuffix(rctx.RoutePattern(), "/*")
-		fs := http.StripPrefix(pathPrefix, http.FileServer(http.Dir(filepath.Join(s.ProgramDir, s.FilesDir))))
+		files := http.StripPrefix(pathPrefix, assets.Handler(s.Assets))
 
 		s.L.Info("r.URL.Path", r.URL.Path)
-		fs.ServeHTTP(w, r)
+		files.ServeHTTP(w, r)
 	})
 }
 
@@ -1,9 +1,21 @@
 package servers
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -16,6 +28,7 @@ import (
 	"github.com/takattila/monitor/pkg/common"
 	"github.com/takattila/monitor/pkg/logger"
 	"github.com/takattila/settings-manager"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type (
@@ -84,6 +97,185 @@ func (a WebServersSuite) TestStartTLS() {
 	a.Contains(fmt.Sprint(err), "remote error: tls: internal error")
 }
 
+func (a WebServersSuite) TestStartTLSFiles() {
+	oldCertCheckInterval := certCheckInterval
+	defer func() { certCheckInterval = oldCertCheckInterval }()
+	certCheckInterval = 0
+
+	dir := a.T().TempDir()
+	a.writeCert(dir, "first")
+
+	webport, err := freeport.GetFreePort()
+	a.Equal(nil, err)
+	redirectPort, err := freeport.GetFreePort()
+	a.Equal(nil, err)
+
+	router := chi.NewRouter()
+	router.Get("/monitor/internal", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	server := Server{
+		Port:       webport,
+		Router:     router,
+		ProgramDir: dir,
+		L:          logger.New(logger.NoneLevel, logger.ColorOff),
+		TLS: TLS{
+			CertFile:     "/server.pem",
+			KeyFile:      "/server.key",
+			MinVersion:   "1.3",
+			HTTPRedirect: true,
+			HTTPPort:     redirectPort,
+		},
+	}
+	go server.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	internalURL := fmt.Sprintf("https://127.0.0.1:%d/monitor/internal", webport)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(internalURL)
+	a.Require().NoError(err)
+	a.Equal(200, resp.StatusCode)
+	a.Equal("first", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	resp.Body.Close()
+
+	// The changed certificate is used by the new connections without a restart.
+	a.writeCert(dir, "second")
+	later := time.Now().Add(time.Minute)
+	a.Equal(nil, os.Chtimes(filepath.Join(dir, "server.pem"), later, later))
+	client.CloseIdleConnections()
+	resp, err = client.Get(internalURL)
+	a.Require().NoError(err)
+	a.Equal("second", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	resp.Body.Close()
+
+	// A broken certificate is not loaded, the previous one is kept.
+	a.Equal(nil, os.WriteFile(filepath.Join(dir, "server.pem"), []byte("broken"), 0600))
+	a.Equal(nil, os.Chtimes(filepath.Join(dir, "server.pem"), later.Add(time.Minute), later.Add(time.Minute)))
+	client.CloseIdleConnections()
+	resp, err = client.Get(internalURL)
+	a.Require().NoError(err)
+	a.Equal("second", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	resp.Body.Close()
+
+	// The older TLS versions are rejected.
+	old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}}}
+	_, err = old.Get(internalURL)
+	a.Contains(fmt.Sprint(err), "protocol version")
+
+	// The plain HTTP requests are redirected.
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = noRedirect.Get(fmt.Sprintf("http://127.0.0.1:%d/monitor/internal?x=1", redirectPort))
+	a.Require().NoError(err)
+	a.Equal(http.StatusMovedPermanently, resp.StatusCode)
+	a.Equal(internalURL+"?x=1", resp.Header.Get("Location"))
+	resp.Body.Close()
+}
+
+func (a WebServersSuite) TestTLSMode() {
+	for _, tc := range []struct {
+		config   TLS
+		port     int
+		expected string
+	}{
+		{TLS{}, 8383, TLSOff},
+		{TLS{}, tlsPort, TLSAutocert},
+		{TLS{CertFile: "cert.pem", KeyFile: "cert.key"}, tlsPort, TLSFiles},
+		{TLS{Mode: TLSAuto, CertFile: "cert.pem"}, 8443, TLSFiles},
+		{TLS{Mode: TLSOff, CertFile: "cert.pem"}, tlsPort, TLSOff},
+		{TLS{Mode: TLSAutocert}, 8443, TLSAutocert},
+	} {
+		mode, err := tc.config.mode(tc.port)
+		a.Equal(nil, err)
+		a.Equal(tc.expected, mode, tc.config)
+	}
+
+	_, err := TLS{Mode: "letsencrypt"}.mode(tlsPort)
+	a.Contains(err.Error(), "invalid TLS mode")
+
+	version, err := TLS{}.minVersion()
+	a.Equal(nil, err)
+	a.Equal(uint16(tls.VersionTLS12), version)
+	_, err = TLS{MinVersion: "1.4"}.minVersion()
+	a.Contains(err.Error(), "invalid minimum TLS version")
+
+	_, err = newCertReloader("not_exists.pem", "not_exists.key", h.L)
+	a.Contains(err.Error(), "TLS certificate")
+}
+
+func (a WebServersSuite) TestRedirectHandler() {
+	for _, tc := range []struct {
+		method, host string
+		port         int
+		status       int
+		location     string
+	}{
+		{"GET", "example.net", tlsPort, http.StatusMovedPermanently, "https://example.net/monitor?x=1"},
+		{"GET", "example.net:80", 8443, http.StatusMovedPermanently, "https://example.net:8443/monitor?x=1"},
+		{"POST", "[::1]:80", tlsPort, http.StatusPermanentRedirect, "https://[::1]/monitor?x=1"},
+		{"HEAD", "[::1]", 8443, http.StatusMovedPermanently, "https://[::1]:8443/monitor?x=1"},
+	} {
+		r := httptest.NewRequest(tc.method, "/monitor?x=1", nil)
+		r.Host = tc.host
+		w := httptest.NewRecorder()
+		redirectHandler(tc.port).ServeHTTP(w, r)
+		a.Equal(tc.status, w.Code)
+		a.Equal(tc.location, w.Header().Get("Location"))
+	}
+}
+
+func (a WebServersSuite) TestChallengeHandler() {
+	certManager := &autocert.Manager{Prompt: autocert.AcceptTOS, HostPolicy: autocert.HostWhitelist("example.net")}
+
+	for _, tc := range []struct {
+		redirect bool
+		status   int
+		location string
+	}{
+		{true, http.StatusMovedPermanently, "https://example.net/monitor?x=1"},
+		{false, http.StatusNotFound, ""},
+	} {
+		server := &Server{Port: tlsPort, TLS: TLS{HTTPRedirect: tc.redirect}}
+
+		r := httptest.NewRequest("GET", "/monitor?x=1", nil)
+		r.Host = "example.net"
+		w := httptest.NewRecorder()
+		server.challengeHandler(certManager).ServeHTTP(w, r)
+		a.Equal(tc.status, w.Code)
+		a.Equal(tc.location, w.Header().Get("Location"))
+
+		// The challenges are answered by the certificate manager in both cases.
+		r = httptest.NewRequest("GET", "/.well-known/acme-challenge/token", nil)
+		r.Host = "example.net"
+		w = httptest.NewRecorder()
+		server.challengeHandler(certManager).ServeHTTP(w, r)
+		a.Empty(w.Header().Get("Location"))
+		a.Contains(w.Body.String(), "acme/autocert")
+	}
+}
+
+// writeCert writes a self-signed certificate with the common name into the directory.
+func (a WebServersSuite) writeCert(dir, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	a.Require().NoError(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	a.Require().NoError(err)
+
+	a.Require().NoError(os.WriteFile(filepath.Join(dir, "server.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	a.Require().NoError(os.WriteFile(filepath.Join(dir, "server.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
 func (a WebServersSuite) TestFilesNotAllowed() {
 	oldFilesRute := s.Data.Get("on_start.routes.web")
 	s.Data.Set("on_start.routes.web", "/monitor/web*")
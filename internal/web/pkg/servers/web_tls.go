@@ -0,0 +1,165 @@
+package servers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/takattila/monitor/pkg/logger"
+)
+
+// TLS modes of the web service.
+const (
+	TLSAuto     = "auto"     // Files, if the certificate is set, autocert on port 443, otherwise plain HTTP.
+	TLSOff      = "off"      // Plain HTTP.
+	TLSFiles    = "files"    // The certificate and the key are read from the files.
+	TLSAutocert = "autocert" // The certificate is requested from Let's Encrypt.
+)
+
+// TLS configures HTTPS of the web service.
+type TLS struct {
+	Mode         string
+	CertFile     string
+	KeyFile      string
+	MinVersion   string
+	HTTPRedirect bool
+	HTTPPort     int
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	// certCheckInterval is how often the certificate files are checked for changes.
+	certCheckInterval = time.Second
+)
+
+// mode resolves the auto mode.
+func (t TLS) mode(port int) (string, error) {
+	switch t.Mode {
+	case "", TLSAuto:
+		if t.CertFile != "" || t.KeyFile != "" {
+			return TLSFiles, nil
+		}
+		if port == tlsPort {
+			return TLSAutocert, nil
+		}
+		return TLSOff, nil
+	case TLSOff, TLSFiles, TLSAutocert:
+		return t.Mode, nil
+	}
+	return "", fmt.Errorf("invalid TLS mode: %q, valid modes: %s, %s, %s, %s", t.Mode, TLSAuto, TLSOff, TLSFiles, TLSAutocert)
+}
+
+// minVersion returns the minimum TLS version, TLS 1.2 by default.
+func (t TLS) minVersion() (uint16, error) {
+	if t.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("invalid minimum TLS version: %q, valid versions: 1.0, 1.1, 1.2, 1.3", t.MinVersion)
+	}
+	return version, nil
+}
+
+// certReloader serves the certificate of the files, and loads it again, when the files are changed.
+// If the new files can not be loaded (e.g. only one of them is written yet), the previous certificate is kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	L        logger.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTimes  [2]time.Time
+	checkedAt time.Time
+}
+
+// newCertReloader loads the certificate of the files.
+func newCertReloader(certFile, keyFile string, l logger.Logger) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, L: l}
+	modTimes, err := c.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTimes); err != nil {
+		return nil, err
+	}
+	c.checkedAt = time.Now()
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, it can be used as tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checkedAt) >= certCheckInterval {
+		c.checkedAt = time.Now()
+		modTimes, err := c.stat()
+		if err != nil {
+			c.L.Error(err)
+		} else if modTimes != c.modTimes {
+			if err := c.load(modTimes); err != nil {
+				c.L.Error(err)
+			} else {
+				c.L.Info("TLS certificate is reloaded:", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// stat returns the modification times of the certificate and the key.
+func (c *certReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, fmt.Errorf("TLS certificate: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (c *certReloader) load(modTimes [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.cert, c.modTimes = &cert, modTimes
+	return nil
+}
+
+// redirectHandler redirects the plain HTTP requests to the HTTPS port of the service.
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if port != tlsPort {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -56,6 +56,8 @@ var ErrBusy = errors.New("terminal: another session is active")
 var (
 	sessionMu      sync.Mutex
 	activeSessions int
+	// connections holds the WebSocket connections of the sessions, the channels are closed, when the sessions end.
+	connections = map[*websocket.Conn]chan struct{}{}
 )
 
 func acquire() bool {
@@ -74,6 +76,46 @@ func release() {
 	activeSessions--
 }
 
+// track registers the connection of a session, the returned function removes it.
+func track(conn *websocket.Conn) func() {
+	done := make(chan struct{})
+	sessionMu.Lock()
+	connections[conn] = done
+	sessionMu.Unlock()
+
+	return func() {
+		sessionMu.Lock()
+		delete(connections, conn)
+		sessionMu.Unlock()
+		close(done)
+	}
+}
+
+// CloseAll closes the WebSocket connections of the sessions, so their shells are stopped cleanly:
+// the shells get SIGHUP, and save their history. It returns, when the sessions end, or the context is done.
+func CloseAll(ctx context.Context) error {
+	sessionMu.Lock()
+	sessions := make(map[*websocket.Conn]chan struct{}, len(connections))
+	for conn, done := range connections {
+		sessions[conn] = done
+	}
+	sessionMu.Unlock()
+
+	for conn := range sessions {
+		go func(conn *websocket.Conn) {
+			_ = conn.Close(websocket.StatusGoingAway, "the service is stopping")
+		}(conn)
+	}
+	for _, done := range sessions {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 // DetectShell returns the login shell of the current user.
 func DetectShell() string {
 	if u, err := currentUser(); err == nil && u.Username != "" {
@@ -319,6 +361,7 @@ func Serve(conn *websocket.Conn, shell, dir string, cols, rows int, usernames ..
 		return ErrBusy
 	}
 	defer release()
+	defer track(conn)()
 
 	var username string
 	if len(usernames) > 0 {
@@ -713,3 +713,41 @@ func TestServeWithUsername(t *testing.T) {
 		t.Fatalf("timed out waiting for Serve to finish")
 	}
 }
+
+func TestCloseAll(t *testing.T) {
+	require.NoError(t, CloseAll(context.Background()))
+
+	srv, url, wait := newServeServer(t, "/bin/sh", "")
+	defer srv.Close()
+
+	conn, _, err := websocket.Dial(context.Background(), url, nil)
+	require.NoError(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	require.NoError(t, conn.Write(context.Background(), websocket.MessageText, []byte(`{"type":"input","data":"echo READY\r"}`)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, data, err := conn.Read(ctx)
+		require.NoError(t, err)
+		if strings.Contains(string(data), "READY") {
+			break
+		}
+	}
+
+	// The client is told, that the service is going away, and the session ends.
+	closed := make(chan error, 1)
+	go func() { closed <- CloseAll(ctx) }()
+	for {
+		if _, _, err = conn.Read(ctx); err != nil {
+			break
+		}
+	}
+	require.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(err))
+	require.NoError(t, <-closed)
+	wait()
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	require.Empty(t, connections)
+}
@@ -0,0 +1,66 @@
+function auditQuery() {
+    var query = {};
+    ["user", "ip", "action", "since", "until"].forEach(function(name) {
+        var value = $('#audit_' + name).val();
+        if (value) {
+            query[name] = value;
+        }
+    });
+    return query;
+}
+
+function loadAudit() {
+    $.ajax({
+        type: "GET",
+        url: ROUTE_AUDIT,
+        data: auditQuery(),
+        dataType: "json",
+        success: function(data) {
+            var html = '<table class="w3-table w3-small">';
+            html += '<tr><th>time</th><th>user</th><th>ip</th><th>action</th><th>params</th><th>result</th></tr>';
+            data.entries.forEach(function(entry) {
+                html += '<tr>';
+                html += '<td>' + new Date(entry.time).toLocaleString() + '</td>';
+                html += '<td>' + escapeHtml(entry.user) + '</td>';
+                html += '<td>' + escapeHtml(entry.ip) + '</td>';
+                html += '<td>' + escapeHtml(entry.action) + '</td>';
+                html += '<td>' + escapeHtml(entry.params) + '</td>';
+                html += '<td>' + escapeHtml(entry.result) + '</td>';
+                html += '</tr>';
+            });
+            html += '</table><p></p>';
+
+            $('#audit_entries').html(html);
+        },
+        error: function(xhr) {
+            if (xhr.status == 403) {
+                return;
+            }
+            var message = xhr.responseJSON ? xhr.responseJSON.error : xhr.statusText;
+            dialog({
+                id: "info",
+                title: "Error",
+                content: escapeHtml(message),
+                cancelBtnText: "OK"
+            });
+        }
+    });
+}
+
+function exportAudit(format) {
+    var query = auditQuery();
+    query.format = format;
+    window.location.href = ROUTE_AUDIT + "?" + $.param(query);
+}
+
+function toggleAudit() {
+    $('#audit').on('click', function() {
+        if ($(this).attr('data-click-state') == 1) {
+            loadAudit();
+        }
+    });
+}
+
+$(document).ready(function() {
+    toggleAudit();
+});
@@ -1,9 +1,22 @@
 let loop = null;
 let stdoutLoop;
+let runJobs = {};
+let runParams = {};
+let runStreams = {};
+let runTerminals = {};
 let autoScroll = true;
 let networkHistory = {};
 const NETWORK_HISTORY_POINTS = 60;
 
+// Every state-changing request carries the CSRF token of the session.
+$.ajaxSetup({
+    headers: {"X-CSRF-Token": CSRF_TOKEN}
+});
+
+function can(permission) {
+    return PERMISSIONS.indexOf(permission) >= 0;
+}
+
 function setCookie(cname, cvalue, exdays) {
     const d = new Date();
     if (exdays === Infinity) {
@@ -46,10 +59,9 @@ function systemctl(params) {
 
     if (action == "start" | action == "stop" | action == "restart" | action == "enable" | action == "disable") {
         params.async = true;
-        return $.ajax(params).responseText;
     }
 
-    return $.ajax(params).responseText;
+    return $.ajax(params).fail(forbiddenDialog).responseText;
 }
 
 function logout() {
@@ -74,17 +86,22 @@ function kill(pid) {
         async: true
     };
 
-    return $.ajax(params).responseText;
+    return $.ajax(params).fail(forbiddenDialog).responseText;
 }
 
 function toggleStatus(section, status) {
+    // The sections are turned on and off for every user, the viewers only hide them.
+    if (!can("operate")) {
+        return;
+    }
+
     var params = {
-        type: "GET",
+        type: "POST",
         url: ROUTE_TOGGLE.replace("{section}", section).replace("{status}", status),
         async: true
     };
 
-    return $.ajax(params).responseText;
+    return $.ajax(params).fail(forbiddenDialog).responseText;
 }
 
 function logoutIfSessionEnded() {
@@ -289,41 +306,215 @@ function tail(id) {
 
 function startLoopStdout(id) {
     stdoutLoop = setInterval(function() {
-        var stdout = $.ajax({
+        if (!runJobs[id]) {
+            return;
+        }
+
+        var job = $.ajax({
             type: "GET",
-            url: ROUTE_RUN.replace("{action}", "stdout").replace("{name}", id),
-            dataType: 'text',
+            url: ROUTE_RUN_JOB.replace("{id}", runJobs[id]),
+            dataType: 'json',
             timeout: 500,
             cache: false,
             async: true
         });
 
-        stdout.done(function(stdout_response) {
-            if (stdout_response) {
+        job.done(function(job_response) {
+            if (job_response && job_response.id) {
                 tail('#modal_content_' + id);
                 $('#modal_loader_' + id).css("display", "none");
                 $('#modal_content_' + id).css("height", ($('#modal_' + id).height() - 80) + "px");
                 $('#modal_content_' + id).css("display", "block");
-                if (stdout_response.indexOf('~x~o(f)o~x~') >= 0) {
+
+                var output = job_response.output.split("\r").join("\n");
+                if (job_response.status != "running" && job_response.status != "queued") {
                     stopLoopStdout();
                     autoScroll = false;
+                    output += "\n[ " + runStatusText(job_response.status) + ", exit code: " + job_response.exit_code + " ]";
+                    $('#modal_cancel_' + id).css("display", "none");
+                    $('#modal_header_' + id).text(runStatusText(job_response.status) + ': "' + id + '"');
                 }
-                $('#modal_data_' + id).text(stdout_response.replace("~x~o(f)o~x~", "").split("\r").join("\n"));
+                $('#modal_data_' + id).text(output);
             }
         });
 
     }, INTERVAL_SECONDS * 1000);
 }
 
+function startRunStream(id) {
+    $('#modal_loader_' + id).css("display", "none");
+    $('#modal_content_' + id).css("height", ($('#modal_' + id).height() - 80) + "px");
+    $('#modal_content_' + id).css("display", "block");
+    $('#modal_data_' + id).css("display", "none");
+
+    var div = document.createElement('div');
+    div.id = 'modal_terminal_' + id;
+    div.style.height = '100%';
+    div.ondblclick = function() { copyContent(id); };
+    document.getElementById('modal_content_' + id).appendChild(div);
+
+    var term = new Terminal({
+        convertEol: true,
+        disableStdin: true,
+        cursorBlink: false,
+        scrollback: 10000,
+        fontSize: 14,
+        fontFamily: '"Anonymice NF", "Anonymous Pro for Powerline", monospace',
+        theme: { background: '#000000' }
+    });
+    var fitAddon = new FitAddon.FitAddon();
+    term.loadAddon(fitAddon);
+    term.open(div);
+    fitAddon.fit();
+    runTerminals[id] = term;
+
+    var protocol = location.protocol === 'https:' ? 'wss://' : 'ws://';
+    var ws = new WebSocket(protocol + location.host + ROUTE_RUN_STREAM.replace("{id}", runJobs[id]));
+    ws.binaryType = 'arraybuffer';
+    runStreams[id] = ws;
+
+    ws.onmessage = function(event) {
+        if (event.data instanceof ArrayBuffer) {
+            term.write(new Uint8Array(event.data));
+            return;
+        }
+
+        var msg = JSON.parse(event.data);
+        if (msg.type == "done") {
+            term.write("\r\n[ " + runStatusText(msg.status) + ", exit code: " + msg.exit_code + " ]\r\n");
+            $('#modal_cancel_' + id).css("display", "none");
+            $('#modal_header_' + id).text(runStatusText(msg.status) + ': "' + id + '"');
+        } else if (msg.type == "error") {
+            term.write("\r\n[ " + msg.error + " ]\r\n");
+        }
+    };
+
+    ws.onerror = function() {
+        term.write("\r\n[ The output stream is not available ]\r\n");
+    };
+}
+
+function stopRunStream(id) {
+    if (runStreams[id]) {
+        runStreams[id].onmessage = null;
+        runStreams[id].onerror = null;
+        runStreams[id].close();
+        delete runStreams[id];
+    }
+    if (runTerminals[id]) {
+        runTerminals[id].dispose();
+        delete runTerminals[id];
+    }
+    $('#modal_terminal_' + id).remove();
+    $('#modal_data_' + id).css("display", "block");
+}
+
+function runStatusText(status) {
+    switch (status) {
+        case "finished":
+            return "Finished";
+        case "failed":
+            return "Failed";
+        case "cancelled":
+            return "Cancelled";
+        case "timed_out":
+            return "Timed out";
+        case "interrupted":
+            return "Interrupted";
+        case "queued":
+            return "Queued";
+        default:
+            return "Running";
+    }
+}
+
+function cancelRun(id) {
+    if (!runJobs[id]) {
+        return;
+    }
+
+    $.ajax({
+        type: "POST",
+        url: ROUTE_RUN.replace("{action}", "cancel").replace("{name}", runJobs[id])
+    }).fail(forbiddenDialog);
+}
+
 function stopLoopStdout() {
     clearInterval(stdoutLoop);
 }
 
+function escapeHtml(text) {
+    return $('<div>').text(text).html().replaceAll('"', '&quot;');
+}
+
+function formatRunTime(value) {
+    if (!value) {
+        return '-';
+    }
+    return new Date(value).toLocaleString();
+}
+
+function runParamsForm(id) {
+    var params = runParams[id] || [];
+    if (params.length == 0) {
+        return '';
+    }
+
+    var form = '<br><br><table class="w3-table">';
+    params.forEach(function(param) {
+        var input = '';
+        var inputId = 'run_param_' + id + '_' + param.name;
+
+        if (param.type == "enum") {
+            input = '<select id="' + inputId + '" class="w3-select">';
+            param.values.forEach(function(value) {
+                var selected = value == param.default ? ' selected' : '';
+                input += '<option value="' + escapeHtml(value) + '"' + selected + '>' + escapeHtml(value) + '</option>';
+            });
+            input += '</select>';
+        } else {
+            var type = param.type == "int" ? "number" : "text";
+            var limits = '';
+            if (param.min !== undefined) {
+                limits += ' min="' + param.min + '"';
+            }
+            if (param.max !== undefined) {
+                limits += ' max="' + param.max + '"';
+            }
+            input = '<input id="' + inputId + '" class="w3-input" type="' + type + '" value="' + escapeHtml(param.default) + '"' + limits + '>';
+        }
+
+        var label = escapeHtml(param.name);
+        if (param.description) {
+            label += '<br><small>' + escapeHtml(param.description) + '</small>';
+        }
+
+        form += '<tr><td class="w3-left-align">' + label + '</td><td>' + input + '</td></tr>';
+    });
+    form += '</table>';
+
+    return form;
+}
+
+function runParamsQuery(id) {
+    var params = runParams[id] || [];
+    var query = [];
+
+    params.forEach(function(param) {
+        var input = $('#run_param_' + id + '_' + param.name);
+        if (input.length > 0) {
+            query.push(encodeURIComponent(param.name) + '=' + encodeURIComponent(input.val()));
+        }
+    });
+
+    return query.length > 0 ? '?' + query.join('&') : '';
+}
+
 function confirmModalOpen(id) {
     dialog({
         id: "confirm", 
         title: "Confirm", 
-        content: 'Are you sure you want to run the <span class="w3-red">[&nbsp;' + id + '&nbsp;]</span> command?', 
+        content: 'Are you sure you want to run the <span class="w3-red">[&nbsp;' + id + '&nbsp;]</span> command?' + runParamsForm(id), 
         cancelBtnText: "NO", 
         okFunc: modalOpen, 
         okFuncParam: id, 
@@ -344,15 +535,41 @@ function modalOpen(id) {
     }
 
     var run = $.ajax({
-        type: "GET",
-        url: ROUTE_RUN.replace("{action}", "exec").replace("{name}", id)
+        type: "POST",
+        url: ROUTE_RUN.replace("{action}", "exec").replace("{name}", id) + runParamsQuery(id),
+        dataType: 'json'
     });
 
-    run.done(function() {
-        startLoopStdout(id);
+    run.done(function(run_response) {
+        if (run_response && run_response.error) {
+            $('#modal_loader_' + id).css("display", "none");
+            $('#modal_content_' + id).css("display", "block");
+            $('#modal_data_' + id).text(run_response.error);
+            return;
+        }
+        runJobs[id] = run_response.id;
+        $('#modal_header_' + id).text('Running: "' + id + '"');
+        $('#modal_cancel_' + id).css("display", "inline-block");
+
+        // The output is streamed into a terminal, if xterm.js is available, otherwise it is polled.
+        if (typeof Terminal !== "undefined" && typeof WebSocket !== "undefined") {
+            startRunStream(id);
+        } else {
+            startLoopStdout(id);
+        }
+    });
+
+    run.fail(function(xhr) {
+        $('#modal_loader_' + id).css("display", "none");
+        $('#modal_content_' + id).css("display", "block");
+        $('#modal_data_' + id).text(xhr.status + " " + xhr.statusText);
+        forbiddenDialog(xhr);
     });
 
-    $('#modal_header_' + id).on('click', function() {
+    $('#modal_header_' + id).off('click').on('click', function() {
+        if (runStreams[id]) {
+            return;
+        }
         if ($(this).attr('data-click-state') == 1) {
             stopLoopStdout();
             autoScroll = false;
@@ -369,7 +586,13 @@ function copyContent(id) {
     var aux = document.createElement("div");
 
     aux.setAttribute("contentEditable", true);
-    aux.innerHTML = document.getElementById('modal_content_' + id).innerHTML;
+    if (runTerminals[id]) {
+        runTerminals[id].selectAll();
+        aux.innerText = runTerminals[id].getSelection();
+        runTerminals[id].clearSelection();
+    } else {
+        aux.innerHTML = document.getElementById('modal_content_' + id).innerHTML;
+    }
     aux.setAttribute("onfocus", "document.execCommand('selectAll',false,null)"); 
     document.body.appendChild(aux);
     aux.focus();
@@ -391,6 +614,7 @@ function modalClose(id) {
         $('#modal_loader_' + id).css("display", "block");
         $('#modal_content_' + id).css("display", "none");
         stopLoopStdout();
+        stopRunStream(id);
         start();
     });
 }
@@ -807,7 +1031,10 @@ function monitor() {
                             <span class="` + serviceStatusClass(status.is_active) + `">[ ` + status.is_active + ` ]</span> ` + service + `
                         </th>
                     </tr>
-                </thead>
+                </thead>`;
+
+                if (can("services")) {
+                    servicesHtml += `
                 <tr>
                     <td class="service-td"><button onclick="confirmSystemCtlAction('start', '` + service + `')" class="service-button w3-button w3-green round-left">start</button></td>
                     <td class="service-td"><button onclick="confirmSystemCtlAction('stop', '` + service + `')" class="service-button w3-button w3-red">stop</button></td>
@@ -817,7 +1044,10 @@ function monitor() {
                     <td class="service-td 3-large" colspan="3">
                         <button onclick="confirmSystemCtlAction('` + enabledBtnAction + `', '` + service + `')" class="service-button w3-button ` + enabledBtnClass + ` round">[ ` + status.is_enabled + ` ] -> ` + enabledBtnAction + ` service</button>
                     </td>
-                </tr>
+                </tr>`;
+                }
+
+                servicesHtml += `
                 <tr>
                     <td class="w3-medium" colspan="3"> </td>
                 </tr>
@@ -834,11 +1064,18 @@ function monitor() {
             for (var id in processInfo) {
                 if (processInfo.hasOwnProperty(id)) {
                     var obj = processInfo[id];
+                    var killAttr = '';
+                    var killMark = '&nbsp;';
+
+                    if (can("kill")) {
+                        killAttr = ` onclick="killProcess('` + obj.pid + `', '` + obj.cmd.replaceAll("'","") + `')"`;
+                        killMark = '&times;';
+                    }
 
                     processHtml += `
                     <tr>
-                        <td id="` + obj.pid + `_kill" onclick="killProcess('` + obj.pid + `', '` + obj.cmd.replaceAll("'","") + `')">
-                            <h4 class="w3-light-gray round-left process-padding-left w3-red">&times;</h4>
+                        <td id="` + obj.pid + `_kill"` + killAttr + `>
+                            <h4 class="w3-light-gray round-left process-padding-left w3-red">` + killMark + `</h4>
                             <b>PID:</b> <br>
                             <b class="w3-text-red">USER:</b> <br>
                             <b>MEM:</b> <br>
@@ -951,6 +1188,7 @@ function monitor() {
             for (var id in runList) {
                 if (runList.hasOwnProperty(id)) {
                     var obj = runList[id];
+                    runParams[id] = obj.params;
                     var toggle = getCookie(id + '_sub');
                     var style = ""
 
@@ -965,10 +1203,18 @@ function monitor() {
                     runHtml += `<div id="` + id + `_container" ` + style + `>`;
 
                     runHtml += `<pre class="w3-medium w3-card w3-panel w3-padding-16 run-list-pre" >`;
-                    runHtml += obj.trim()
+                    runHtml += obj.command.trim()
                     runHtml += `</pre>`;
 
-                    runHtml +=`<button onclick="confirmModalOpen('` + id + `');" class="service-button w3-button w3-red round-left">run</button>`;
+                    if (obj.schedule) {
+                        runHtml += `<p class="w3-small"><i class="fa fa-clock-o fa-fw"></i> ` + escapeHtml(obj.schedule);
+                        runHtml += ` &middot; next: ` + formatRunTime(obj.next_run);
+                        runHtml += ` &middot; last: ` + formatRunTime(obj.last_run) + `</p>`;
+                    }
+
+                    if (can("run")) {
+                        runHtml +=`<button onclick="confirmModalOpen('` + id + `');" class="service-button w3-button w3-red round-left">run</button>`;
+                    }
                     runHtml += `<br><br>`;
 
                     runHtml += `</div>`;
@@ -979,6 +1225,7 @@ function monitor() {
                             <header class="w3-container w3-red"> 
                                 <span onclick="modalClose('` + id + `')" class="w3-button w3-display-topright modal-header-close-font">&times;</span>
                                 <h2 id="modal_header_` + id + `" data-click-state="1" class="modal-header-font">Running: "` + id + `"</h2>
+                                <button id="modal_cancel_` + id + `" onclick="cancelRun('` + id + `')" class="w3-button w3-small w3-white w3-round w3-margin-bottom" style="display: none">cancel</button>
                             </header>
                             <div class="w3-container w3-margin-bottom">
 
@@ -1474,6 +1721,11 @@ function collapseSectionsExceptCpu() {
     $('#run').click();
     $('#terminal').click();
     $('#settings').click();
+    $('#totp').click();
+    $('#sessions').click();
+    $('#tokens').click();
+    $('#users').click();
+    $('#audit').click();
     $('#power').click();
     $('#logout').click();
 }
@@ -1563,6 +1815,19 @@ function stop() {
     console.log("stopped setInterval");
 }
 
+// forbiddenDialog tells the user, that an action is not permitted by the role.
+// It is called only by the actions of the user, the background requests fail silently.
+function forbiddenDialog(xhr) {
+    if (xhr.status == 403) {
+        dialog({
+            id: "info",
+            title: "Forbidden",
+            content: "You do not have permission for this action.",
+            cancelBtnText: "OK"
+        });
+    }
+}
+
 $(document).ready(function() {
     loader();
     logoutIfSessionEnded();
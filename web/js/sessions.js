@@ -0,0 +1,104 @@
+function sessionsRequest(method, url, done) {
+    $.ajax({
+        type: method,
+        url: url,
+        dataType: "json",
+        success: function(response) {
+            if (done) {
+                done(response);
+            }
+            loadSessions();
+        },
+        error: function(xhr) {
+            if (xhr.status == 403) {
+                return;
+            }
+            var message = xhr.responseJSON ? xhr.responseJSON.error : xhr.statusText;
+            dialog({
+                id: "info",
+                title: "Error",
+                content: escapeHtml(message),
+                cancelBtnText: "OK"
+            });
+        }
+    });
+}
+
+function loadSessions() {
+    $.ajax({
+        type: "GET",
+        url: ROUTE_SESSIONS,
+        dataType: "json",
+        success: function(data) {
+            var html = '<table class="w3-table">';
+            data.sessions.forEach(function(session) {
+                html += '<tr>';
+                html += '<td class="w3-left-align">';
+                html += '<i class="fa fa-desktop fa-fw w3-margin-right"></i>' + escapeHtml(session.ip);
+                if (session.current) {
+                    html += ' <span class="w3-small w3-green">&nbsp;current&nbsp;</span>';
+                }
+                html += '<br><small>' + escapeHtml(session.user_agent) + '</small>';
+                html += '</td>';
+                html += '<td><small>last seen: ' + new Date(session.last_seen).toLocaleString() + '</small></td>';
+                if (session.current) {
+                    html += '<td></td>';
+                } else {
+                    html += '<td class="service-td"><button onclick="confirmRevokeSession(\'' + session.id + '\');" class="service-button w3-button w3-red round-left round-right">revoke</button></td>';
+                }
+                html += '</tr>';
+            });
+            html += '</table><p></p>';
+
+            if (data.sessions.length > 1) {
+                html += '<button onclick="confirmRevokeSessions();" class="service-button w3-button w3-red round-left round-right">revoke all others</button><p></p>';
+            }
+
+            $('#sessions_container').html(html);
+        }
+    });
+}
+
+function revokeSession(id) {
+    sessionsRequest("DELETE", ROUTE_SESSION.replace("{id}", encodeURIComponent(id)));
+}
+
+function revokeSessions() {
+    sessionsRequest("DELETE", ROUTE_SESSIONS);
+}
+
+function confirmRevokeSession(id) {
+    dialog({
+        id: "confirm",
+        title: "Confirm",
+        content: 'Are you sure you want to <b class="w3-red">[&nbsp;revoke&nbsp;]</b> this session?',
+        cancelBtnText: "NO",
+        okFunc: revokeSession,
+        okFuncParam: id,
+        okBtnText: "YES"
+    });
+}
+
+function confirmRevokeSessions() {
+    dialog({
+        id: "confirm",
+        title: "Confirm",
+        content: 'Are you sure you want to <b class="w3-red">[&nbsp;revoke&nbsp;]</b> all of your other sessions?',
+        cancelBtnText: "NO",
+        okFunc: revokeSessions,
+        okFuncParam: "",
+        okBtnText: "YES"
+    });
+}
+
+function toggleSessions() {
+    $('#sessions').on('click', function() {
+        if ($(this).attr('data-click-state') == 1) {
+            loadSessions();
+        }
+    });
+}
+
+$(document).ready(function() {
+    toggleSessions();
+});
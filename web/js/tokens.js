@@ -0,0 +1,117 @@
+let tokenScopes = [];
+
+function tokensRequest(method, url, data, done) {
+    $.ajax({
+        type: method,
+        url: url,
+        contentType: "application/json",
+        data: data ? JSON.stringify(data) : undefined,
+        dataType: "json",
+        success: function(response) {
+            if (done) {
+                done(response);
+            }
+            loadTokens();
+        },
+        error: function(xhr) {
+            if (xhr.status == 403) {
+                return;
+            }
+            var message = xhr.responseJSON ? xhr.responseJSON.error : xhr.statusText;
+            dialog({
+                id: "info",
+                title: "Error",
+                content: escapeHtml(message),
+                cancelBtnText: "OK"
+            });
+        }
+    });
+}
+
+function formatTokenTime(value) {
+    if (!value || value.startsWith("0001-")) {
+        return 'never';
+    }
+    return new Date(value).toLocaleString();
+}
+
+function loadTokens() {
+    $.ajax({
+        type: "GET",
+        url: ROUTE_TOKENS,
+        dataType: "json",
+        success: function(data) {
+            tokenScopes = data.scopes;
+
+            var html = '<table class="w3-table">';
+            data.tokens.forEach(function(token) {
+                html += '<tr>';
+                html += '<td class="w3-left-align">';
+                html += '<i class="fa fa-key fa-fw w3-margin-right"></i>' + escapeHtml(token.name);
+                html += ' <span class="w3-small w3-blue">&nbsp;' + escapeHtml(token.scope) + '&nbsp;</span>';
+                html += '</td>';
+                html += '<td><small>expires: ' + formatTokenTime(token.expires_at) + '<br>last used: ' + formatTokenTime(token.last_used) + '</small></td>';
+                html += '<td class="service-td"><button onclick="confirmRevokeToken(\'' + token.id + '\');" class="service-button w3-button w3-red round-left round-right">revoke</button></td>';
+                html += '</tr>';
+            });
+            html += '</table>';
+
+            html += '<h3><i class="fa fa-plus fa-fw w3-margin-right"></i> New token</h3>';
+            html += '<table class="w3-table">';
+            html += '<tr><td><input id="token_new_name" class="w3-input" type="text" placeholder="name"></td>';
+            html += '<td><select id="token_new_scope" class="w3-select">';
+            tokenScopes.forEach(function(scope) {
+                html += '<option value="' + scope + '">' + scope + '</option>';
+            });
+            html += '</select></td>';
+            html += '<td><input id="token_new_expires" class="w3-input" type="number" min="0" value="90" title="expires after days, 0: never"></td>';
+            html += '<td class="service-td"><button onclick="createToken();" class="service-button w3-button w3-red round-left round-right">create</button></td></tr>';
+            html += '</table><p></p>';
+
+            $('#tokens_container').html(html);
+        }
+    });
+}
+
+function createToken() {
+    tokensRequest("POST", ROUTE_TOKENS, {
+        name: $('#token_new_name').val(),
+        scope: $('#token_new_scope').val(),
+        expires_days: parseInt($('#token_new_expires').val() || "0", 10)
+    }, function(response) {
+        dialog({
+            id: "info",
+            title: "API token",
+            content: 'Copy the token now, it is not shown again:<br><br><code>' + escapeHtml(response.token) + '</code>',
+            cancelBtnText: "OK"
+        });
+    });
+}
+
+function revokeToken(id) {
+    tokensRequest("DELETE", ROUTE_TOKEN.replace("{id}", encodeURIComponent(id)));
+}
+
+function confirmRevokeToken(id) {
+    dialog({
+        id: "confirm",
+        title: "Confirm",
+        content: 'Are you sure you want to <b class="w3-red">[&nbsp;revoke&nbsp;]</b> this API token?',
+        cancelBtnText: "NO",
+        okFunc: revokeToken,
+        okFuncParam: id,
+        okBtnText: "YES"
+    });
+}
+
+function toggleTokens() {
+    $('#tokens').on('click', function() {
+        if ($(this).attr('data-click-state') == 1) {
+            loadTokens();
+        }
+    });
+}
+
+$(document).ready(function() {
+    toggleTokens();
+});
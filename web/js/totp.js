@@ -0,0 +1,100 @@
+function totpRequest(method, action, data, done) {
+    $.ajax({
+        type: method,
+        url: ROUTE_TOTP.replace("{action}", action),
+        contentType: "application/json",
+        data: data ? JSON.stringify(data) : undefined,
+        dataType: "json",
+        success: done,
+        error: function(xhr) {
+            if (xhr.status == 403) {
+                return;
+            }
+            var message = xhr.responseJSON ? xhr.responseJSON.error : xhr.statusText;
+            dialog({
+                id: "info",
+                title: "Error",
+                content: escapeHtml(message),
+                cancelBtnText: "OK"
+            });
+        }
+    });
+}
+
+function loadTotp() {
+    totpRequest("GET", "status", null, function(status) {
+        var html = '<p>';
+        if (status.enabled) {
+            html += '<i class="fa fa-check fa-fw w3-margin-right"></i> Two-factor authentication is enabled.';
+            if (status.required) {
+                html += '<br><small>It is required for your role, so it can not be disabled.</small>';
+            }
+            html += '</p>';
+            if (!status.required) {
+                html += '<button onclick="confirmTotpDisable();" class="service-button w3-button w3-red round-left round-right">disable</button>';
+            }
+        } else {
+            html += '<i class="fa fa-times fa-fw w3-margin-right"></i> Two-factor authentication is disabled.</p>';
+            html += '<button onclick="totpSetup();" class="service-button w3-button w3-red round-left round-right">enable</button>';
+        }
+        html += '<p></p>';
+
+        $('#totp_container').html(html);
+    });
+}
+
+function totpSetup() {
+    totpRequest("POST", "setup", null, function(setup) {
+        var html = '<p>Scan the QR code with your authenticator app, or enter the secret manually.</p>';
+        html += '<p><img src="' + setup.qr + '" width="200" height="200"></p>';
+        html += '<p><code>' + escapeHtml(setup.secret) + '</code></p>';
+        html += '<table class="w3-table"><tr>';
+        html += '<td><input id="totp_code" class="w3-input" type="text" placeholder="123456" autocomplete="one-time-code"></td>';
+        html += '<td class="service-td"><button onclick="totpConfirm();" class="service-button w3-button w3-red round-left round-right">confirm</button></td>';
+        html += '</tr></table><p></p>';
+
+        $('#totp_container').html(html);
+    });
+}
+
+function totpConfirm() {
+    totpRequest("POST", "confirm", {code: $('#totp_code').val()}, function(response) {
+        dialog({
+            id: "info",
+            title: "Recovery codes",
+            content: 'Store these codes in a safe place, they will not be shown again:<pre>' + response.recovery_codes.join('\n') + '</pre>',
+            cancelBtnText: "OK"
+        });
+        loadTotp();
+    });
+}
+
+function totpDisable() {
+    totpRequest("POST", "disable", {code: $('#totp_disable_code').val()}, function() {
+        loadTotp();
+    });
+}
+
+function confirmTotpDisable() {
+    dialog({
+        id: "confirm",
+        title: "Disable two-factor authentication",
+        content: 'Enter a code from your authenticator app, or a recovery code:<br><br><input id="totp_disable_code" class="w3-input" type="text">',
+        cancelBtnText: "CANCEL",
+        okFunc: totpDisable,
+        okFuncParam: "",
+        okBtnText: "DISABLE"
+    });
+}
+
+function toggleTotp() {
+    $('#totp').on('click', function() {
+        if ($(this).attr('data-click-state') == 1) {
+            loadTotp();
+        }
+    });
+}
+
+$(document).ready(function() {
+    toggleTotp();
+});
@@ -0,0 +1,180 @@
+let userRoles = [];
+
+function usersRequest(method, url, data, done) {
+    $.ajax({
+        type: method,
+        url: url,
+        contentType: "application/json",
+        data: data ? JSON.stringify(data) : undefined,
+        dataType: "json",
+        success: function(response) {
+            if (done) {
+                done(response);
+            }
+            loadUsers();
+        },
+        error: function(xhr) {
+            if (xhr.status == 403) {
+                return;
+            }
+            var message = xhr.responseJSON ? xhr.responseJSON.error : xhr.statusText;
+            dialog({
+                id: "info",
+                title: "Error",
+                content: escapeHtml(message),
+                cancelBtnText: "OK"
+            });
+        }
+    });
+}
+
+function userRoute(username) {
+    return ROUTE_USER.replace("{username}", encodeURIComponent(username));
+}
+
+function roleSelect(id, selected) {
+    var html = '<select id="' + id + '" class="w3-select">';
+    userRoles.forEach(function(role) {
+        var attr = role == selected ? ' selected' : '';
+        html += '<option value="' + role + '"' + attr + '>' + role + '</option>';
+    });
+    html += '</select>';
+    return html;
+}
+
+function loadUsers() {
+    $.ajax({
+        type: "GET",
+        url: ROUTE_USERS,
+        dataType: "json",
+        success: function(data) {
+            userRoles = data.roles;
+
+            var html = '<table class="w3-table">';
+            data.users.forEach(function(user) {
+                var name = escapeHtml(user.username);
+                var state = user.disabled ? 'enable' : 'disable';
+
+                html += '<tr>';
+                html += '<td class="w3-left-align">';
+                html += '<i class="fa fa-user fa-fw w3-margin-right"></i>' + name;
+                if (user.disabled) {
+                    html += ' <span class="w3-small w3-red">&nbsp;disabled&nbsp;</span>';
+                }
+                if (user.locked) {
+                    html += ' <span class="w3-small w3-red">&nbsp;locked&nbsp;</span>';
+                }
+                if (user.totp) {
+                    html += ' <i class="fa fa-key fa-fw" title="two-factor authentication"></i>';
+                }
+                html += '</td>';
+                html += '<td>' + roleSelect('user_role_' + name, user.role).replace('<select', '<select onchange="setUserRole(\'' + name + '\', this.value)"') + '</td>';
+                html += '<td class="service-td"><button onclick="confirmUserPassword(\'' + name + '\');" class="service-button w3-button w3-red round-left">password</button></td>';
+                html += '<td class="service-td"><button onclick="setUserDisabled(\'' + name + '\', ' + !user.disabled + ');" class="service-button w3-button w3-red">' + state + '</button></td>';
+                if (user.locked) {
+                    html += '<td class="service-td"><button onclick="unlockUser(\'' + name + '\');" class="service-button w3-button w3-red">unlock</button></td>';
+                } else {
+                    html += '<td></td>';
+                }
+                if (user.totp) {
+                    html += '<td class="service-td"><button onclick="confirmResetUserTotp(\'' + name + '\');" class="service-button w3-button w3-red">reset 2fa</button></td>';
+                } else {
+                    html += '<td></td>';
+                }
+                html += '<td class="service-td"><button onclick="confirmDeleteUser(\'' + name + '\');" class="service-button w3-button w3-red round-right">delete</button></td>';
+                html += '</tr>';
+            });
+            html += '</table>';
+
+            html += '<h3><i class="fa fa-user-plus fa-fw w3-margin-right"></i> New user</h3>';
+            html += '<table class="w3-table">';
+            html += '<tr><td><input id="user_new_name" class="w3-input" type="text" placeholder="username"></td>';
+            html += '<td><input id="user_new_password" class="w3-input" type="password" placeholder="password"></td>';
+            html += '<td>' + roleSelect('user_new_role', 'viewer') + '</td>';
+            html += '<td class="service-td"><button onclick="createUser();" class="service-button w3-button w3-red round-left round-right">create</button></td></tr>';
+            html += '</table><p></p>';
+
+            $('#users_container').html(html);
+        }
+    });
+}
+
+function createUser() {
+    usersRequest("POST", ROUTE_USERS, {
+        username: $('#user_new_name').val(),
+        password: $('#user_new_password').val(),
+        role: $('#user_new_role').val()
+    });
+}
+
+function setUserRole(username, role) {
+    usersRequest("PATCH", userRoute(username), {role: role});
+}
+
+function setUserDisabled(username, disabled) {
+    usersRequest("PATCH", userRoute(username), {disabled: disabled});
+}
+
+function setUserPassword(username) {
+    usersRequest("PATCH", userRoute(username), {password: $('#user_password').val()});
+}
+
+function resetUserTotp(username) {
+    usersRequest("PATCH", userRoute(username), {reset_totp: true});
+}
+
+function unlockUser(username) {
+    usersRequest("POST", ROUTE_USER_UNLOCK.replace("{username}", encodeURIComponent(username)));
+}
+
+function deleteUser(username) {
+    usersRequest("DELETE", userRoute(username));
+}
+
+function confirmUserPassword(username) {
+    dialog({
+        id: "confirm",
+        title: "Password",
+        content: 'New password of the <b class="w3-red">[&nbsp;' + username + '&nbsp;]</b> user:<br><br><input id="user_password" class="w3-input" type="password">',
+        cancelBtnText: "CANCEL",
+        okFunc: setUserPassword,
+        okFuncParam: username,
+        okBtnText: "SAVE"
+    });
+}
+
+function confirmResetUserTotp(username) {
+    dialog({
+        id: "confirm",
+        title: "Confirm",
+        content: 'Are you sure you want to <b class="w3-red">[&nbsp;reset&nbsp;]</b> the two-factor authentication of the "' + username + '" user?',
+        cancelBtnText: "NO",
+        okFunc: resetUserTotp,
+        okFuncParam: username,
+        okBtnText: "YES"
+    });
+}
+
+function confirmDeleteUser(username) {
+    dialog({
+        id: "confirm",
+        title: "Confirm",
+        content: 'Are you sure you want to <b class="w3-red">[&nbsp;delete&nbsp;]</b> the "' + username + '" user?',
+        cancelBtnText: "NO",
+        okFunc: deleteUser,
+        okFuncParam: username,
+        okBtnText: "YES"
+    });
+}
+
+function toggleUsers() {
+    $('#users').on('click', function() {
+        if ($(this).attr('data-click-state') == 1) {
+            loadUsers();
+        }
+    });
+}
+
+$(document).ready(function() {
+    toggleUsers();
+});
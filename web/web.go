@@ -0,0 +1,9 @@
+// Package web embeds the default sources of the web interface into the binaries.
+package web
+
+import "embed"
+
+// Assets holds the html, css, js, img and fonts directories.
+//
+//go:embed css fonts html img js
+var Assets embed.FS